@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errBadPrecondition = errors.New("If-Match must hold a single entity tag")
+
+// etag formats a resource version as a strong entity tag.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag exposes the version of the resource in the response.
+func setETag(ctx *gin.Context, version int) {
+	ctx.Header("ETag", etag(version))
+}
+
+// ifMatchVersion reads the If-Match header. It returns 0 when the header is
+// absent or "*", meaning any version. An entity tag that is not one of ours
+// yields -1, which never matches a stored version.
+func ifMatchVersion(ctx *gin.Context) (int, error) {
+	h := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if h == "" || h == "*" {
+		return 0, nil
+	}
+	if strings.Contains(h, ",") {
+		return 0, errBadPrecondition
+	}
+
+	// Our tags are strong, but accept a weak one for lenient clients.
+	h = strings.TrimPrefix(h, "W/")
+	if len(h) < 2 || h[0] != '"' || h[len(h)-1] != '"' {
+		return 0, errBadPrecondition
+	}
+	version, err := strconv.Atoi(h[1 : len(h)-1])
+	if err != nil || version <= 0 {
+		return -1, nil
+	}
+	return version, nil
+}
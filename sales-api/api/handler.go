@@ -1,9 +1,14 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"sales-api/internal/sale"
+	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 
@@ -13,48 +18,151 @@ import (
 // handler holds the user service and implements HTTP handlers for user CRUD.
 type handler struct {
 	saleService *sale.Service
+	outbox      *sale.Dispatcher
 	logger      *zap.Logger
+
+	// defaultCurrency is used when POST /sales omits the currency.
+	defaultCurrency string
+
+	// usersBreaker guards the calls to users-api.
+	usersBreaker *sale.CircuitBreaker
+
+	// usersCache remembers users-api lookups.
+	usersCache *sale.CachingUserClient
+
+	// webhooks manages the /webhooks subscriptions.
+	webhooks *sale.WebhookService
+
+	// stream feeds GET /sales/stream.
+	stream *sale.Broadcaster
+
+	// done is closed when the server shuts down, ending open streams.
+	done <-chan struct{}
 }
 
+// Headers of the idempotent POST /sales.
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// handleCreateSale handles POST /sales
+// A retry sent with the same Idempotency-Key gets the original sale back
+// instead of creating a new one.
 func (h *handler) handleCreateSale(ctx *gin.Context) {
 	// request payload
+	// amount may be a JSON string or number; it is never parsed as a float.
 	var req struct {
-		UserID string  `json:"user_id"`
-		Amount float32 `json:"amount"`
+		UserID   string      `json:"user_id"`
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
 	}
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Currency == "" {
+		req.Currency = h.defaultCurrency
+	}
+	amount, err := sale.ParseMoney(req.Amount.String(), req.Currency)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	u := &sale.Sale{
 		UserID: req.UserID,
-		Amount: req.Amount,
+		Amount: amount,
 	}
-	err := h.saleService.CreateSale(u)
+	key := ctx.GetHeader(idempotencyKeyHeader)
+	replayed, err := h.saleService.CreateSaleIdempotent(ctx.Request.Context(), key, u)
 
 	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, sale.ErrIdempotencyKeyReused) {
+			h.logger.Warn("idempotency key reused", zap.String("key", key))
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, sale.ErrIdempotencyInProgress) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		var notActive *sale.UserNotActiveError
+		if errors.As(err, &notActive) {
+			body := gin.H{"error": err.Error(), "user_status": notActive.Status}
+			if notActive.Reason != "" {
+				body["status_reason"] = notActive.Reason
+			}
+			if !notActive.SuspendedUntil.IsZero() {
+				body["suspended_until"] = notActive.SuspendedUntil
+			}
+			ctx.JSON(http.StatusForbidden, body)
+			return
+		}
 		if errors.Is(err, sale.ErrInvalidInput) {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		} else if errors.Is(err, sale.ErrNotUserFound) {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
+		} else if errors.Is(err, sale.ErrTryingToGetUser) {
+			h.logger.Warn("users-api unavailable", zap.Error(err))
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
 		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	h.logger.Info("sale created", zap.Any("sale", u))
+	if replayed {
+		h.logger.Info("sale replayed", zap.String("key", key), zap.String("id", u.ID))
+		ctx.Header(idempotentReplayedHeader, "true")
+	} else {
+		h.logger.Info("sale created", zap.Any("sale", u))
+	}
+	setETag(ctx, u.Version)
+	if u.Status == sale.StatusPendingVerification {
+		// Accepted, but the buyer is still to be checked.
+		ctx.JSON(http.StatusAccepted, u)
+		return
+	}
 	ctx.JSON(http.StatusCreated, u)
 }
 
+// handleReadSale handles GET /sales
+// Filters as described in sale.ParseSaleFilter, pages with ?limit and
+// ?cursor, the next_cursor of the previous page, and sorts with
+// ?sort=created_at|updated_at|amount, descending if prefixed with "-".
+// ?expand=user embeds the buyer of each sale.
 func (h *handler) handleReadSale(ctx *gin.Context) {
-	userID := ctx.Query("user_id")
-	status := ctx.Query("status")
+	expandUser, ok := parseExpand(ctx)
+	if !ok {
+		return
+	}
+	filter, err := sale.ParseSaleFilter(ctx.Request.URL.Query(), h.defaultCurrency)
+	if h.handleFieldErrors(ctx, err) {
+		return
+	}
+
+	page := sale.PageRequest{Cursor: ctx.Query("cursor")}
+	page.Sort, page.Desc = strings.CutPrefix(ctx.Query("sort"), "-")
+	if v := ctx.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be an integer"})
+			return
+		}
+		page.Limit = n
+	}
 
-	u, err := h.saleService.GetSaleByUserAndStatus(userID, status)
+	u, err := h.saleService.ListSales(ctx.Request.Context(), filter, page)
 	if err != nil {
+		if h.handleContextError(ctx, err) || h.handleFieldErrors(ctx, err) {
+			return
+		}
 		if errors.Is(err, sale.ErrInvalidInput) {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -64,10 +172,128 @@ func (h *handler) handleReadSale(ctx *gin.Context) {
 		return
 	}
 
+	if expandUser {
+		expanded, err := h.saleService.ExpandInforme(ctx.Request.Context(), u)
+		if err != nil {
+			h.handleExpandError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, expanded)
+		return
+	}
+
 	h.logger.Info("get user succeed", zap.Any("user", u))
 	ctx.JSON(http.StatusOK, u)
 }
 
+// handleGetSale handles GET /sales/:id
+// With ?version=N it answers the sale as it was at that version, and with
+// ?expand=user it embeds the buyer's profile.
+func (h *handler) handleGetSale(ctx *gin.Context) {
+	id := ctx.Param("id")
+	expandUser, ok := parseExpand(ctx)
+	if !ok {
+		return
+	}
+
+	var (
+		s   *sale.Sale
+		err error
+	)
+	if v := ctx.Query("version"); v != "" {
+		version, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "version must be an integer"})
+			return
+		}
+		s, err = h.saleService.GetSaleAtVersion(ctx.Request.Context(), id, version)
+	} else {
+		s, err = h.saleService.GetSale(ctx.Request.Context(), id)
+	}
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, sale.ErrNotFoundSale) || errors.Is(err, sale.ErrVersionNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("error trying to get sale", zap.Error(err), zap.String("id", id))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(ctx, s.Version)
+	if expandUser {
+		expanded, err := h.saleService.ExpandUsers(ctx.Request.Context(), *s)
+		if err != nil {
+			h.handleExpandError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, expanded[0])
+		return
+	}
+	ctx.JSON(http.StatusOK, s)
+}
+
+// parseExpand reads ?expand, a comma separated list of what to embed in
+// sales, and reports whether it asks for the buyer. It answers 400 and
+// returns ok false if it names anything else.
+func parseExpand(ctx *gin.Context) (user, ok bool) {
+	v := ctx.Query("expand")
+	if v == "" {
+		return false, true
+	}
+	for _, field := range strings.Split(v, ",") {
+		switch strings.TrimSpace(field) {
+		case "user":
+			user = true
+		case "":
+		default:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("cannot expand %q", field)})
+			return false, false
+		}
+	}
+	return user, true
+}
+
+// handleExpandError answers the error of embedding buyers in sales, which
+// can only be ctx running out.
+func (h *handler) handleExpandError(ctx *gin.Context, err error) {
+	if h.handleContextError(ctx, err) {
+		return
+	}
+	h.logger.Error("error trying to expand sales", zap.Error(err))
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// handleSaleHistory handles GET /sales/:id/history
+func (h *handler) handleSaleHistory(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	history, err := h.saleService.GetSaleHistory(ctx.Request.Context(), id)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, sale.ErrNotFoundSale) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("error trying to get sale history", zap.Error(err), zap.String("id", id))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"sale_id": id, "history": history})
+}
+
+// handleStatuses handles GET /sales/statuses
+// Publishes the sale lifecycle so clients know which PATCHes are valid.
+func (h *handler) handleStatuses(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"states": h.saleService.StateMachine().Describe()})
+}
+
 // handleUpdate handles PUT /users/:id
 func (h *handler) handleUpdateSale(ctx *gin.Context) {
 	id := ctx.Param("id")
@@ -78,8 +304,17 @@ func (h *handler) handleUpdateSale(ctx *gin.Context) {
 		return
 	}
 
-	u, err := h.saleService.UpdateSale(id, fields)
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, err := h.saleService.UpdateSale(ctx.Request.Context(), id, fields, version)
 	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
 		if errors.Is(err, sale.ErrNotFoundSale) {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
@@ -92,10 +327,272 @@ func (h *handler) handleUpdateSale(ctx *gin.Context) {
 			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 			return
 		}
+		if errors.Is(err, sale.ErrVersionConflict) {
+			status := http.StatusConflict
+			if version != 0 {
+				status = http.StatusPreconditionFailed
+			}
+			ctx.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
 
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(ctx, u.Version)
 	ctx.JSON(http.StatusOK, u)
 }
+
+// handleHealth handles GET /health
+// The service stays up while users-api is down, so an open breaker reports
+// "degraded" rather than failing the check.
+func (h *handler) handleHealth(ctx *gin.Context) {
+	users := h.usersBreaker.State()
+	status := "ok"
+	if users.State != sale.BreakerClosed {
+		status = "degraded"
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": status,
+		"dependencies": gin.H{
+			"users_api": users,
+		},
+	})
+}
+
+// handleUserCacheStats handles GET /admin/cache/users
+func (h *handler) handleUserCacheStats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.usersCache.Stats())
+}
+
+// handleInvalidateUser handles DELETE /admin/cache/users/:id
+// Lets users-api, or an operator, drop a user that changed or was deleted.
+func (h *handler) handleInvalidateUser(ctx *gin.Context) {
+	id := ctx.Param("id")
+	h.usersCache.Invalidate(id)
+	h.logger.Info("user cache entry invalidated", zap.String("user_id", id))
+	ctx.Status(http.StatusNoContent)
+}
+
+// handleOutbox handles GET /admin/outbox
+// Accepts ?status=pending|delivered|dead and ?limit=N.
+func (h *handler) handleOutbox(ctx *gin.Context) {
+	limit := 0
+	if v := ctx.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative integer"})
+			return
+		}
+		limit = n
+	}
+
+	entries, err := h.outbox.Entries(ctx.Request.Context(), ctx.Query("status"), limit)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, sale.ErrInvalidInput) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "unknown outbox status"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"results": entries})
+}
+
+// handleOutboxEntry handles GET /admin/outbox/:seq
+func (h *handler) handleOutboxEntry(ctx *gin.Context) {
+	h.withOutboxEntry(ctx, h.outbox.Entry)
+}
+
+// handleReplayOutboxEntry handles POST /admin/outbox/:seq/replay
+// Queues the entry for delivery again, e.g. a dead one once its receiver is
+// back.
+func (h *handler) handleReplayOutboxEntry(ctx *gin.Context) {
+	h.withOutboxEntry(ctx, h.outbox.Replay)
+}
+
+// withOutboxEntry answers with the entry fn returns for the :seq parameter.
+func (h *handler) withOutboxEntry(ctx *gin.Context, fn func(context.Context, int64) (*sale.OutboxEntry, error)) {
+	seq, err := strconv.ParseInt(ctx.Param("seq"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "seq must be an integer"})
+		return
+	}
+
+	entry, err := fn(ctx.Request.Context(), seq)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, sale.ErrOutboxEntryNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, entry)
+}
+
+// handleCreateWebhook handles POST /webhooks
+// The answer is the only one that shows the secret used to sign deliveries.
+func (h *handler) handleCreateWebhook(ctx *gin.Context) {
+	var w sale.Webhook
+	if err := ctx.ShouldBindJSON(&w); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.webhooks.CreateWebhook(ctx.Request.Context(), &w); err != nil {
+		h.handleWebhookError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, w)
+}
+
+// handleListWebhooks handles GET /webhooks
+func (h *handler) handleListWebhooks(ctx *gin.Context) {
+	webhooks, err := h.webhooks.ListWebhooks(ctx.Request.Context())
+	if err != nil {
+		h.handleWebhookError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"results": webhooks})
+}
+
+// handleGetWebhook handles GET /webhooks/:id
+func (h *handler) handleGetWebhook(ctx *gin.Context) {
+	w, err := h.webhooks.GetWebhook(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.handleWebhookError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, w)
+}
+
+// handleUpdateWebhook handles PATCH /webhooks/:id
+func (h *handler) handleUpdateWebhook(ctx *gin.Context) {
+	var fields sale.UpdateFieldsWebhook
+	if err := ctx.ShouldBindJSON(&fields); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	w, err := h.webhooks.UpdateWebhook(ctx.Request.Context(), ctx.Param("id"), &fields)
+	if err != nil {
+		h.handleWebhookError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, w)
+}
+
+// handleDeleteWebhook handles DELETE /webhooks/:id
+func (h *handler) handleDeleteWebhook(ctx *gin.Context) {
+	if err := h.webhooks.DeleteWebhook(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		h.handleWebhookError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// handleWebhookDeliveries handles GET /webhooks/:id/deliveries
+// Lists the latest deliveries first; ?limit=N bounds them (50 by default).
+func (h *handler) handleWebhookDeliveries(ctx *gin.Context) {
+	limit := 50
+	if v := ctx.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := h.webhooks.Deliveries(ctx.Request.Context(), ctx.Param("id"), limit)
+	if err != nil {
+		h.handleWebhookError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"results": deliveries})
+}
+
+// handleWebhookError answers a failed /webhooks request.
+func (h *handler) handleWebhookError(ctx *gin.Context, err error) {
+	if h.handleContextError(ctx, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, sale.ErrWebhookNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, sale.ErrInvalidInput):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("webhook request failed", zap.Error(err), zap.String("path", ctx.FullPath()))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// handleFieldErrors answers 400 listing every invalid field when err is a
+// sale.FieldErrors. It reports whether err was handled.
+func (h *handler) handleFieldErrors(ctx *gin.Context, err error) bool {
+	var fields sale.FieldErrors
+	if !errors.As(err, &fields) {
+		return false
+	}
+	ctx.JSON(http.StatusBadRequest, gin.H{"error": sale.ErrInvalidInput.Error(), "fields": fields})
+	return true
+}
+
+// statusClientClosedRequest is the non-standard status logged when the
+// client goes away before we answer.
+const statusClientClosedRequest = 499
+
+// handleContextError answers requests whose context ended before the service
+// finished: 504 on timeout, and no body at all if the client disconnected.
+// It reports whether err was handled.
+func (h *handler) handleContextError(ctx *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, sale.ErrTimeout):
+		h.logger.Warn("request timed out", zap.String("path", ctx.FullPath()))
+		ctx.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
+		return true
+	case errors.Is(err, context.Canceled):
+		h.logger.Info("request cancelled by client", zap.String("path", ctx.FullPath()))
+		ctx.AbortWithStatus(statusClientClosedRequest)
+		return true
+	}
+	return false
+}
+
+// handleUserEvent handles POST /events/users
+// users-api retries anything but a 2xx, so failures answer 500 and are
+// delivered again; handling an event twice is harmless.
+func (h *handler) handleUserEvent(ctx *gin.Context) {
+	var event sale.UserEvent
+	if err := ctx.ShouldBindJSON(&event); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if event.ID == "" || event.Type == "" || event.UserID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "id, type and user_id are required"})
+		return
+	}
+
+	cancelled, err := h.saleService.HandleUserEvent(ctx.Request.Context(), event)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		h.logger.Error("handling user event", zap.Error(err), zap.String("event_id", event.ID))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"cancelled": cancelled})
+}
@@ -1,35 +1,257 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"sales-api/internal/sale"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
-// InitRoutes registers all user CRUD endpoints on the given Gin engine.
+// Storage backends accepted in Config.Storage.
+const (
+	StorageMemory = "memory"
+	StorageSQLite = "sqlite"
+)
+
+// Config holds the settings needed to wire the sales API.
+type Config struct {
+	// UserAPIURL is the base URL of users-api.
+	UserAPIURL string
+
+	// Storage selects the sale.Storage backend: StorageMemory (default) or StorageSQLite.
+	Storage string
+
+	// SQLitePath is the database file used when Storage is StorageSQLite.
+	SQLitePath string
+
+	// DefaultCurrency is the ISO-4217 code assumed when POST /sales has no
+	// currency. Defaults to ARS.
+	DefaultCurrency string
+
+	// Approval holds the thresholds of the approval rules engine. The zero
+	// value means sale.DefaultRulesConfig.
+	Approval sale.RulesConfig
+
+	// RequestTimeout bounds how long a request may run before it is answered
+	// with 504. Zero means no deadline.
+	RequestTimeout time.Duration
+
+	// UserClient tunes the users-api client: timeouts, retries and circuit
+	// breaker. The zero value means sale.DefaultUserClientConfig. Its BaseURL
+	// is always UserAPIURL.
+	UserClient sale.UserClientConfig
+
+	// UserCache sizes the cache of users-api lookups. Zero fields mean
+	// sale.DefaultUserCacheConfig.
+	UserCache sale.UserCacheConfig
+
+	// DegradedMode accepts sales as pending_verification while users-api is
+	// unreachable, and starts a reconciler that verifies them every
+	// ReconcileInterval (sale.DefaultReconcileInterval if zero).
+	DegradedMode      bool
+	ReconcileInterval time.Duration
+
+	// IdempotencyTTL is how long the response to a POST /sales sent with an
+	// Idempotency-Key is kept for replays. Zero means
+	// sale.DefaultIdempotencyTTL.
+	IdempotencyTTL time.Duration
+
+	// EventPublishers receive the sale events delivered from the outbox.
+	EventPublishers []sale.Publisher
+
+	// Outbox tunes the delivery of sale events. Zero fields mean
+	// sale.DefaultDispatcherConfig.
+	Outbox sale.DispatcherConfig
+
+	// Webhooks tunes the delivery of sale events to the webhooks registered
+	// under /webhooks. Zero fields mean sale.DefaultWebhookConfig.
+	Webhooks sale.WebhookConfig
+
+	// StreamReplayBuffer is how many recent changes GET /sales/stream keeps
+	// for clients resuming with Last-Event-ID. Zero means
+	// sale.DefaultReplayBuffer.
+	StreamReplayBuffer int
+}
+
+// InitRoutes registers all sale endpoints on the given Gin engine.
 // It initializes the storage, service, and handler, then binds each HTTP
-// method and path to the appropriate handler function.
-func InitRoutes(e *gin.Engine, userAPIURL string) {
+// method and path to the appropriate handler function. The background
+// workers, and any open sale stream, run until ctx is done.
+func InitRoutes(ctx context.Context, e *gin.Engine, cfg Config) error {
+	// Check cfg before opening the storage or starting any worker, so a bad
+	// setting leaves nothing behind.
+	currency := cfg.DefaultCurrency
+	if currency == "" {
+		currency = "ARS"
+	}
+	if !sale.IsCurrency(currency) {
+		return fmt.Errorf("unknown default currency %q", currency)
+	}
+
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	storage := sale.NewLocalStorage()
-	saleService := sale.NewService(storage, logger, userAPIURL)
+	storage, err := newStorage(cfg)
+	if err != nil {
+		return err
+	}
+	rules := cfg.Approval
+	if rules == (sale.RulesConfig{}) {
+		rules = sale.DefaultRulesConfig()
+	}
+	ttl := cfg.IdempotencyTTL
+	if ttl == 0 {
+		ttl = sale.DefaultIdempotencyTTL
+	}
+	clientCfg := cfg.UserClient
+	if clientCfg == (sale.UserClientConfig{}) {
+		clientCfg = sale.DefaultUserClientConfig()
+	}
+	clientCfg.BaseURL = cfg.UserAPIURL
+	users := sale.NewHTTPUserClient(clientCfg, logger)
+	usersCache := sale.NewCachingUserClient(users, cfg.UserCache)
+	stream := sale.NewBroadcaster(cfg.StreamReplayBuffer)
+
+	opts := []sale.Option{
+		sale.WithUserClient(usersCache),
+		sale.WithBroadcaster(stream),
+		sale.WithApprovalPolicy(sale.NewRulesEngine(rules)),
+		sale.WithIdempotency(newIdempotencyStore(storage), ttl),
+	}
+	if cfg.DegradedMode {
+		opts = append(opts, sale.WithDegradedMode())
+	}
+	saleService := sale.NewService(storage, logger, cfg.UserAPIURL, opts...)
+
+	if cfg.DegradedMode {
+		interval := cfg.ReconcileInterval
+		if interval == 0 {
+			interval = sale.DefaultReconcileInterval
+		}
+		go sale.NewReconciler(saleService, interval).Run(ctx)
+	}
+
+	webhooks := sale.NewWebhookService(newWebhookStore(storage), cfg.Webhooks, logger)
+	go webhooks.Run(ctx)
+
+	publishers := append([]sale.Publisher{webhooks}, cfg.EventPublishers...)
+	outbox := sale.NewDispatcher(storage, cfg.Outbox, logger, publishers...)
+	go outbox.Run(ctx)
 
 	h := handler{
-		saleService: saleService,
-		logger:      logger,
+		saleService:     saleService,
+		outbox:          outbox,
+		webhooks:        webhooks,
+		stream:          stream,
+		done:            ctx.Done(),
+		logger:          logger,
+		defaultCurrency: currency,
+		usersBreaker:    users.Breaker(),
+		usersCache:      usersCache,
 	}
 
+	// The stream stays open for as long as the client listens, so it is
+	// registered before the request timeout applies.
+	e.GET("/sales/stream", h.handleSaleStream)
+
+	if cfg.RequestTimeout > 0 {
+		e.Use(withTimeout(cfg.RequestTimeout))
+	}
+	e.Use(withActor())
+
 	e.POST("/sales", h.handleCreateSale)
 	e.GET("/sales", h.handleReadSale)
+	e.GET("/sales/statuses", h.handleStatuses)
+	e.GET("/sales/:id", h.handleGetSale)
+	e.GET("/sales/:id/history", h.handleSaleHistory)
 	e.PATCH("/sales/:id", h.handleUpdateSale)
 
+	e.POST("/events/users", h.handleUserEvent)
+
+	e.POST("/webhooks", h.handleCreateWebhook)
+	e.GET("/webhooks", h.handleListWebhooks)
+	e.GET("/webhooks/:id", h.handleGetWebhook)
+	e.PATCH("/webhooks/:id", h.handleUpdateWebhook)
+	e.DELETE("/webhooks/:id", h.handleDeleteWebhook)
+	e.GET("/webhooks/:id/deliveries", h.handleWebhookDeliveries)
+
+	e.GET("/health", h.handleHealth)
+	e.GET("/admin/cache/users", h.handleUserCacheStats)
+	e.DELETE("/admin/cache/users/:id", h.handleInvalidateUser)
+	e.GET("/admin/outbox", h.handleOutbox)
+	e.GET("/admin/outbox/:seq", h.handleOutboxEntry)
+	e.POST("/admin/outbox/:seq/replay", h.handleReplayOutboxEntry)
+
 	e.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "pong",
 		})
 	})
+
+	return nil
+}
+
+// newStorage builds the sale.Storage selected by cfg.
+func newStorage(cfg Config) (sale.Storage, error) {
+	switch cfg.Storage {
+	case "", StorageMemory:
+		return sale.NewLocalStorage(), nil
+	case StorageSQLite:
+		if cfg.SQLitePath == "" {
+			return nil, fmt.Errorf("sqlite storage requires a database path")
+		}
+		return sale.NewSQLiteStorage(cfg.SQLitePath)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage)
+	}
+}
+
+// newIdempotencyStore keeps idempotency keys next to the sales, so they
+// survive a restart whenever the sales do.
+func newIdempotencyStore(storage sale.Storage) sale.IdempotencyStore {
+	if store, ok := storage.(sale.IdempotencyStore); ok {
+		return store
+	}
+	return sale.NewLocalIdempotencyStore()
+}
+
+// newWebhookStore keeps webhooks next to the sales, for the same reason as
+// newIdempotencyStore.
+func newWebhookStore(storage sale.Storage) sale.WebhookStore {
+	if store, ok := storage.(sale.WebhookStore); ok {
+		return store
+	}
+	return sale.NewLocalWebhookStore()
+}
+
+// withTimeout attaches a deadline to every request context, so the service
+// and storage stop working once it expires.
+func withTimeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// actorHeader names who is making the request. There is no authentication
+// yet, so it is trusted as sent; without it changes are recorded as
+// sale.ActorSystem.
+const actorHeader = "X-Actor"
+
+// withActor passes the caller named in actorHeader down to the service, to
+// be recorded in the sale history.
+func withActor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if actor := c.GetHeader(actorHeader); actor != "" {
+			c.Request = c.Request.WithContext(sale.WithActor(c.Request.Context(), actor))
+		}
+		c.Next()
+	}
 }
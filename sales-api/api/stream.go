@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sales-api/internal/sale"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// streamHeartbeat is how often an idle stream gets a comment, so proxies
+// and clients do not take it for dead.
+const streamHeartbeat = 15 * time.Second
+
+// eventResync tells a resuming client that some events were lost and it
+// should reload the sales it shows.
+const eventResync = "resync"
+
+// handleSaleStream handles GET /sales/stream
+// Streams sale changes as Server-Sent Events, optionally only those of
+// ?user_id and leaving the sale in ?status. A client reconnecting with
+// Last-Event-ID first gets the events it missed.
+func (h *handler) handleSaleStream(ctx *gin.Context) {
+	filter := sale.StreamFilter{
+		UserID: ctx.Query("user_id"),
+		Status: ctx.Query("status"),
+	}
+	if filter.Status != "" && !h.saleService.StateMachine().IsState(filter.Status) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": sale.ErrInvalidInput.Error()})
+		return
+	}
+
+	var lastSeq uint64
+	if v := ctx.GetHeader("Last-Event-ID"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be a non-negative integer"})
+			return
+		}
+		lastSeq = n
+	}
+
+	sub, replay, complete := h.stream.Subscribe(filter, lastSeq)
+	defer h.stream.Unsubscribe(sub)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+
+	w := ctx.Writer
+	if !complete {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventResync)
+	}
+	for _, ev := range replay {
+		if err := writeStreamEvent(w, ev); err != nil {
+			return
+		}
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-h.done:
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				h.logger.Info("dropped slow sale stream", zap.String("user_id", filter.UserID), zap.String("status", filter.Status))
+				return
+			}
+			if err := writeStreamEvent(w, ev); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		w.Flush()
+	}
+}
+
+// writeStreamEvent writes ev as one Server-Sent Event, with its sequence
+// number as id so the client can resume after it.
+func writeStreamEvent(w io.Writer, ev sale.StreamEvent) error {
+	data, err := json.Marshal(ev.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Event.Type, data)
+	return err
+}
@@ -0,0 +1,165 @@
+package sale
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ApprovalPolicy decides the initial status of a new sale.
+type ApprovalPolicy interface {
+	Decide(ctx context.Context, in ApprovalInput) (Decision, error)
+}
+
+// ApprovalInput is everything a policy may look at to decide.
+type ApprovalInput struct {
+	Sale *Sale
+
+	// Buyer is the user returned by users-api.
+	Buyer *User
+
+	// History summarizes the buyer's previous sales.
+	History metadata
+
+	Now time.Time
+}
+
+// Decision is the outcome of an ApprovalPolicy, stored on the sale.
+type Decision struct {
+	Status string `json:"status"`
+	Rule   string `json:"rule"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Rule is one step of a RulesEngine. Evaluate reports whether the rule fired
+// and, if so, the decision it makes.
+type Rule struct {
+	Name     string
+	Evaluate func(in ApprovalInput) (Decision, bool)
+}
+
+// RulesConfig holds the thresholds used by the default rules.
+type RulesConfig struct {
+	// RejectAboveAmount rejects sales with a larger amount in the same
+	// currency.
+	RejectAboveAmount Money
+
+	// MaxRejectionRatio rejects buyers whose past sales were rejected more
+	// often than this ratio (0..1), once they have at least MinHistory sales.
+	MaxRejectionRatio float64
+	MinHistory        int
+
+	// MinUserAge sends sales from younger accounts to manual review.
+	MinUserAge time.Duration
+
+	// AutoApproveMaxAmount approves sales up to this amount; larger ones, and
+	// sales in any other currency, are left pending for review.
+	AutoApproveMaxAmount Money
+}
+
+// DefaultRulesConfig returns the thresholds used when none are configured.
+func DefaultRulesConfig() RulesConfig {
+	return RulesConfig{
+		RejectAboveAmount:    MustParseMoney("100000", "ARS"),
+		MaxRejectionRatio:    0.5,
+		MinHistory:           5,
+		MinUserAge:           24 * time.Hour,
+		AutoApproveMaxAmount: MustParseMoney("1000", "ARS"),
+	}
+}
+
+// RulesEngine is an ApprovalPolicy that evaluates Rules in order and keeps
+// the decision of the first one that fires, or Fallback if none does.
+type RulesEngine struct {
+	Rules    []Rule
+	Fallback Decision
+}
+
+// NewRulesEngine builds the default rules engine. In order:
+//
+//   - amount_over_limit: amount above RejectAboveAmount -> rejected
+//   - high_rejection_ratio: too many past rejections -> rejected
+//   - new_account: account younger than MinUserAge, or of unknown age -> pending
+//   - amount_needs_review: amount above AutoApproveMaxAmount -> pending
+//   - otherwise auto_approve -> approved
+func NewRulesEngine(cfg RulesConfig) *RulesEngine {
+	return &RulesEngine{
+		Rules: []Rule{
+			{
+				Name: "amount_over_limit",
+				Evaluate: func(in ApprovalInput) (Decision, bool) {
+					if cmp, err := in.Sale.Amount.Cmp(cfg.RejectAboveAmount); err != nil || cmp <= 0 {
+						return Decision{}, false
+					}
+					return Decision{
+						Status: StatusRejected,
+						Reason: "amount above " + cfg.RejectAboveAmount.String(),
+					}, true
+				},
+			},
+			{
+				Name: "high_rejection_ratio",
+				Evaluate: func(in ApprovalInput) (Decision, bool) {
+					if in.History.Quantity == 0 || in.History.Quantity < cfg.MinHistory {
+						return Decision{}, false
+					}
+					ratio := float64(in.History.Rejected) / float64(in.History.Quantity)
+					if ratio <= cfg.MaxRejectionRatio {
+						return Decision{}, false
+					}
+					return Decision{
+						Status: StatusRejected,
+						Reason: fmt.Sprintf("%.0f%% of past sales rejected", ratio*100),
+					}, true
+				},
+			},
+			{
+				Name: "new_account",
+				Evaluate: func(in ApprovalInput) (Decision, bool) {
+					if in.Buyer != nil && !in.Buyer.CreatedAt.IsZero() &&
+						in.Now.Sub(in.Buyer.CreatedAt) >= cfg.MinUserAge {
+						return Decision{}, false
+					}
+					return Decision{
+						Status: StatusPending,
+						Reason: fmt.Sprintf("account younger than %s", cfg.MinUserAge),
+					}, true
+				},
+			},
+			{
+				Name: "amount_needs_review",
+				Evaluate: func(in ApprovalInput) (Decision, bool) {
+					cmp, err := in.Sale.Amount.Cmp(cfg.AutoApproveMaxAmount)
+					if err != nil {
+						return Decision{
+							Status: StatusPending,
+							Reason: "no auto-approval limit for " + in.Sale.Amount.Currency,
+						}, true
+					}
+					if cmp <= 0 {
+						return Decision{}, false
+					}
+					return Decision{
+						Status: StatusPending,
+						Reason: "amount above " + cfg.AutoApproveMaxAmount.String(),
+					}, true
+				},
+			},
+		},
+		Fallback: Decision{Status: StatusApproved, Rule: "auto_approve"},
+	}
+}
+
+// Decide implements ApprovalPolicy.
+func (e *RulesEngine) Decide(ctx context.Context, in ApprovalInput) (Decision, error) {
+	for _, rule := range e.Rules {
+		if err := ctx.Err(); err != nil {
+			return Decision{}, err
+		}
+		if d, fired := rule.Evaluate(in); fired {
+			d.Rule = rule.Name
+			return d, nil
+		}
+	}
+	return e.Fallback, nil
+}
@@ -0,0 +1,83 @@
+package sale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRulesEngine_Decide(t *testing.T) {
+	now := time.Now()
+	oldUser := &User{ID: "1", CreatedAt: now.Add(-30 * 24 * time.Hour)}
+	engine := NewRulesEngine(DefaultRulesConfig())
+
+	tests := []struct {
+		name     string
+		in       ApprovalInput
+		wantRule string
+		want     string
+	}{
+		{
+			name:     "small amount from an old account",
+			in:       ApprovalInput{Sale: &Sale{Amount: MustParseMoney("100", "ARS")}, Buyer: oldUser},
+			wantRule: "auto_approve",
+			want:     StatusApproved,
+		},
+		{
+			name:     "amount over the hard limit",
+			in:       ApprovalInput{Sale: &Sale{Amount: MustParseMoney("200000", "ARS")}, Buyer: oldUser},
+			wantRule: "amount_over_limit",
+			want:     StatusRejected,
+		},
+		{
+			name: "buyer with many rejections",
+			in: ApprovalInput{
+				Sale:    &Sale{Amount: MustParseMoney("100", "ARS")},
+				Buyer:   oldUser,
+				History: metadata{Quantity: 10, Rejected: 6, Approved: 4},
+			},
+			wantRule: "high_rejection_ratio",
+			want:     StatusRejected,
+		},
+		{
+			name: "few sales are not enough to judge",
+			in: ApprovalInput{
+				Sale:    &Sale{Amount: MustParseMoney("100", "ARS")},
+				Buyer:   oldUser,
+				History: metadata{Quantity: 2, Rejected: 2},
+			},
+			wantRule: "auto_approve",
+			want:     StatusApproved,
+		},
+		{
+			name:     "brand new account",
+			in:       ApprovalInput{Sale: &Sale{Amount: MustParseMoney("100", "ARS")}, Buyer: &User{ID: "2", CreatedAt: now.Add(-time.Hour)}},
+			wantRule: "new_account",
+			want:     StatusPending,
+		},
+		{
+			name:     "currency without limits needs review",
+			in:       ApprovalInput{Sale: &Sale{Amount: MustParseMoney("10", "USD")}, Buyer: oldUser},
+			wantRule: "amount_needs_review",
+			want:     StatusPending,
+		},
+		{
+			name:     "big amount needs review",
+			in:       ApprovalInput{Sale: &Sale{Amount: MustParseMoney("5000", "ARS")}, Buyer: oldUser},
+			wantRule: "amount_needs_review",
+			want:     StatusPending,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.in.Now = now
+			d, err := engine.Decide(context.Background(), tt.in)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, d.Status)
+			require.Equal(t, tt.wantRule, d.Rule)
+		})
+	}
+}
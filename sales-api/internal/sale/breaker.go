@@ -0,0 +1,115 @@
+package sale
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// BreakerState is a snapshot of a CircuitBreaker, as shown by the health
+// endpoint.
+type BreakerState struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenedAt            time.Time `json:"opened_at,omitzero"`
+}
+
+// CircuitBreaker stops calling a dependency after Threshold consecutive
+// failures. Once Cooldown has passed it lets a single trial call through:
+// if it succeeds the breaker closes, otherwise it stays open for another
+// Cooldown.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool // a half-open trial call is in flight
+}
+
+// NewCircuitBreaker builds a closed CircuitBreaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow reports whether a call may go through now. Every allowed call must
+// be followed by Success, Failure or Ignore.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.stateLocked() {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return false
+}
+
+// Success records a call that reached the dependency and closes the breaker.
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.trial = false
+}
+
+// Failure records a failed call, opening the breaker once the threshold is
+// reached or when a half-open trial fails.
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.trial || b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+	b.trial = false
+}
+
+// Ignore records an allowed call whose outcome says nothing about the
+// dependency, such as one cancelled by its caller.
+func (b *CircuitBreaker) Ignore() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
+
+// State returns a snapshot of the breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return BreakerState{
+		State:               b.stateLocked(),
+		ConsecutiveFailures: b.failures,
+		OpenedAt:            b.openedAt,
+	}
+}
+
+// stateLocked derives the state from the counters. Callers hold b.mu.
+func (b *CircuitBreaker) stateLocked() string {
+	switch {
+	case b.openedAt.IsZero():
+		return BreakerClosed
+	case b.now().Sub(b.openedAt) < b.cooldown:
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
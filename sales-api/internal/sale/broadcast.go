@@ -0,0 +1,146 @@
+package sale
+
+import (
+	"context"
+	"sync"
+)
+
+// Default sizes of a Broadcaster.
+const (
+	// DefaultReplayBuffer is how many recent events are kept for clients
+	// resuming a stream.
+	DefaultReplayBuffer = 1000
+
+	// subscriptionBuffer is how many events may wait for a subscriber before
+	// it counts as too slow and is dropped.
+	subscriptionBuffer = 64
+)
+
+// StreamEvent is an Event numbered in the order the Broadcaster saw it. Seq
+// starts over when the process restarts.
+type StreamEvent struct {
+	Seq   uint64
+	Event Event
+}
+
+// StreamFilter selects the events a subscriber receives. Zero fields match
+// everything; Status matches the status a sale was left in.
+type StreamFilter struct {
+	UserID string
+	Status string
+}
+
+func (f StreamFilter) matches(event Event) bool {
+	return (f.UserID == "" || f.UserID == event.UserID) &&
+		(f.Status == "" || f.Status == event.ToStatus)
+}
+
+// Subscription receives the events of a Broadcaster that pass its filter.
+type Subscription struct {
+	// C is closed when the subscriber falls too far behind; it should resume
+	// from the last event it got.
+	C <-chan StreamEvent
+
+	c      chan StreamEvent
+	filter StreamFilter
+}
+
+// Broadcaster hands every sale change to the subscribers of this process,
+// keeping the latest ones so a subscriber can resume where it left off.
+//
+// Publishing never blocks: a subscriber whose buffer is full is dropped
+// instead of slowing down the write that published the event.
+type Broadcaster struct {
+	mu     sync.Mutex
+	seq    uint64
+	replay []StreamEvent // ring of the latest events, oldest at next
+	next   int
+	subs   map[*Subscription]struct{}
+}
+
+// NewBroadcaster builds a Broadcaster that keeps the last replaySize events,
+// or DefaultReplayBuffer if not positive.
+func NewBroadcaster(replaySize int) *Broadcaster {
+	if replaySize <= 0 {
+		replaySize = DefaultReplayBuffer
+	}
+	return &Broadcaster{
+		replay: make([]StreamEvent, 0, replaySize),
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Publish implements Publisher. It never fails.
+func (b *Broadcaster) Publish(_ context.Context, event Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	ev := StreamEvent{Seq: b.seq, Event: event}
+	if len(b.replay) < cap(b.replay) {
+		b.replay = append(b.replay, ev)
+	} else {
+		b.replay[b.next] = ev
+		b.next = (b.next + 1) % len(b.replay)
+	}
+
+	for sub := range b.subs {
+		if !sub.filter.matches(event) {
+			continue
+		}
+		select {
+		case sub.c <- ev:
+		default:
+			// Too slow: let it reconnect and catch up from the replay buffer.
+			delete(b.subs, sub)
+			close(sub.c)
+		}
+	}
+	return nil
+}
+
+// Subscribe starts receiving the events that pass filter. Events after
+// lastSeq still in the replay buffer are returned in replay, and complete is
+// false if some were already evicted, so the subscriber should reload what
+// it shows. A zero lastSeq replays nothing.
+func (b *Broadcaster) Subscribe(filter StreamFilter, lastSeq uint64) (sub *Subscription, replay []StreamEvent, complete bool) {
+	c := make(chan StreamEvent, subscriptionBuffer)
+	sub = &Subscription{C: c, c: c, filter: filter}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs[sub] = struct{}{}
+	if lastSeq == 0 {
+		return sub, nil, true
+	}
+	complete = true
+	if lastSeq > b.seq {
+		// Numbered by an earlier process, whose last events are lost.
+		lastSeq = 0
+		complete = false
+	}
+
+	for i := range b.replay {
+		ev := b.replay[(b.next+i)%len(b.replay)]
+		if i == 0 && ev.Seq > lastSeq+1 {
+			complete = false
+		}
+		if ev.Seq > lastSeq && filter.matches(ev.Event) {
+			replay = append(replay, ev)
+		}
+	}
+	return sub, replay, complete
+}
+
+// Unsubscribe stops sub from receiving events. It is safe to call on a
+// subscription that was already dropped.
+func (b *Broadcaster) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,104 @@
+package sale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_BroadcastsChanges(t *testing.T) {
+	users := &mockUserClient{
+		mockGetUser: func(id string) (*User, error) {
+			return &User{ID: id, CreatedAt: time.Now()}, nil
+		},
+	}
+	stream := NewBroadcaster(0)
+	s := NewService(NewLocalStorage(), nil, "", WithUserClient(users), WithBroadcaster(stream))
+
+	all, _, _ := stream.Subscribe(StreamFilter{}, 0)
+	approved, _, _ := stream.Subscribe(StreamFilter{Status: StatusApproved}, 0)
+	other, _, _ := stream.Subscribe(StreamFilter{UserID: "other"}, 0)
+
+	ctx := context.Background()
+	sale := &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")}
+	require.NoError(t, s.CreateSale(ctx, sale))
+	_, err := s.UpdateSale(ctx, sale.ID, &UpdateFieldsSale{Status: StatusApproved}, 0)
+	require.NoError(t, err)
+
+	ev := <-all.C
+	require.Equal(t, uint64(1), ev.Seq)
+	require.Equal(t, EventSaleCreated, ev.Event.Type)
+	ev = <-all.C
+	require.Equal(t, uint64(2), ev.Seq)
+	require.Equal(t, StatusApproved, ev.Event.ToStatus)
+
+	ev = <-approved.C
+	require.Equal(t, uint64(2), ev.Seq)
+	require.Empty(t, approved.C)
+	require.Empty(t, other.C)
+}
+
+func TestBroadcaster_Resume(t *testing.T) {
+	b := NewBroadcaster(3)
+	for i := range 5 {
+		userID := "1234"
+		if i%2 == 1 {
+			userID = "5678"
+		}
+		require.NoError(t, b.Publish(context.Background(), Event{UserID: userID}))
+	}
+
+	tests := []struct {
+		name     string
+		filter   StreamFilter
+		lastSeq  uint64
+		want     []uint64
+		complete bool
+	}{
+		{name: "new subscriber", lastSeq: 0, want: nil, complete: true},
+		{name: "up to date", lastSeq: 5, want: nil, complete: true},
+		{name: "within buffer", lastSeq: 3, want: []uint64{4, 5}, complete: true},
+		{name: "oldest kept", lastSeq: 2, want: []uint64{3, 4, 5}, complete: true},
+		{name: "filtered", filter: StreamFilter{UserID: "1234"}, lastSeq: 2, want: []uint64{3, 5}, complete: true},
+		{name: "evicted", lastSeq: 1, want: []uint64{3, 4, 5}, complete: false},
+		{name: "earlier process", lastSeq: 9, want: []uint64{3, 4, 5}, complete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, complete := b.Subscribe(tt.filter, tt.lastSeq)
+			defer b.Unsubscribe(sub)
+
+			var got []uint64
+			for _, ev := range replay {
+				got = append(got, ev.Seq)
+			}
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.complete, complete)
+		})
+	}
+}
+
+func TestBroadcaster_DropsSlowSubscribers(t *testing.T) {
+	b := NewBroadcaster(0)
+	slow, _, _ := b.Subscribe(StreamFilter{}, 0)
+
+	// Nobody reads slow, yet publishing never blocks.
+	for range subscriptionBuffer + 1 {
+		require.NoError(t, b.Publish(context.Background(), Event{}))
+	}
+
+	var got int
+	for range slow.C {
+		got++
+	}
+	require.Equal(t, subscriptionBuffer, got)
+
+	// It can resume from the last event it got.
+	sub, replay, complete := b.Subscribe(StreamFilter{}, uint64(got))
+	require.True(t, complete)
+	require.Len(t, replay, 1)
+	b.Unsubscribe(sub)
+	b.Unsubscribe(slow)
+}
@@ -0,0 +1,59 @@
+package sale
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Statuses of a users-api user that sales-api tells apart.
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusBlocked   = "blocked"
+)
+
+// ErrUserNotActive is matched by the *UserNotActiveError returned for a sale
+// whose buyer is suspended, blocked or otherwise not allowed to buy.
+var ErrUserNotActive = errors.New("user is not active")
+
+// UserNotActiveError tells why the buyer of a sale may not buy. It wraps
+// ErrUserNotActive.
+type UserNotActiveError struct {
+	UserID string
+	Status string
+	Reason string
+
+	// SuspendedUntil is when a suspension ends, zero for other statuses.
+	SuspendedUntil time.Time
+}
+
+func (e *UserNotActiveError) Error() string {
+	msg := fmt.Sprintf("%s: user %s is %s", ErrUserNotActive, e.UserID, e.Status)
+	if !e.SuspendedUntil.IsZero() {
+		msg += " until " + e.SuspendedUntil.Format(time.RFC3339)
+	}
+	return msg
+}
+
+// Unwrap makes UserNotActiveError match ErrUserNotActive.
+func (e *UserNotActiveError) Unwrap() error {
+	return ErrUserNotActive
+}
+
+// checkActive returns a *UserNotActiveError unless user may buy at now. A
+// user without a status, as sent by a users-api older than user statuses,
+// counts as active, and so does one whose suspension is over.
+func (u *User) checkActive(now time.Time) error {
+	switch {
+	case u.Status == "", u.Status == UserStatusActive:
+		return nil
+	case u.Status == UserStatusSuspended && !now.Before(u.SuspendedUntil):
+		return nil
+	}
+	err := &UserNotActiveError{UserID: u.ID, Status: u.Status, Reason: u.StatusReason}
+	if u.Status == UserStatusSuspended {
+		err.SuspendedUntil = u.SuspendedUntil
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package sale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_CreateSale_InactiveBuyer(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	until := time.Now().Add(time.Hour)
+	buyers := map[string]*User{
+		"active":    {Status: UserStatusActive, CreatedAt: old},
+		"legacy":    {CreatedAt: old},
+		"suspended": {Status: UserStatusSuspended, StatusReason: "chargeback", SuspendedUntil: until, CreatedAt: old},
+		"expired":   {Status: UserStatusSuspended, SuspendedUntil: time.Now().Add(-time.Hour), CreatedAt: old},
+		"blocked":   {Status: UserStatusBlocked, StatusReason: "stolen card", CreatedAt: old},
+		"weird":     {Status: "pending_review", CreatedAt: old},
+	}
+	users := &mockUserClient{
+		mockGetUser: func(id string) (*User, error) {
+			buyer := *buyers[id]
+			buyer.ID = id
+			return &buyer, nil
+		},
+	}
+	s := NewService(NewLocalStorage(), nil, "", WithUserClient(users))
+	ctx := context.Background()
+
+	for _, id := range []string{"active", "legacy", "expired"} {
+		sale := &Sale{UserID: id, Amount: MustParseMoney("10", "ARS")}
+		require.NoError(t, s.CreateSale(ctx, sale), id)
+		require.Equal(t, StatusApproved, sale.Status, id)
+	}
+
+	tests := []struct {
+		id   string
+		want UserNotActiveError
+	}{
+		{id: "suspended", want: UserNotActiveError{UserID: "suspended", Status: UserStatusSuspended, Reason: "chargeback", SuspendedUntil: until}},
+		{id: "blocked", want: UserNotActiveError{UserID: "blocked", Status: UserStatusBlocked, Reason: "stolen card"}},
+		{id: "weird", want: UserNotActiveError{UserID: "weird", Status: "pending_review"}},
+	}
+	for _, tt := range tests {
+		err := s.CreateSale(ctx, &Sale{UserID: tt.id, Amount: MustParseMoney("10", "ARS")})
+		require.ErrorIs(t, err, ErrUserNotActive, tt.id)
+		var notActive *UserNotActiveError
+		require.ErrorAs(t, err, &notActive, tt.id)
+		require.Equal(t, tt.want, *notActive, tt.id)
+	}
+
+	summary, err := s.ListSales(ctx, SaleFilter{}, PageRequest{})
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.Metadata.Quantity)
+}
+
+func TestService_Reconcile_InactiveBuyer(t *testing.T) {
+	ctx := context.Background()
+	down := true
+	users := &mockUserClient{
+		mockGetUser: func(id string) (*User, error) {
+			if down {
+				return nil, ErrUsersAPIUnavailable
+			}
+			return &User{ID: id, Status: UserStatusBlocked, CreatedAt: time.Now().Add(-48 * time.Hour)}, nil
+		},
+	}
+	s := NewService(NewLocalStorage(), nil, "", WithUserClient(users), WithDegradedMode())
+
+	sale := &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")}
+	require.NoError(t, s.CreateSale(ctx, sale))
+	require.Equal(t, StatusPendingVerification, sale.Status)
+
+	down = false
+	result, err := s.ReconcilePending(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, ReconcileResult{Rejected: 1}, result)
+
+	got, err := s.GetSale(ctx, sale.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRejected, got.Status)
+	require.Equal(t, ReasonUserNotActive, got.StatusReason)
+}
+
+func TestService_HandleUserEvent_RefreshesBuyer(t *testing.T) {
+	ctx := context.Background()
+	status := UserStatusActive
+	users := &mockUserClient{
+		mockGetUser: func(id string) (*User, error) {
+			return &User{ID: id, Status: status, CreatedAt: time.Now().Add(-48 * time.Hour)}, nil
+		},
+	}
+	cache := NewCachingUserClient(users, UserCacheConfig{})
+	s := NewService(NewLocalStorage(), nil, "", WithUserClient(cache))
+
+	require.NoError(t, s.CreateSale(ctx, &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")}))
+
+	// users-api blocks the user and tells sales-api so.
+	status = UserStatusBlocked
+	cancelled, err := s.HandleUserEvent(ctx, UserEvent{ID: "user.blocked:1234:2", Type: "user.blocked", UserID: "1234", Version: 2})
+	require.NoError(t, err)
+	require.Zero(t, cancelled)
+
+	err = s.CreateSale(ctx, &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")})
+	require.ErrorIs(t, err, ErrUserNotActive)
+}
@@ -1,33 +1,141 @@
 package sale
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Sale represents a system user with metadata for auditing and versioning.
 type Sale struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	Amount    float32   `json:"amount"`
-	Status    string    `json:"status"`
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Amount Money  `json:"amount"`
+	Status string `json:"status"`
+
+	// StatusReason explains why the sale got its current status, if known.
+	StatusReason string `json:"status_reason,omitempty"`
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Version   int       `json:"version"`
+
+	// Approval records which rule chose the initial status.
+	Approval *Decision `json:"approval,omitempty"`
+}
+
+// MarshalJSON flattens Amount: the decimal travels as a string in "amount"
+// next to its ISO-4217 code in "currency".
+func (s Sale) MarshalJSON() ([]byte, error) {
+	type plain Sale
+	return json.Marshal(struct {
+		plain
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{plain(s), s.Amount.Decimal(), s.Amount.Currency})
+}
+
+// UnmarshalJSON reads the format written by MarshalJSON. The amount may also
+// come as a JSON number; it is parsed from its text, never through a float.
+func (s *Sale) UnmarshalJSON(data []byte) error {
+	type plain Sale
+	aux := struct {
+		*plain
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	amount, err := ParseMoney(aux.Amount.String(), aux.Currency)
+	if err != nil {
+		return err
+	}
+	s.Amount = amount
+	return nil
+}
+
+// User is a users-api user as sales-api reads it: enough to check the buyer
+// of a sale and to embed their profile in it.
+type User struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Address  string `json:"address,omitempty"`
+	NickName string `json:"nickname,omitempty"`
+	Status   string `json:"status"`
+
+	// StatusReason explains why the user got their current status, if known.
+	StatusReason string `json:"status_reason,omitempty"`
+
+	// SuspendedUntil is when a suspension ends by itself.
+	SuspendedUntil time.Time `json:"suspended_until,omitzero"`
+
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
 }
 
 type metadata struct {
-	Quantity    int     `json:"quantity"`
-	Approved    int     `json:"approved"`
-	Rejected    int     `json:"rejected"`
-	Pending     int     `json:"pending"`
-	TotalAmount float32 `json:"total_amount"`
+	Quantity  int `json:"quantity"`
+	Approved  int `json:"approved"`
+	Rejected  int `json:"rejected"`
+	Pending   int `json:"pending"`
+	OnHold    int `json:"on_hold"`
+	Cancelled int `json:"cancelled"`
+	Refunded  int `json:"refunded"`
+	Expired   int `json:"expired"`
+
+	PendingVerification int `json:"pending_verification"`
+
+	// TotalAmount holds one exact total per currency, sorted by code.
+	TotalAmount []Money `json:"total_amount"`
+}
+
+// add accounts for quantity sales with the given status totalling amounts,
+// which may be in several currencies. Returns an error wrapping
+// ErrAmountOverflow if a total no longer fits.
+func (m *metadata) add(status string, quantity int, amounts ...Money) error {
+	m.Quantity += quantity
+	switch status {
+	case StatusApproved:
+		m.Approved += quantity
+	case StatusRejected:
+		m.Rejected += quantity
+	case StatusPending:
+		m.Pending += quantity
+	case StatusOnHold:
+		m.OnHold += quantity
+	case StatusCancelled:
+		m.Cancelled += quantity
+	case StatusRefunded:
+		m.Refunded += quantity
+	case StatusExpired:
+		m.Expired += quantity
+	case StatusPendingVerification:
+		m.PendingVerification += quantity
+	}
+	for _, amount := range amounts {
+		totals, err := addTotal(m.TotalAmount, amount)
+		if err != nil {
+			return err
+		}
+		m.TotalAmount = totals
+	}
+	return nil
 }
 
 type informe struct {
 	Metadata metadata `json:"metadata"`
 	Results  []Sale   `json:"results"`
+
+	// NextCursor fetches the page after Results; empty on the last one.
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // UpdateFields represents the optional fields for updating a Sale.
 // A nil pointer means “no change” for that field.
 type UpdateFieldsSale struct {
 	Status string `json:"status"`
+
+	// Reason optionally explains the new status.
+	Reason string `json:"reason,omitempty"`
 } //ver * de UpdateFieldsUser
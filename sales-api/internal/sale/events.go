@@ -0,0 +1,76 @@
+package sale
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Event types published for sales.
+const (
+	EventSaleCreated       = "sale.created"
+	EventSaleStatusChanged = "sale.status_changed"
+)
+
+// Event tells other services that a sale was created or changed status.
+//
+// Delivery is at least once, so consumers must be idempotent. ID is the same
+// for every delivery of one event and can be used to drop duplicates.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	SaleID     string    `json:"sale_id"`
+	UserID     string    `json:"user_id"`
+
+	// Version is the sale version the event describes.
+	Version int `json:"version"`
+
+	// FromStatus is empty for sale.created.
+	FromStatus string `json:"from_status,omitempty"`
+	ToStatus   string `json:"to_status"`
+	Reason     string `json:"reason,omitempty"`
+	Actor      string `json:"actor"`
+
+	// Sale is the sale as it was left by the change.
+	Sale *Sale `json:"sale"`
+}
+
+// newEvent builds the event announcing the change entry records, which left
+// sale as it is now. Every history entry is announced, so the storage calls
+// it for each entry it writes.
+func newEvent(sale *Sale, entry HistoryEntry) Event {
+	eventType := EventSaleStatusChanged
+	if entry.FromStatus == "" {
+		eventType = EventSaleCreated
+	}
+	snapshot := *sale
+	return Event{
+		ID:         eventType + ":" + entry.SaleID + ":" + strconv.Itoa(entry.Version),
+		Type:       eventType,
+		OccurredAt: entry.At,
+		SaleID:     entry.SaleID,
+		UserID:     sale.UserID,
+		Version:    entry.Version,
+		FromStatus: entry.FromStatus,
+		ToStatus:   entry.ToStatus,
+		Reason:     entry.Reason,
+		Actor:      entry.Actor,
+		Sale:       &snapshot,
+	}
+}
+
+// Publisher sends events to whoever listens to them. Publishers are the
+// sinks a Dispatcher delivers the outbox to: an error means the event was not
+// taken and will be offered again.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// PublisherFunc adapts a function to Publisher.
+type PublisherFunc func(ctx context.Context, event Event) error
+
+// Publish implements Publisher.
+func (f PublisherFunc) Publish(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
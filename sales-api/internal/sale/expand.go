@@ -0,0 +1,111 @@
+package sale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// UserStatusUnknown is the status of the placeholder embedded in a sale
+// whose buyer users-api did not return, because it was deleted, never
+// existed or could not be reached.
+const UserStatusUnknown = "unknown"
+
+// expandConcurrency bounds the users-api lookups one expansion runs at once.
+const expandConcurrency = 8
+
+// ExpandedSale is a sale with its buyer's users-api profile embedded as
+// "user".
+type ExpandedSale struct {
+	Sale
+	User *User
+}
+
+// MarshalJSON adds "user" to the JSON of the sale, which Sale.MarshalJSON
+// would otherwise write alone.
+func (e ExpandedSale) MarshalJSON() ([]byte, error) {
+	sale, err := json.Marshal(&e.Sale)
+	if err != nil {
+		return nil, err
+	}
+	user, err := json.Marshal(e.User)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(sale)+len(user)+9)
+	out = append(out, sale[:len(sale)-1]...)
+	out = append(out, `,"user":`...)
+	out = append(out, user...)
+	return append(out, '}'), nil
+}
+
+// expandedInforme is an informe whose results embed their buyers.
+type expandedInforme struct {
+	informe
+	Results []ExpandedSale `json:"results"`
+}
+
+// ExpandUsers embeds in each sale the profile of its buyer. Every distinct
+// buyer is looked up once, several at a time; those users-api does not
+// return are replaced by a placeholder with UserStatusUnknown, so only a
+// cancelled or timed out ctx makes it fail.
+func (s *Service) ExpandUsers(ctx context.Context, sales ...Sale) ([]ExpandedSale, error) {
+	var ids []string
+	users := make(map[string]*User)
+	for _, sale := range sales {
+		if _, ok := users[sale.UserID]; !ok {
+			users[sale.UserID] = nil
+			ids = append(ids, sale.UserID)
+		}
+	}
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, expandConcurrency)
+	)
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			user, err := s.users.GetUser(ctx, id)
+			if err != nil {
+				if !errors.Is(err, ErrUserNotFound) && ctx.Err() == nil {
+					s.logger.Warn("embedding placeholder for unreachable user", zap.Error(err), zap.String("user_id", id))
+				}
+				user = &User{ID: id, Status: UserStatusUnknown}
+			}
+			mu.Lock()
+			users[id] = user
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+
+	expanded := make([]ExpandedSale, len(sales))
+	for i, sale := range sales {
+		expanded[i] = ExpandedSale{Sale: sale, User: users[sale.UserID]}
+	}
+	return expanded, nil
+}
+
+// ExpandInforme is ExpandUsers over the results of a listing.
+func (s *Service) ExpandInforme(ctx context.Context, u informe) (expandedInforme, error) {
+	results, err := s.ExpandUsers(ctx, u.Results...)
+	if err != nil {
+		return expandedInforme{}, err
+	}
+	return expandedInforme{informe: u, Results: results}, nil
+}
@@ -0,0 +1,89 @@
+package sale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_ExpandUsers(t *testing.T) {
+	var (
+		mu               sync.Mutex
+		calls            = map[string]int{}
+		inFlight, maxRun atomic.Int32
+	)
+	users := &mockUserClient{
+		mockGetUser: func(id string) (*User, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				m := maxRun.Load()
+				if n <= m || maxRun.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			calls[id]++
+			mu.Unlock()
+
+			switch id {
+			case "ghost":
+				return nil, ErrUserNotFound
+			case "down":
+				return nil, ErrTryingToGetUser
+			}
+			return &User{ID: id, Name: "name-" + id, Status: "active"}, nil
+		},
+	}
+	s := NewService(NewLocalStorage(), nil, "", WithUserClient(users))
+
+	var sales []Sale
+	for i := range 20 {
+		id := fmt.Sprintf("user-%d", i)
+		sales = append(sales, Sale{ID: "a-" + id, UserID: id}, Sale{ID: "b-" + id, UserID: id})
+	}
+	sales = append(sales, Sale{ID: "s1", UserID: "ghost"}, Sale{ID: "s2", UserID: "down"})
+
+	expanded, err := s.ExpandUsers(context.Background(), sales...)
+	require.NoError(t, err)
+	require.Len(t, expanded, len(sales))
+	for i, e := range expanded {
+		require.Equal(t, sales[i].ID, e.ID)
+		require.Equal(t, sales[i].UserID, e.User.ID)
+	}
+	require.Equal(t, "name-user-3", expanded[6].User.Name)
+	require.Equal(t, &User{ID: "ghost", Status: UserStatusUnknown}, expanded[40].User)
+	require.Equal(t, &User{ID: "down", Status: UserStatusUnknown}, expanded[41].User)
+
+	require.Len(t, calls, 22)
+	for id, n := range calls {
+		require.Equal(t, 1, n, id)
+	}
+	require.Greater(t, maxRun.Load(), int32(1))
+	require.LessOrEqual(t, maxRun.Load(), int32(expandConcurrency))
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(expanded[0])
+		require.NoError(t, err)
+
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(data, &got))
+		require.Equal(t, "a-user-0", got["id"])
+		require.Equal(t, map[string]any{"id": "user-0", "name": "name-user-0", "status": "active"}, got["user"])
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := s.ExpandUsers(ctx, Sale{ID: "s3", UserID: "user-99"})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
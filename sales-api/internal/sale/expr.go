@@ -0,0 +1,564 @@
+package sale
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FilterSyntaxError reports where a filter expression went wrong. It wraps
+// ErrInvalidInput.
+type FilterSyntaxError struct {
+	// Pos is the 1-based position, in characters, of the offending token.
+	Pos int
+	Msg string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("%s: filter: %s at position %d", ErrInvalidInput, e.Msg, e.Pos)
+}
+
+// Unwrap makes FilterSyntaxError match ErrInvalidInput.
+func (e *FilterSyntaxError) Unwrap() error {
+	return ErrInvalidInput
+}
+
+// FilterExpr is a compiled filter expression such as
+//
+//	amount > 100 and status in ("pending", "approved") and created_at >= "2026-01-01"
+//
+// Comparisons (=, !=, <, <=, >, >=, in, not in) of a field against literals
+// are combined with and, or, not and parentheses. Strings are double quoted;
+// times are strings in RFC 3339 or YYYY-MM-DD form. Amounts compare by value,
+// whatever their currency; add a currency comparison to narrow them down.
+type FilterExpr struct {
+	src  string
+	root exprNode
+}
+
+// ParseFilterExpr compiles src. Errors are *FilterSyntaxError.
+func ParseFilterExpr(src string) (*FilterExpr, error) {
+	p := &exprParser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorAt(tok, "unexpected %s", tok)
+	}
+	return &FilterExpr{src: src, root: root}, nil
+}
+
+// String returns the source of e.
+func (e *FilterExpr) String() string {
+	return e.src
+}
+
+// Match reports whether sale passes e.
+func (e *FilterExpr) Match(sale *Sale) bool {
+	return e.root.match(sale)
+}
+
+// sql translates e into a condition on the sales table and its arguments.
+func (e *FilterExpr) sql() (string, []any) {
+	return e.root.sql()
+}
+
+// fieldKind is the type of a field, which decides the literals it compares
+// against.
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindTime
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	case kindNumber:
+		return "a number"
+	case kindTime:
+		return "a time"
+	default:
+		return "a string"
+	}
+}
+
+// literal is a value of the kind of the field it is compared with.
+type literal struct {
+	str string
+	num *big.Rat
+	t   time.Time
+}
+
+func compareLiterals(kind fieldKind, a, b literal) int {
+	switch kind {
+	case kindNumber:
+		return a.num.Cmp(b.num)
+	case kindTime:
+		return a.t.Compare(b.t)
+	default:
+		return strings.Compare(a.str, b.str)
+	}
+}
+
+// exprField describes a field expressions may use.
+type exprField struct {
+	kind  fieldKind
+	value func(*Sale) literal
+
+	// column is the SQL column compared; sqlCond overrides it.
+	column  string
+	sqlCond func(op string, lit literal) (string, []any)
+}
+
+// saleExprFields are the fields a sale filter expression may use.
+var saleExprFields = map[string]exprField{
+	"id":            {kind: kindString, column: "id", value: func(s *Sale) literal { return literal{str: s.ID} }},
+	"user_id":       {kind: kindString, column: "user_id", value: func(s *Sale) literal { return literal{str: s.UserID} }},
+	"status":        {kind: kindString, column: "status", value: func(s *Sale) literal { return literal{str: s.Status} }},
+	"status_reason": {kind: kindString, column: "status_reason", value: func(s *Sale) literal { return literal{str: s.StatusReason} }},
+	"currency":      {kind: kindString, column: "currency", value: func(s *Sale) literal { return literal{str: s.Amount.Currency} }},
+	"version":       {kind: kindNumber, column: "version", value: func(s *Sale) literal { return literal{num: big.NewRat(int64(s.Version), 1)} }},
+	"created_at":    {kind: kindTime, column: "created_at", value: func(s *Sale) literal { return literal{t: s.CreatedAt} }},
+	"updated_at":    {kind: kindTime, column: "updated_at", value: func(s *Sale) literal { return literal{t: s.UpdatedAt} }},
+	"amount": {
+		kind: kindNumber,
+		value: func(s *Sale) literal {
+			return literal{num: new(big.Rat).SetFrac(big.NewInt(s.Amount.Units), pow10(currencyExponents[s.Amount.Currency]))}
+		},
+		sqlCond: amountCond,
+	},
+}
+
+// amountCond compares amounts in SQL exactly: the literal is scaled to the
+// minor units of each currency in turn.
+func amountCond(op string, lit literal) (string, []any) {
+	currencies := make([]string, 0, len(currencyExponents))
+	for c := range currencyExponents {
+		currencies = append(currencies, c)
+	}
+	slices.Sort(currencies)
+
+	conds := make([]string, len(currencies))
+	var args []any
+	for i, c := range currencies {
+		scaled := new(big.Rat).Mul(lit.num, new(big.Rat).SetInt(pow10(currencyExponents[c])))
+		cond, condArgs := intCond("amount_units", op, scaled)
+		conds[i] = "(currency = ? AND " + cond + ")"
+		args = append(append(args, c), condArgs...)
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// intCond compares an integer column with r, which need not be an integer.
+func intCond(column, op string, r *big.Rat) (string, []any) {
+	floor := new(big.Int).Div(r.Num(), r.Denom()) // rounds towards -inf as Denom > 0
+	exact := r.IsInt()
+	if !floor.IsInt64() {
+		// Beyond any stored value.
+		above := r.Sign() > 0
+		switch {
+		case op == "=":
+			return "1 = 0", nil
+		case op == "!=", (op == "<" || op == "<=") == above:
+			return "1 = 1", nil
+		default:
+			return "1 = 0", nil
+		}
+	}
+	n := floor.Int64()
+	if exact {
+		return column + " " + op + " ?", []any{n}
+	}
+	switch op {
+	case ">", ">=":
+		return column + " > ?", []any{n}
+	case "<", "<=":
+		return column + " <= ?", []any{n}
+	case "!=":
+		return "1 = 1", nil
+	default:
+		return "1 = 0", nil
+	}
+}
+
+// pow10 returns 10^exp.
+func pow10(exp int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)
+}
+
+// exprNode is a node of a compiled expression.
+type exprNode interface {
+	match(sale *Sale) bool
+	sql() (string, []any)
+}
+
+type andNode struct{ left, right exprNode }
+
+func (n andNode) match(sale *Sale) bool { return n.left.match(sale) && n.right.match(sale) }
+
+func (n andNode) sql() (string, []any) {
+	l, largs := n.left.sql()
+	r, rargs := n.right.sql()
+	return "(" + l + " AND " + r + ")", append(largs, rargs...)
+}
+
+type orNode struct{ left, right exprNode }
+
+func (n orNode) match(sale *Sale) bool { return n.left.match(sale) || n.right.match(sale) }
+
+func (n orNode) sql() (string, []any) {
+	l, largs := n.left.sql()
+	r, rargs := n.right.sql()
+	return "(" + l + " OR " + r + ")", append(largs, rargs...)
+}
+
+type notNode struct{ x exprNode }
+
+func (n notNode) match(sale *Sale) bool { return !n.x.match(sale) }
+
+func (n notNode) sql() (string, []any) {
+	x, args := n.x.sql()
+	return "(NOT " + x + ")", args
+}
+
+// cmpNode compares a field with one literal, or with a list for in.
+type cmpNode struct {
+	field  exprField
+	op     string // one of the comparison operators, or "in"
+	values []literal
+}
+
+func (n cmpNode) match(sale *Sale) bool {
+	v := n.field.value(sale)
+	if n.op == "in" {
+		for _, lit := range n.values {
+			if compareLiterals(n.field.kind, v, lit) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	c := compareLiterals(n.field.kind, v, n.values[0])
+	switch n.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func (n cmpNode) sql() (string, []any) {
+	if n.op == "in" {
+		conds := make([]string, len(n.values))
+		var args []any
+		for i, lit := range n.values {
+			cond, condArgs := n.cond("=", lit)
+			conds[i] = cond
+			args = append(args, condArgs...)
+		}
+		return "(" + strings.Join(conds, " OR ") + ")", args
+	}
+	return n.cond(n.op, n.values[0])
+}
+
+func (n cmpNode) cond(op string, lit literal) (string, []any) {
+	if n.field.sqlCond != nil {
+		return n.field.sqlCond(op, lit)
+	}
+	switch n.field.kind {
+	case kindNumber:
+		return intCond(n.field.column, op, lit.num)
+	case kindTime:
+		return n.field.column + " " + op + " ?", []any{toUnixNano(lit.t)}
+	default:
+		return n.field.column + " " + op + " ?", []any{lit.str}
+	}
+}
+
+// Tokens of a filter expression.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string // unquoted for strings
+	pos  int    // 1-based, in characters
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of filter"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// exprParser is a recursive descent parser of
+//
+//	or      = and { "or" and }
+//	and     = unary { "and" unary }
+//	unary   = "not" unary | "(" or ")" | field cmp
+//	cmp     = op literal | [ "not" ] "in" "(" literal { "," literal } ")"
+type exprParser struct {
+	src    string
+	tokens []token
+	next   int
+}
+
+func (p *exprParser) errorAt(tok token, format string, args ...any) error {
+	return &FilterSyntaxError{Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) lex() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		pos := utf8.RuneCountInString(src[:i]) + 1
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(' || r == ')' || r == ',':
+			kind := map[rune]tokenKind{'(': tokLParen, ')': tokRParen, ',': tokComma}[r]
+			p.tokens = append(p.tokens, token{kind: kind, text: string(r), pos: pos})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			j := i + 1
+			if j < len(src) && (src[j] == '=' || (r == '<' && src[j] == '>')) {
+				j++
+			}
+			op := src[i:j]
+			switch op {
+			case "!":
+				return &FilterSyntaxError{Pos: pos, Msg: `unexpected "!", did you mean "!="`}
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+			p.tokens = append(p.tokens, token{kind: tokOp, text: op, pos: pos})
+			i = j
+		case r == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return &FilterSyntaxError{Pos: pos, Msg: "unterminated string"}
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return &FilterSyntaxError{Pos: pos, Msg: "invalid string"}
+			}
+			p.tokens = append(p.tokens, token{kind: tokString, text: s, pos: pos})
+			i = j + 1
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			j := i + 1
+			for j < len(src) && (src[j] == '.' || (src[j] >= '0' && src[j] <= '9')) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tokNumber, text: src[i:j], pos: pos})
+			i = j
+		case r == '_' || unicode.IsLetter(r):
+			j := i + size
+			for j < len(src) {
+				r, size := utf8.DecodeRuneInString(src[j:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			p.tokens = append(p.tokens, token{kind: tokIdent, text: src[i:j], pos: pos})
+			i = j
+		default:
+			return &FilterSyntaxError{Pos: pos, Msg: fmt.Sprintf("unexpected %q", r)}
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokEOF, pos: utf8.RuneCountInString(src) + 1})
+	return nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) take() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokEOF {
+		p.next++
+	}
+	return tok
+}
+
+// keyword reports whether tok is the keyword kw, in any case.
+func keyword(tok token, kw string) bool {
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, kw)
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for keyword(p.peek(), "or") {
+		p.take()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for keyword(p.peek(), "and") {
+		p.take()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	tok := p.take()
+	switch {
+	case keyword(tok, "not"):
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	case tok.kind == tokLParen:
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.take(); closing.kind != tokRParen {
+			return nil, p.errorAt(closing, `expected ")", got %s`, closing)
+		}
+		return x, nil
+	case tok.kind == tokIdent && !keyword(tok, "and") && !keyword(tok, "or") && !keyword(tok, "in"):
+		return p.parseComparison(tok)
+	default:
+		return nil, p.errorAt(tok, "expected a field name, got %s", tok)
+	}
+}
+
+func (p *exprParser) parseComparison(name token) (exprNode, error) {
+	field, ok := saleExprFields[name.text]
+	if !ok {
+		return nil, p.errorAt(name, "unknown field %q", name.text)
+	}
+
+	tok := p.take()
+	negate := false
+	if keyword(tok, "not") {
+		negate = true
+		if tok = p.take(); !keyword(tok, "in") {
+			return nil, p.errorAt(tok, `expected "in", got %s`, tok)
+		}
+	}
+
+	if keyword(tok, "in") {
+		if open := p.take(); open.kind != tokLParen {
+			return nil, p.errorAt(open, `expected "(", got %s`, open)
+		}
+		var values []literal
+		for {
+			lit, err := p.parseLiteral(name.text, field.kind)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, lit)
+			sep := p.take()
+			if sep.kind == tokRParen {
+				break
+			}
+			if sep.kind != tokComma {
+				return nil, p.errorAt(sep, `expected "," or ")", got %s`, sep)
+			}
+		}
+		var node exprNode = cmpNode{field: field, op: "in", values: values}
+		if negate {
+			node = notNode{node}
+		}
+		return node, nil
+	}
+
+	if tok.kind != tokOp {
+		return nil, p.errorAt(tok, "expected a comparison after %s, got %s", name.text, tok)
+	}
+	lit, err := p.parseLiteral(name.text, field.kind)
+	if err != nil {
+		return nil, err
+	}
+	return cmpNode{field: field, op: tok.text, values: []literal{lit}}, nil
+}
+
+func (p *exprParser) parseLiteral(name string, kind fieldKind) (literal, error) {
+	tok := p.take()
+	switch {
+	case kind == kindNumber && tok.kind == tokNumber:
+		r, ok := new(big.Rat).SetString(tok.text)
+		if !ok {
+			return literal{}, p.errorAt(tok, "invalid number %q", tok.text)
+		}
+		return literal{num: r}, nil
+	case kind == kindTime && tok.kind == tokString:
+		if t, err := time.Parse(time.RFC3339Nano, tok.text); err == nil {
+			return literal{t: t}, nil
+		}
+		t, err := time.Parse(time.DateOnly, tok.text)
+		if err != nil {
+			return literal{}, p.errorAt(tok, "invalid time %q, expected RFC 3339 or YYYY-MM-DD", tok.text)
+		}
+		return literal{t: t}, nil
+	case kind == kindString && tok.kind == tokString:
+		return literal{str: tok.text}, nil
+	default:
+		return literal{}, p.errorAt(tok, "%s compares with %s, got %s", name, kind, tok)
+	}
+}
@@ -0,0 +1,98 @@
+package sale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilterExpr_Errors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{src: ``, pos: 1, msg: "expected a field name, got end of filter"},
+		{src: `price > 1`, pos: 1, msg: `unknown field "price"`},
+		{src: `amount >`, pos: 9, msg: "amount compares with a number, got end of filter"},
+		{src: `amount > "1"`, pos: 10, msg: `amount compares with a number, got "1"`},
+		{src: `status = 1`, pos: 10, msg: `status compares with a string, got "1"`},
+		{src: `created_at > "ayer"`, pos: 14, msg: `invalid time "ayer", expected RFC 3339 or YYYY-MM-DD`},
+		{src: `status in ("a" "b")`, pos: 16, msg: `expected "," or ")", got "b"`},
+		{src: `status not = "a"`, pos: 12, msg: `expected "in", got "="`},
+		{src: `(status = "a"`, pos: 14, msg: `expected ")", got end of filter`},
+		{src: `status = "a" status = "b"`, pos: 14, msg: `unexpected "status"`},
+		{src: `status = "a`, pos: 10, msg: "unterminated string"},
+		{src: `status ! "a"`, pos: 8, msg: `unexpected "!", did you mean "!="`},
+		{src: `estado = "ñ" & x`, pos: 14, msg: `unexpected '&'`},
+		{src: `amount > 1.2.3`, pos: 10, msg: `invalid number "1.2.3"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := ParseFilterExpr(tt.src)
+			var syntax *FilterSyntaxError
+			require.ErrorAs(t, err, &syntax)
+			require.ErrorIs(t, err, ErrInvalidInput)
+			require.Equal(t, tt.pos, syntax.Pos)
+			require.Equal(t, tt.msg, syntax.Msg)
+		})
+	}
+}
+
+func TestService_ListSales_FilterExpr(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC) }
+	seed := []*Sale{
+		{ID: "a", UserID: "u1", Status: StatusPending, Amount: MustParseMoney("99.99", "ARS"), CreatedAt: day(1), UpdatedAt: day(1), Version: 1},
+		{ID: "b", UserID: "u1", Status: StatusApproved, Amount: MustParseMoney("150", "ARS"), CreatedAt: day(2), UpdatedAt: day(5), Version: 2},
+		{ID: "c", UserID: "u2", Status: StatusRejected, Amount: MustParseMoney("100", "ARS"), CreatedAt: day(3), UpdatedAt: day(4), Version: 1},
+		{ID: "d", UserID: "u2", Status: StatusApproved, Amount: MustParseMoney("100.5", "USD"), CreatedAt: day(4), UpdatedAt: day(4), Version: 2},
+		{ID: "e", UserID: "u3", Status: StatusPending, Amount: MustParseMoney("101", "JPY"), CreatedAt: day(5), UpdatedAt: day(5), Version: 1, StatusReason: "manual review"},
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{expr: `amount > 100 and status in ("pending", "approved") and created_at >= "2026-01-02"`, want: []string{"b", "d", "e"}},
+		{expr: `amount >= 100`, want: []string{"b", "c", "d", "e"}},
+		{expr: `amount = 100.5`, want: []string{"d"}},
+		{expr: `amount < 100.5`, want: []string{"a", "c"}},
+		{expr: `amount <= 100.001`, want: []string{"a", "c"}},
+		{expr: `amount > 100.001`, want: []string{"b", "d", "e"}},
+		{expr: `amount = 100.001 or amount > 99999999999999999999999`, want: []string{}},
+		{expr: `amount != 100.001 and amount < 99999999999999999999999`, want: []string{"a", "b", "c", "d", "e"}},
+		{expr: `amount > -1.5 and currency = "JPY"`, want: []string{"e"}},
+		{expr: `not (status = "pending" or user_id == "u2")`, want: []string{"b"}},
+		{expr: `status NOT IN ("pending") AND version <> 2`, want: []string{"c"}},
+		{expr: `version > 1.5`, want: []string{"b", "d"}},
+		{expr: `updated_at < "2026-01-04T12:00:00Z" or status_reason = "manual review"`, want: []string{"a", "e"}},
+		{expr: `id >= "c" and id < "e"`, want: []string{"c", "d"}},
+	}
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			for _, sale := range seed {
+				require.NoError(t, storage.SetSale(ctx, sale))
+			}
+			s := NewService(storage, nil, "")
+
+			for _, tt := range tests {
+				expr, err := ParseFilterExpr(tt.expr)
+				require.NoError(t, err, tt.expr)
+
+				resp, err := s.ListSales(ctx, SaleFilter{Where: expr}, PageRequest{})
+				require.NoError(t, err, tt.expr)
+				got := []string{}
+				for _, sale := range resp.Results {
+					got = append(got, sale.ID)
+				}
+				require.Equal(t, tt.want, got, tt.expr)
+				require.Equal(t, len(tt.want), resp.Metadata.Quantity, tt.expr)
+			}
+		})
+	}
+}
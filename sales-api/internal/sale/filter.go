@@ -0,0 +1,226 @@
+package sale
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FieldError tells what is wrong with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	// Position points into the field value, from 1, when the error is
+	// about part of it.
+	Position int `json:"position,omitempty"`
+}
+
+// FieldErrors lists every invalid field of a request. It wraps
+// ErrInvalidInput.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return ErrInvalidInput.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+// Unwrap makes FieldErrors match ErrInvalidInput.
+func (e FieldErrors) Unwrap() error {
+	return ErrInvalidInput
+}
+
+// add records that field is invalid.
+func (e *FieldErrors) add(field, format string, args ...any) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns e as an error, or nil if it is empty.
+func (e FieldErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// SaleFilter selects sales. Zero fields match everything.
+//
+// Time ranges include From and exclude To. The amount bounds are inclusive
+// and only compare within Currency, so setting either of them needs it.
+type SaleFilter struct {
+	UserID string
+
+	// Statuses matches a sale in any of them.
+	Statuses []string
+
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	UpdatedFrom time.Time
+	UpdatedTo   time.Time
+
+	Currency  string
+	MinAmount *Money
+	MaxAmount *Money
+
+	// Where is an extra filter expression the sales must match.
+	Where *FilterExpr
+}
+
+// ParseSaleFilter reads a SaleFilter from the query parameters user_id,
+// status (comma separated), created_from, created_to, updated_from,
+// updated_to, currency, min_amount, max_amount and filter, a FilterExpr.
+// Times are RFC 3339 or
+// plain dates; a plain date as an upper bound includes that whole day.
+// Amounts are in currency, or in defaultCurrency if it is not given.
+//
+// Every field that does not parse is reported in FieldErrors; checks that
+// need more than one field are left to Service.ListSales.
+func ParseSaleFilter(query url.Values, defaultCurrency string) (SaleFilter, error) {
+	var (
+		f    SaleFilter
+		errs FieldErrors
+	)
+
+	f.UserID = query.Get("user_id")
+	if v := query.Get("status"); v != "" {
+		for _, st := range strings.Split(v, ",") {
+			if st = strings.TrimSpace(st); st != "" {
+				f.Statuses = append(f.Statuses, st)
+			}
+		}
+	}
+
+	parseTime := func(field string, upper bool) time.Time {
+		v := query.Get(field)
+		if v == "" {
+			return time.Time{}
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			errs.add(field, "must be an RFC 3339 time or a YYYY-MM-DD date")
+			return time.Time{}
+		}
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t
+	}
+	f.CreatedFrom = parseTime("created_from", false)
+	f.CreatedTo = parseTime("created_to", true)
+	f.UpdatedFrom = parseTime("updated_from", false)
+	f.UpdatedTo = parseTime("updated_to", true)
+
+	f.Currency = query.Get("currency")
+	currency := f.Currency
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	parseAmount := func(field string) *Money {
+		v := query.Get(field)
+		if v == "" {
+			return nil
+		}
+		m, err := ParseMoney(v, currency)
+		if err != nil {
+			errs.add(field, "%s", strings.TrimPrefix(err.Error(), ErrInvalidInput.Error()+": "))
+			return nil
+		}
+		return &m
+	}
+	f.MinAmount = parseAmount("min_amount")
+	f.MaxAmount = parseAmount("max_amount")
+	if (f.MinAmount != nil || f.MaxAmount != nil) && f.Currency == "" {
+		f.Currency = currency
+	}
+
+	if v := query.Get("filter"); v != "" {
+		expr, err := ParseFilterExpr(v)
+		var syntax *FilterSyntaxError
+		if errors.As(err, &syntax) {
+			errs = append(errs, FieldError{Field: "filter", Message: syntax.Msg, Position: syntax.Pos})
+		}
+		f.Where = expr
+	}
+
+	return f, errs.err()
+}
+
+// validate reports, field by field, what makes f unusable with machine.
+func (f SaleFilter) validate(machine *StateMachine) error {
+	var errs FieldErrors
+
+	for _, st := range f.Statuses {
+		if !machine.IsState(st) {
+			errs.add("status", "unknown status %q", st)
+		}
+	}
+	if !f.CreatedTo.IsZero() && !f.CreatedFrom.Before(f.CreatedTo) {
+		errs.add("created_to", "must be after created_from")
+	}
+	if !f.UpdatedTo.IsZero() && !f.UpdatedFrom.Before(f.UpdatedTo) {
+		errs.add("updated_to", "must be after updated_from")
+	}
+	if f.Currency != "" && !IsCurrency(f.Currency) {
+		errs.add("currency", "unknown currency %q", f.Currency)
+	}
+	checkBound := func(field string, bound *Money) {
+		switch {
+		case bound == nil:
+		case bound.Currency != f.Currency:
+			errs.add(field, "must be in %s", f.Currency)
+		case bound.Units < 0:
+			errs.add(field, "must not be negative")
+		}
+	}
+	checkBound("min_amount", f.MinAmount)
+	checkBound("max_amount", f.MaxAmount)
+	if f.MinAmount != nil && f.MaxAmount != nil && f.MinAmount.Currency == f.MaxAmount.Currency &&
+		f.MinAmount.Units > f.MaxAmount.Units {
+		errs.add("max_amount", "must not be less than min_amount")
+	}
+	return errs.err()
+}
+
+// matches reports whether sale passes the filter.
+func (f SaleFilter) matches(sale *Sale) bool {
+	switch {
+	case f.UserID != "" && sale.UserID != f.UserID:
+		return false
+	case len(f.Statuses) > 0 && !slices.Contains(f.Statuses, sale.Status):
+		return false
+	case !inRange(sale.CreatedAt, f.CreatedFrom, f.CreatedTo):
+		return false
+	case !inRange(sale.UpdatedAt, f.UpdatedFrom, f.UpdatedTo):
+		return false
+	case f.Currency != "" && sale.Amount.Currency != f.Currency:
+		return false
+	case f.MinAmount != nil && sale.Amount.Units < f.MinAmount.Units:
+		return false
+	case f.MaxAmount != nil && sale.Amount.Units > f.MaxAmount.Units:
+		return false
+	case f.Where != nil && !f.Where.Match(sale):
+		return false
+	}
+	return true
+}
+
+// onlyUserAndStatus reports whether f filters by nothing but UserID and
+// Statuses, which LocalStorage keeps counters for.
+func (f SaleFilter) onlyUserAndStatus() bool {
+	return f.CreatedFrom.IsZero() && f.CreatedTo.IsZero() && f.UpdatedFrom.IsZero() && f.UpdatedTo.IsZero() &&
+		f.Currency == "" && f.MinAmount == nil && f.MaxAmount == nil && f.Where == nil
+}
+
+// inRange reports whether t is in [from, to), a zero bound being open.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
@@ -0,0 +1,168 @@
+package sale
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSaleFilter(t *testing.T) {
+	ars10 := MustParseMoney("10", "ARS")
+	usd5 := MustParseMoney("5", "USD")
+
+	tests := []struct {
+		name    string
+		query   string
+		want    SaleFilter
+		wantErr []string
+	}{
+		{name: "empty", query: "", want: SaleFilter{}},
+		{
+			name:  "statuses",
+			query: "user_id=1234&status=pending,%20approved,",
+			want:  SaleFilter{UserID: "1234", Statuses: []string{StatusPending, StatusApproved}},
+		},
+		{
+			name:  "dates",
+			query: "created_from=2026-01-01&created_to=2026-01-31&updated_from=2026-02-01T10:00:00Z",
+			want: SaleFilter{
+				CreatedFrom: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+				CreatedTo:   time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+				UpdatedFrom: time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "amount in default currency",
+			query: "min_amount=10",
+			want:  SaleFilter{Currency: "ARS", MinAmount: &ars10},
+		},
+		{
+			name:  "amount in currency",
+			query: "currency=USD&max_amount=5",
+			want:  SaleFilter{Currency: "USD", MaxAmount: &usd5},
+		},
+		{
+			name:    "malformed",
+			query:   "created_from=yesterday&updated_to=2026-13-01&min_amount=ten&max_amount=1.001",
+			wantErr: []string{"created_from", "updated_to", "min_amount", "max_amount"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			got, err := ParseSaleFilter(query, "ARS")
+			if tt.wantErr != nil {
+				var fields FieldErrors
+				require.ErrorAs(t, err, &fields)
+				require.ErrorIs(t, err, ErrInvalidInput)
+				require.Equal(t, tt.wantErr, fieldNames(fields))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestService_ListSales_Filters(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC) }
+	seed := []*Sale{
+		{ID: "a", UserID: "u1", Status: StatusPending, Amount: MustParseMoney("10", "ARS"), CreatedAt: day(1), UpdatedAt: day(1)},
+		{ID: "b", UserID: "u1", Status: StatusApproved, Amount: MustParseMoney("50", "ARS"), CreatedAt: day(2), UpdatedAt: day(5)},
+		{ID: "c", UserID: "u2", Status: StatusRejected, Amount: MustParseMoney("100", "ARS"), CreatedAt: day(3), UpdatedAt: day(4)},
+		{ID: "d", UserID: "u2", Status: StatusApproved, Amount: MustParseMoney("50", "USD"), CreatedAt: day(4), UpdatedAt: day(4)},
+	}
+	ars := func(s string) *Money { m := MustParseMoney(s, "ARS"); return &m }
+
+	tests := []struct {
+		name   string
+		filter SaleFilter
+		want   []string
+	}{
+		{name: "every user", filter: SaleFilter{}, want: []string{"a", "b", "c", "d"}},
+		{name: "one user", filter: SaleFilter{UserID: "u2"}, want: []string{"c", "d"}},
+		{name: "statuses", filter: SaleFilter{Statuses: []string{StatusPending, StatusApproved}}, want: []string{"a", "b", "d"}},
+		{name: "user and statuses", filter: SaleFilter{UserID: "u1", Statuses: []string{StatusApproved, StatusRejected}}, want: []string{"b"}},
+		{name: "created range", filter: SaleFilter{CreatedFrom: day(2), CreatedTo: day(4)}, want: []string{"b", "c"}},
+		{name: "updated since", filter: SaleFilter{UpdatedFrom: day(4)}, want: []string{"b", "c", "d"}},
+		{name: "currency", filter: SaleFilter{Currency: "USD"}, want: []string{"d"}},
+		{name: "amount range", filter: SaleFilter{Currency: "ARS", MinAmount: ars("50"), MaxAmount: ars("100")}, want: []string{"b", "c"}},
+		{name: "nothing", filter: SaleFilter{UserID: "u1", Currency: "USD"}, want: []string{}},
+	}
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			for _, sale := range seed {
+				sale.Version = 1
+				require.NoError(t, storage.SetSale(ctx, sale))
+			}
+			s := NewService(storage, nil, "")
+
+			for _, tt := range tests {
+				resp, err := s.ListSales(ctx, tt.filter, PageRequest{})
+				require.NoError(t, err, tt.name)
+
+				got := []string{}
+				for _, sale := range resp.Results {
+					got = append(got, sale.ID)
+				}
+				require.Equal(t, tt.want, got, tt.name)
+				require.Equal(t, len(tt.want), resp.Metadata.Quantity, tt.name)
+			}
+		})
+	}
+}
+
+func TestService_ListSales_InvalidFilter(t *testing.T) {
+	s := NewService(NewLocalStorage(), nil, "")
+	from := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	ars := MustParseMoney("100", "ARS")
+	usd := MustParseMoney("10", "USD")
+
+	tests := []struct {
+		name   string
+		filter SaleFilter
+		want   []string
+	}{
+		{name: "unknown status", filter: SaleFilter{Statuses: []string{StatusPending, "lost"}}, want: []string{"status"}},
+		{name: "created backwards", filter: SaleFilter{CreatedFrom: from, CreatedTo: from.AddDate(0, 0, -1)}, want: []string{"created_to"}},
+		{name: "empty updated range", filter: SaleFilter{UpdatedFrom: from, UpdatedTo: from}, want: []string{"updated_to"}},
+		{name: "unknown currency", filter: SaleFilter{Currency: "XXX"}, want: []string{"currency"}},
+		{name: "bound in other currency", filter: SaleFilter{Currency: "ARS", MaxAmount: &usd}, want: []string{"max_amount"}},
+		{name: "bound without currency", filter: SaleFilter{MinAmount: &ars}, want: []string{"min_amount"}},
+		{name: "min above max", filter: SaleFilter{Currency: "ARS", MinAmount: &ars, MaxAmount: ptr(MustParseMoney("10", "ARS"))}, want: []string{"max_amount"}},
+		{
+			name:   "several",
+			filter: SaleFilter{Statuses: []string{"lost"}, CreatedFrom: from, CreatedTo: from},
+			want:   []string{"status", "created_to"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.ListSales(context.Background(), tt.filter, PageRequest{})
+			var fields FieldErrors
+			require.ErrorAs(t, err, &fields)
+			require.ErrorIs(t, err, ErrInvalidInput)
+			require.Equal(t, tt.want, fieldNames(fields))
+		})
+	}
+}
+
+func fieldNames(errs FieldErrors) []string {
+	names := make([]string, len(errs))
+	for i, e := range errs {
+		names[i] = e.Field
+	}
+	return names
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
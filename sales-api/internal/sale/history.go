@@ -0,0 +1,104 @@
+package sale
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrVersionNotFound is returned when asking for a version a sale never had,
+// or one older than its recorded history.
+var ErrVersionNotFound = errors.New("sale version not found")
+
+// HistoryEntry records one status change of a sale. Entries are written
+// together with the change they describe and never modified afterwards.
+type HistoryEntry struct {
+	SaleID string `json:"sale_id"`
+
+	// Version is the sale version the change produced.
+	Version int `json:"version"`
+
+	// FromStatus is empty for the entry written when the sale was created.
+	FromStatus string `json:"from_status,omitempty"`
+	ToStatus   string `json:"to_status"`
+	Reason     string `json:"reason,omitempty"`
+
+	Actor string    `json:"actor"`
+	At    time.Time `json:"at"`
+}
+
+// ActorSystem is recorded for changes no caller asked for.
+const ActorSystem = "system"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying who is making the request, to be
+// recorded in the history of the sales it changes.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor set by WithActor, or ActorSystem.
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return ActorSystem
+}
+
+// newHistoryEntry describes the change that left sale as it is now.
+func newHistoryEntry(ctx context.Context, sale *Sale, fromStatus string) HistoryEntry {
+	return HistoryEntry{
+		SaleID:     sale.ID,
+		Version:    sale.Version,
+		FromStatus: fromStatus,
+		ToStatus:   sale.Status,
+		Reason:     sale.StatusReason,
+		Actor:      actorFrom(ctx),
+		At:         sale.UpdatedAt,
+	}
+}
+
+// GetSaleHistory returns every recorded change of a sale, oldest first.
+// Returns ErrNotFoundSale if the sale does not exist.
+func (s *Service) GetSaleHistory(ctx context.Context, id string) ([]HistoryEntry, error) {
+	if _, err := s.storage.ReadSale(ctx, id); err != nil {
+		return nil, contextError(err)
+	}
+	history, err := s.storage.ReadSaleHistory(ctx, id)
+	if err != nil {
+		return nil, contextError(err)
+	}
+	return history, nil
+}
+
+// GetSaleAtVersion rebuilds a sale as it was right after version was
+// written, replaying its history over the fields that never change.
+// Returns ErrVersionNotFound if there is no record of that version.
+func (s *Service) GetSaleAtVersion(ctx context.Context, id string, version int) (*Sale, error) {
+	sale, err := s.storage.ReadSale(ctx, id)
+	if err != nil {
+		return nil, contextError(err)
+	}
+	if version == sale.Version {
+		return sale, nil
+	}
+	if version < 1 || version > sale.Version {
+		return nil, ErrVersionNotFound
+	}
+
+	history, err := s.storage.ReadSaleHistory(ctx, id)
+	if err != nil {
+		return nil, contextError(err)
+	}
+	for _, entry := range history {
+		if entry.Version == version {
+			sale.Status = entry.ToStatus
+			sale.StatusReason = entry.Reason
+			sale.UpdatedAt = entry.At
+			sale.Version = entry.Version
+			return sale, nil
+		}
+	}
+	return nil, ErrVersionNotFound
+}
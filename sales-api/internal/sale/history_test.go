@@ -0,0 +1,76 @@
+package sale
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_History(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1234"}`))
+	}))
+	defer mockServer.Close()
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewService(newStorage(), nil, mockServer.URL)
+
+			sale := &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")}
+			require.NoError(t, s.CreateSale(ctx, sale))
+			require.Equal(t, StatusPending, sale.Status)
+
+			_, err := s.UpdateSale(WithActor(ctx, "ana"), sale.ID, &UpdateFieldsSale{Status: StatusOnHold}, 0)
+			require.NoError(t, err)
+			// A refused change leaves no trace.
+			_, err = s.UpdateSale(WithActor(ctx, "ana"), sale.ID, &UpdateFieldsSale{Status: StatusOnHold}, 1)
+			require.ErrorIs(t, err, ErrVersionConflict)
+			approved, err := s.UpdateSale(WithActor(ctx, "bob"), sale.ID, &UpdateFieldsSale{Status: StatusApproved}, 2)
+			require.NoError(t, err)
+
+			history, err := s.GetSaleHistory(ctx, sale.ID)
+			require.NoError(t, err)
+			require.Len(t, history, 3)
+
+			require.Equal(t, HistoryEntry{
+				SaleID: sale.ID, Version: 1, ToStatus: StatusPending,
+				Reason: "account younger than 24h0m0s", Actor: ActorSystem,
+			}, withoutTime(history[0]))
+			require.Equal(t, HistoryEntry{
+				SaleID: sale.ID, Version: 2, FromStatus: StatusPending, ToStatus: StatusOnHold, Actor: "ana",
+			}, withoutTime(history[1]))
+			require.Equal(t, HistoryEntry{
+				SaleID: sale.ID, Version: 3, FromStatus: StatusOnHold, ToStatus: StatusApproved, Actor: "bob",
+			}, withoutTime(history[2]))
+			require.True(t, history[2].At.Equal(approved.UpdatedAt))
+
+			for version, status := range map[int]string{1: StatusPending, 2: StatusOnHold, 3: StatusApproved} {
+				got, err := s.GetSaleAtVersion(ctx, sale.ID, version)
+				require.NoError(t, err)
+				require.Equal(t, version, got.Version)
+				require.Equal(t, status, got.Status)
+				require.Equal(t, sale.Amount, got.Amount)
+				require.True(t, got.UpdatedAt.Equal(history[version-1].At))
+			}
+
+			_, err = s.GetSaleAtVersion(ctx, sale.ID, 4)
+			require.ErrorIs(t, err, ErrVersionNotFound)
+			_, err = s.GetSaleAtVersion(ctx, sale.ID, 0)
+			require.ErrorIs(t, err, ErrVersionNotFound)
+
+			_, err = s.GetSaleHistory(ctx, "missing")
+			require.ErrorIs(t, err, ErrNotFoundSale)
+		})
+	}
+}
+
+// withoutTime drops the timestamp, which differs between runs.
+func withoutTime(e HistoryEntry) HistoryEntry {
+	e.At = time.Time{}
+	return e
+}
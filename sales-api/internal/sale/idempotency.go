@@ -0,0 +1,198 @@
+package sale
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is replayed with a
+	// request different from the one it was first used with.
+	ErrIdempotencyKeyReused = errors.New("idempotency key already used with a different request")
+
+	// ErrIdempotencyInProgress is returned when a key is replayed while the
+	// first request that used it is still running.
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// MaxIdempotencyKeyLength bounds the size of a client-supplied key.
+const MaxIdempotencyKeyLength = 255
+
+// DefaultIdempotencyTTL is how long a completed request is remembered when no
+// TTL is configured.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyLease is how long a key stays claimed by a request that has not
+// finished yet. It is kept short so a crash mid-request does not lock the key
+// out for the whole TTL.
+const idempotencyLease = time.Minute
+
+// IdempotencyRecord is what is remembered about a request sent with an
+// idempotency key.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+
+	// Sale is the response of the first request; nil while it is running.
+	Sale *Sale
+
+	ExpiresAt time.Time
+}
+
+// IdempotencyStore keeps idempotency keys until they expire.
+type IdempotencyStore interface {
+	// ReserveKey claims key for a request with the given hash until
+	// expiresAt. If the key is already held and not expired at now, nothing
+	// changes and the existing record is returned with reserved set to false.
+	ReserveKey(ctx context.Context, key, requestHash string, now, expiresAt time.Time) (existing *IdempotencyRecord, reserved bool, err error)
+
+	// CompleteKey stores the response of the request holding key and keeps
+	// it until expiresAt.
+	CompleteKey(ctx context.Context, key string, sale *Sale, expiresAt time.Time) error
+
+	// ReleaseKey forgets key, so the request can be tried again.
+	ReleaseKey(ctx context.Context, key string) error
+}
+
+// WithIdempotency makes CreateSaleIdempotent remember keys in store for ttl.
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) Option {
+	return func(s *Service) {
+		s.idempotency = store
+		s.idempotencyTTL = ttl
+	}
+}
+
+// CreateSaleIdempotent is CreateSale guarded by a client-supplied key. The
+// first request with a key creates the sale and its response is remembered
+// for the configured TTL; a replay with the same payload gets the original
+// sale back in sale, with replayed set to true.
+//
+// A replay with a different payload fails with ErrIdempotencyKeyReused, and
+// one arriving while the first is still running with ErrIdempotencyInProgress.
+// Failed requests are not remembered, so they can be retried with the same key.
+func (s *Service) CreateSaleIdempotent(ctx context.Context, key string, sale *Sale) (replayed bool, err error) {
+	if key == "" || s.idempotency == nil {
+		return false, s.CreateSale(ctx, sale)
+	}
+	if len(key) > MaxIdempotencyKeyLength {
+		return false, ErrInvalidInput
+	}
+
+	hash := requestHash(sale)
+	now := time.Now()
+	existing, reserved, err := s.idempotency.ReserveKey(ctx, key, hash, now, now.Add(idempotencyLease))
+	if err != nil {
+		return false, contextError(err)
+	}
+	if !reserved {
+		switch {
+		case existing.RequestHash != hash:
+			return false, ErrIdempotencyKeyReused
+		case existing.Sale == nil:
+			return false, ErrIdempotencyInProgress
+		}
+		*sale = *existing.Sale
+		return true, nil
+	}
+
+	if err := s.CreateSale(ctx, sale); err != nil {
+		// Use a fresh context: the request's may be the reason we failed.
+		if relErr := s.idempotency.ReleaseKey(context.WithoutCancel(ctx), key); relErr != nil {
+			s.logger.Error("failed to release idempotency key", zap.Error(relErr), zap.String("key", key))
+		}
+		return false, err
+	}
+
+	if err := s.idempotency.CompleteKey(context.WithoutCancel(ctx), key, sale, time.Now().Add(s.idempotencyTTL)); err != nil {
+		// The sale exists; the worst case is that a replay creates another one.
+		s.logger.Error("failed to store idempotent response", zap.Error(err), zap.String("key", key))
+	}
+	return false, nil
+}
+
+// requestHash fingerprints the fields a client sends to create a sale.
+func requestHash(sale *Sale) string {
+	sum := sha256.Sum256([]byte(sale.UserID + "\x00" + sale.Amount.Decimal() + "\x00" + sale.Amount.Currency))
+	return hex.EncodeToString(sum[:])
+}
+
+// LocalIdempotencyStore is an in-memory IdempotencyStore.
+type LocalIdempotencyStore struct {
+	mu        sync.Mutex
+	m         map[string]*IdempotencyRecord
+	lastSweep time.Time
+}
+
+// NewLocalIdempotencyStore instantiates a new LocalIdempotencyStore.
+func NewLocalIdempotencyStore() *LocalIdempotencyStore {
+	return &LocalIdempotencyStore{m: map[string]*IdempotencyRecord{}}
+}
+
+// sweepInterval is how often LocalIdempotencyStore drops expired keys.
+const sweepInterval = time.Minute
+
+// ReserveKey implements IdempotencyStore.
+func (l *LocalIdempotencyStore) ReserveKey(ctx context.Context, key, requestHash string, now, expiresAt time.Time) (*IdempotencyRecord, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, rec := range l.m {
+			if !now.Before(rec.ExpiresAt) {
+				delete(l.m, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	if rec, ok := l.m[key]; ok && now.Before(rec.ExpiresAt) {
+		existing := *rec
+		return &existing, false, nil
+	}
+
+	l.m[key] = &IdempotencyRecord{Key: key, RequestHash: requestHash, ExpiresAt: expiresAt}
+	return nil, true, nil
+}
+
+// CompleteKey implements IdempotencyStore.
+func (l *LocalIdempotencyStore) CompleteKey(ctx context.Context, key string, sale *Sale, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stored := *sale
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec, ok := l.m[key]
+	if !ok {
+		return ErrNotFound
+	}
+	rec.Sale = &stored
+	rec.ExpiresAt = expiresAt
+	return nil
+}
+
+// ReleaseKey implements IdempotencyStore.
+func (l *LocalIdempotencyStore) ReleaseKey(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.m, key)
+	return nil
+}
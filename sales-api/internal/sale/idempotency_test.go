@@ -0,0 +1,136 @@
+package sale
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// idempotencyBackends returns every IdempotencyStore together with the sale
+// Storage it is deployed with.
+func idempotencyBackends(t *testing.T) map[string]func() (Storage, IdempotencyStore) {
+	return map[string]func() (Storage, IdempotencyStore){
+		"local": func() (Storage, IdempotencyStore) {
+			return NewLocalStorage(), NewLocalIdempotencyStore()
+		},
+		"sqlite": func() (Storage, IdempotencyStore) {
+			s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "sales.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s, s
+		},
+	}
+}
+
+func TestService_CreateSaleIdempotent(t *testing.T) {
+	var userCalls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userCalls.Add(1)
+		w.Write([]byte(`{"id":"1234"}`))
+	}))
+	defer mockServer.Close()
+
+	for backend, newStores := range idempotencyBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage, keys := newStores()
+			s := NewService(storage, nil, mockServer.URL, WithIdempotency(keys, time.Hour))
+
+			first := &Sale{UserID: "1234", Amount: MustParseMoney("100", "ARS")}
+			replayed, err := s.CreateSaleIdempotent(ctx, "key-1", first)
+			require.NoError(t, err)
+			require.False(t, replayed)
+
+			// A retry gets the very same sale and creates nothing.
+			calls := userCalls.Load()
+			retry := &Sale{UserID: "1234", Amount: MustParseMoney("100.00", "ARS")}
+			replayed, err = s.CreateSaleIdempotent(ctx, "key-1", retry)
+			require.NoError(t, err)
+			require.True(t, replayed)
+			require.Equal(t, first.ID, retry.ID)
+			require.Equal(t, first.Status, retry.Status)
+			require.Equal(t, first.Amount, retry.Amount)
+			require.Equal(t, calls, userCalls.Load())
+
+			all, err := storage.ReadAllSales(ctx)
+			require.NoError(t, err)
+			require.Len(t, all, 1)
+
+			// Same key, different payload.
+			_, err = s.CreateSaleIdempotent(ctx, "key-1", &Sale{UserID: "1234", Amount: MustParseMoney("101", "ARS")})
+			require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+			// Another key is another sale.
+			other := &Sale{UserID: "1234", Amount: MustParseMoney("100", "ARS")}
+			replayed, err = s.CreateSaleIdempotent(ctx, "key-2", other)
+			require.NoError(t, err)
+			require.False(t, replayed)
+			require.NotEqual(t, first.ID, other.ID)
+		})
+	}
+}
+
+func TestService_CreateSaleIdempotent_FailureReleasesKey(t *testing.T) {
+	var found atomic.Bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !found.Load() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"id":"1234"}`))
+	}))
+	defer mockServer.Close()
+
+	s := NewService(NewLocalStorage(), nil, mockServer.URL, WithIdempotency(NewLocalIdempotencyStore(), time.Hour))
+
+	_, err := s.CreateSaleIdempotent(context.Background(), "key", &Sale{UserID: "1234", Amount: MustParseMoney("1", "ARS")})
+	require.ErrorIs(t, err, ErrUserNotFound)
+
+	found.Store(true)
+	sale := &Sale{UserID: "1234", Amount: MustParseMoney("1", "ARS")}
+	replayed, err := s.CreateSaleIdempotent(context.Background(), "key", sale)
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.NotEmpty(t, sale.ID)
+}
+
+func TestIdempotencyStore_Expiry(t *testing.T) {
+	for backend, newStores := range idempotencyBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			_, keys := newStores()
+			now := time.Now()
+
+			_, reserved, err := keys.ReserveKey(ctx, "k", "h1", now, now.Add(time.Minute))
+			require.NoError(t, err)
+			require.True(t, reserved)
+
+			// Held while the first request runs.
+			rec, reserved, err := keys.ReserveKey(ctx, "k", "h1", now, now.Add(time.Minute))
+			require.NoError(t, err)
+			require.False(t, reserved)
+			require.Nil(t, rec.Sale)
+
+			sale := &Sale{ID: "s1", UserID: "u", Amount: MustParseMoney("1", "USD"), Status: StatusApproved, Version: 1}
+			require.NoError(t, keys.CompleteKey(ctx, "k", sale, now.Add(time.Hour)))
+
+			rec, reserved, err = keys.ReserveKey(ctx, "k", "h2", now.Add(30*time.Minute), now.Add(31*time.Minute))
+			require.NoError(t, err)
+			require.False(t, reserved)
+			require.Equal(t, "h1", rec.RequestHash)
+			require.Equal(t, "s1", rec.Sale.ID)
+			require.Equal(t, sale.Amount, rec.Sale.Amount)
+
+			// Once expired the key is free again.
+			_, reserved, err = keys.ReserveKey(ctx, "k", "h2", now.Add(2*time.Hour), now.Add(3*time.Hour))
+			require.NoError(t, err)
+			require.True(t, reserved)
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package sale
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrCurrencyMismatch is returned when combining amounts in different currencies.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// ErrAmountOverflow is returned when an amount or a total does not fit in
+// int64 units.
+var ErrAmountOverflow = errors.New("amount overflow")
+
+// currencyExponents maps the supported ISO-4217 codes to the number of digits
+// of their minor unit (2 for cents).
+var currencyExponents = map[string]int{
+	"ARS": 2,
+	"BOB": 2,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CLP": 0,
+	"COP": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"PEN": 2,
+	"PYG": 0,
+	"USD": 2,
+	"UYU": 2,
+}
+
+// Money is an exact amount in a given currency. Units counts the minor unit
+// of Currency (cents for USD, whole yens for JPY), so sums never drift.
+type Money struct {
+	Units    int64
+	Currency string
+}
+
+// IsCurrency reports whether code is a supported ISO-4217 currency.
+func IsCurrency(code string) bool {
+	_, ok := currencyExponents[code]
+	return ok
+}
+
+// ParseMoney parses a decimal amount such as "1234.5" in the given currency.
+// It rejects unknown currencies, exponents, and more decimals than the
+// currency has, with an error wrapping ErrInvalidInput.
+func ParseMoney(amount, currency string) (Money, error) {
+	exp, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: unknown currency %q", ErrInvalidInput, currency)
+	}
+
+	s := amount
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: invalid amount %q", ErrInvalidInput, amount)
+	}
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("%w: %s allows at most %d decimals", ErrInvalidInput, currency, exp)
+	}
+
+	digits := whole + frac + strings.Repeat("0", exp-len(frac))
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: amount %q out of range", ErrInvalidInput, amount)
+	}
+	if negative {
+		units = -units
+	}
+	return Money{Units: units, Currency: currency}, nil
+}
+
+// MustParseMoney is like ParseMoney but panics on error. Meant for constants
+// and tests.
+func MustParseMoney(amount, currency string) Money {
+	m, err := ParseMoney(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks that the currency is supported.
+func (m Money) Validate() error {
+	if !IsCurrency(m.Currency) {
+		return fmt.Errorf("%w: unknown currency %q", ErrInvalidInput, m.Currency)
+	}
+	return nil
+}
+
+// IsPositive reports whether the amount is greater than zero.
+func (m Money) IsPositive() bool {
+	return m.Units > 0
+}
+
+// Add returns m + o. Both must share the currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	sum := m.Units + o.Units
+	if (o.Units > 0 && sum < m.Units) || (o.Units < 0 && sum > m.Units) {
+		return Money{}, fmt.Errorf("%w: %w", ErrInvalidInput, ErrAmountOverflow)
+	}
+	return Money{Units: sum, Currency: m.Currency}, nil
+}
+
+// Cmp compares m and o: -1 if m < o, 0 if equal, +1 if m > o.
+// Both must share the currency.
+func (m Money) Cmp(o Money) (int, error) {
+	if m.Currency != o.Currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	switch {
+	case m.Units < o.Units:
+		return -1, nil
+	case m.Units > o.Units:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Decimal formats the amount with exactly the currency's decimals, e.g. "100.00".
+func (m Money) Decimal() string {
+	exp := currencyExponents[m.Currency]
+	units := m.Units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+	// Work on the absolute value as a string to avoid overflowing on MinInt64.
+	digits := strconv.FormatUint(absUnits(units), 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func absUnits(u int64) uint64 {
+	if u < 0 {
+		if u == math.MinInt64 {
+			return uint64(math.MaxInt64) + 1
+		}
+		return uint64(-u)
+	}
+	return uint64(u)
+}
+
+// String formats the amount followed by its currency, e.g. "100.00 ARS".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// moneyJSON is the wire format of Money.
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON writes {"amount":"100.00","currency":"ARS"}.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+// UnmarshalJSON reads the format written by MarshalJSON.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var aux moneyJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	parsed, err := ParseMoney(aux.Amount, aux.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// addTotal adds amount to the per-currency totals, keeping them sorted by
+// currency code. Returns an error wrapping ErrAmountOverflow if the total
+// in that currency no longer fits.
+func addTotal(totals []Money, amount Money) ([]Money, error) {
+	i := sort.Search(len(totals), func(i int) bool { return totals[i].Currency >= amount.Currency })
+	if i < len(totals) && totals[i].Currency == amount.Currency {
+		sum, err := totals[i].Add(amount)
+		if err != nil {
+			return totals, fmt.Errorf("%w: total in %s", ErrAmountOverflow, amount.Currency)
+		}
+		totals[i] = sum
+		return totals, nil
+	}
+	totals = append(totals, Money{})
+	copy(totals[i+1:], totals[i:])
+	totals[i] = amount
+	return totals, nil
+}
@@ -0,0 +1,92 @@
+package sale
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		amount, currency string
+		want             Money
+		wantErr          bool
+	}{
+		{amount: "100", currency: "ARS", want: Money{Units: 10000, Currency: "ARS"}},
+		{amount: "100.5", currency: "ARS", want: Money{Units: 10050, Currency: "ARS"}},
+		{amount: "0.01", currency: "USD", want: Money{Units: 1, Currency: "USD"}},
+		{amount: "1500", currency: "JPY", want: Money{Units: 1500, Currency: "JPY"}},
+		{amount: "1.234", currency: "KWD", want: Money{Units: 1234, Currency: "KWD"}},
+		{amount: "-3.20", currency: "EUR", want: Money{Units: -320, Currency: "EUR"}},
+		{amount: "1.001", currency: "USD", wantErr: true},
+		{amount: "1.5", currency: "JPY", wantErr: true},
+		{amount: "1e3", currency: "USD", wantErr: true},
+		{amount: ".5", currency: "USD", wantErr: true},
+		{amount: "", currency: "USD", wantErr: true},
+		{amount: "10", currency: "XXX", wantErr: true},
+		{amount: "99999999999999999999", currency: "USD", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.amount+" "+tt.currency, func(t *testing.T) {
+			got, err := ParseMoney(tt.amount, tt.currency)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidInput)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMoney_Decimal(t *testing.T) {
+	require.Equal(t, "100.00", Money{Units: 10000, Currency: "ARS"}.Decimal())
+	require.Equal(t, "0.05", Money{Units: 5, Currency: "USD"}.Decimal())
+	require.Equal(t, "-0.05", Money{Units: -5, Currency: "USD"}.Decimal())
+	require.Equal(t, "0.007", Money{Units: 7, Currency: "KWD"}.Decimal())
+	require.Equal(t, "1500", Money{Units: 1500, Currency: "JPY"}.Decimal())
+	require.Equal(t, "12.34 USD", Money{Units: 1234, Currency: "USD"}.String())
+}
+
+func TestMoney_SumIsExact(t *testing.T) {
+	var (
+		totals []Money
+		f      float32
+		err    error
+	)
+	for i := 0; i < 10000; i++ {
+		totals, err = addTotal(totals, MustParseMoney("0.10", "ARS"))
+		require.NoError(t, err)
+		f += 0.10
+	}
+	totals, err = addTotal(totals, MustParseMoney("5", "USD"))
+	require.NoError(t, err)
+
+	require.Equal(t, []Money{MustParseMoney("1000", "ARS"), MustParseMoney("5", "USD")}, totals)
+	require.NotEqual(t, float32(1000), f, "float32 should drift; otherwise this test proves nothing")
+
+	_, err = MustParseMoney("1", "ARS").Add(MustParseMoney("1", "USD"))
+	require.ErrorIs(t, err, ErrCurrencyMismatch)
+
+	_, err = addTotal(totals, Money{Units: math.MaxInt64, Currency: "USD"})
+	require.ErrorIs(t, err, ErrAmountOverflow)
+}
+
+func TestSale_JSON(t *testing.T) {
+	in := Sale{ID: "1", UserID: "u", Amount: MustParseMoney("19.99", "USD"), Status: StatusPending}
+
+	data, err := json.Marshal(in)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"amount":"19.99","currency":"USD"`)
+
+	var out Sale
+	require.NoError(t, json.Unmarshal(data, &out))
+	require.Equal(t, in.Amount, out.Amount)
+	require.Equal(t, in.ID, out.ID)
+
+	err = json.Unmarshal([]byte(`{"amount":"1.5","currency":"JPY"}`), &out)
+	require.ErrorIs(t, err, ErrInvalidInput)
+}
@@ -0,0 +1,407 @@
+package sale
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrOutboxEntryNotFound is returned when no outbox entry has the given
+// sequence number.
+var ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+
+// Statuses of an OutboxEntry.
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxDead      = "dead"
+)
+
+// IsOutboxStatus reports whether status is one of the OutboxEntry statuses.
+func IsOutboxStatus(status string) bool {
+	switch status {
+	case OutboxPending, OutboxDelivered, OutboxDead:
+		return true
+	}
+	return false
+}
+
+// OutboxEntry is an event waiting to be, or already, delivered. Entries are
+// written by the storage together with the change they announce, so an
+// event is never lost nor sent for a change that did not happen.
+type OutboxEntry struct {
+	// Seq orders entries by when they were written.
+	Seq   int64 `json:"seq"`
+	Event Event `json:"event"`
+
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+
+	CreatedAt     time.Time `json:"created_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	DeliveredAt   time.Time `json:"delivered_at,omitzero"`
+}
+
+// newOutboxEntry queues event for immediate delivery.
+func newOutboxEntry(event Event) *OutboxEntry {
+	return &OutboxEntry{
+		Event:         event,
+		Status:        OutboxPending,
+		CreatedAt:     event.OccurredAt,
+		NextAttemptAt: event.OccurredAt,
+	}
+}
+
+// OutboxFilter selects outbox entries. Zero fields match everything.
+type OutboxFilter struct {
+	Status string
+
+	// DueBy keeps the entries whose next attempt is not after it.
+	DueBy time.Time
+
+	// Limit caps how many entries are returned.
+	Limit int
+}
+
+// matches reports whether entry passes the filter, ignoring Limit.
+func (f OutboxFilter) matches(entry *OutboxEntry) bool {
+	if f.Status != "" && entry.Status != f.Status {
+		return false
+	}
+	if !f.DueBy.IsZero() && entry.NextAttemptAt.After(f.DueBy) {
+		return false
+	}
+	return true
+}
+
+// OutboxStorage is the part of Storage that keeps the outbox.
+type OutboxStorage interface {
+	// ReadOutbox returns the entries matching filter, oldest first.
+	ReadOutbox(ctx context.Context, filter OutboxFilter) ([]*OutboxEntry, error)
+
+	// ReadOutboxEntry returns the entry numbered seq.
+	// Returns ErrOutboxEntryNotFound if there is none.
+	ReadOutboxEntry(ctx context.Context, seq int64) (*OutboxEntry, error)
+
+	// UpdateOutboxEntry stores the delivery state of an existing entry. Its
+	// event is never changed.
+	UpdateOutboxEntry(ctx context.Context, entry *OutboxEntry) error
+}
+
+// keptDeliveredEntries is how many delivered entries a LocalStorage keeps
+// around to be listed or replayed; older ones are dropped.
+const keptDeliveredEntries = 1000
+
+// localOutbox is the outbox of a LocalStorage, guarded by its lock. Entries
+// are kept by Seq and the pending ones are indexed, so a dispatch pass never
+// goes through the delivered ones, of which only the latest keep are kept.
+type localOutbox struct {
+	entries   map[int64]*OutboxEntry
+	lastSeq   int64
+	pending   []int64          // Seq of the pending entries, ascending
+	delivered []deliveredEntry // oldest delivery first
+	keep      int
+}
+
+// deliveredEntry remembers when an entry was delivered, so an entry replayed
+// and delivered again is dropped for its latest delivery only.
+type deliveredEntry struct {
+	seq int64
+	at  time.Time
+}
+
+func newLocalOutbox() *localOutbox {
+	return &localOutbox{entries: map[int64]*OutboxEntry{}, keep: keptDeliveredEntries}
+}
+
+// add queues event for delivery with the next Seq.
+func (o *localOutbox) add(event Event) {
+	o.lastSeq++
+	entry := newOutboxEntry(event)
+	entry.Seq = o.lastSeq
+	o.entries[entry.Seq] = entry
+	o.pending = append(o.pending, entry.Seq)
+}
+
+// read returns copies of the entries matching filter, oldest first.
+func (o *localOutbox) read(filter OutboxFilter) []*OutboxEntry {
+	seqs := o.pending
+	if filter.Status != OutboxPending {
+		seqs = slices.Sorted(maps.Keys(o.entries))
+	}
+
+	entries := []*OutboxEntry{}
+	for _, seq := range seqs {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		if entry := o.entries[seq]; filter.matches(entry) {
+			e := *entry
+			entries = append(entries, &e)
+		}
+	}
+	return entries
+}
+
+// get returns a copy of the entry numbered seq.
+func (o *localOutbox) get(seq int64) (*OutboxEntry, error) {
+	entry, ok := o.entries[seq]
+	if !ok {
+		return nil, ErrOutboxEntryNotFound
+	}
+	e := *entry
+	return &e, nil
+}
+
+// update stores the delivery state of entry, keeping the pending index in
+// step and dropping the delivered entries beyond keep.
+func (o *localOutbox) update(entry *OutboxEntry) error {
+	stored, ok := o.entries[entry.Seq]
+	if !ok {
+		return ErrOutboxEntryNotFound
+	}
+
+	was := stored.Status
+	stored.Status = entry.Status
+	stored.Attempts = entry.Attempts
+	stored.LastError = entry.LastError
+	stored.NextAttemptAt = entry.NextAttemptAt
+	stored.DeliveredAt = entry.DeliveredAt
+
+	i, indexed := slices.BinarySearch(o.pending, stored.Seq)
+	switch {
+	case stored.Status == OutboxPending && !indexed:
+		o.pending = slices.Insert(o.pending, i, stored.Seq)
+	case stored.Status != OutboxPending && indexed:
+		o.pending = slices.Delete(o.pending, i, i+1)
+	}
+
+	if stored.Status == OutboxDelivered && was != OutboxDelivered {
+		o.delivered = append(o.delivered, deliveredEntry{seq: stored.Seq, at: stored.DeliveredAt})
+		o.prune()
+	}
+	return nil
+}
+
+// prune drops the oldest delivered entries beyond keep.
+func (o *localOutbox) prune() {
+	for len(o.delivered) > o.keep {
+		d := o.delivered[0]
+		o.delivered = o.delivered[1:]
+		if entry, ok := o.entries[d.seq]; ok && entry.Status == OutboxDelivered && entry.DeliveredAt.Equal(d.at) {
+			delete(o.entries, d.seq)
+		}
+	}
+}
+
+// DispatcherConfig tunes a Dispatcher. Zero fields take the defaults of
+// DefaultDispatcherConfig.
+type DispatcherConfig struct {
+	// Interval is how often the outbox is checked for due entries.
+	Interval time.Duration
+
+	// Batch bounds how many entries one pass delivers.
+	Batch int
+
+	// MaxAttempts is how many times an entry is tried before it is marked
+	// dead, waiting from RetryWait up to RetryMaxWait, doubling, in between.
+	MaxAttempts  int
+	RetryWait    time.Duration
+	RetryMaxWait time.Duration
+}
+
+// DefaultDispatcherConfig returns the settings used for fields left empty.
+func DefaultDispatcherConfig() DispatcherConfig {
+	return DispatcherConfig{
+		Interval:     time.Second,
+		Batch:        100,
+		MaxAttempts:  10,
+		RetryWait:    time.Second,
+		RetryMaxWait: 5 * time.Minute,
+	}
+}
+
+// DispatchResult counts what one DispatchPending pass did.
+type DispatchResult struct {
+	Delivered int
+	Retried   int
+	Dead      int
+}
+
+// Dispatcher delivers the outbox to publishers. An entry counts as delivered
+// once every publisher accepted it; if any fails, all of them get it again
+// later, so delivery is at least once.
+type Dispatcher struct {
+	storage    OutboxStorage
+	publishers []Publisher
+	cfg        DispatcherConfig
+	logger     *zap.Logger
+	now        func() time.Time
+}
+
+// NewDispatcher builds a Dispatcher that delivers the outbox kept in storage
+// to publishers.
+func NewDispatcher(storage OutboxStorage, cfg DispatcherConfig, logger *zap.Logger, publishers ...Publisher) *Dispatcher {
+	def := DefaultDispatcherConfig()
+	if cfg.Interval == 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.Batch == 0 {
+		cfg.Batch = def.Batch
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.RetryWait == 0 {
+		cfg.RetryWait = def.RetryWait
+	}
+	if cfg.RetryMaxWait == 0 {
+		cfg.RetryMaxWait = def.RetryMaxWait
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Dispatcher{
+		storage:    storage,
+		publishers: publishers,
+		cfg:        cfg,
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+// DispatchPending tries every due entry once, up to a batch, oldest first.
+// An entry that fails does not hold back the ones after it.
+func (d *Dispatcher) DispatchPending(ctx context.Context) (DispatchResult, error) {
+	var result DispatchResult
+
+	now := d.now()
+	entries, err := d.storage.ReadOutbox(ctx, OutboxFilter{Status: OutboxPending, DueBy: now, Limit: d.cfg.Batch})
+	if err != nil {
+		return result, contextError(err)
+	}
+
+	for _, entry := range entries {
+		entry.Attempts++
+		err := d.publish(ctx, entry.Event)
+		switch {
+		case err == nil:
+			entry.Status = OutboxDelivered
+			entry.DeliveredAt = d.now()
+			entry.LastError = ""
+			result.Delivered++
+		case entry.Attempts >= d.cfg.MaxAttempts:
+			entry.Status = OutboxDead
+			entry.LastError = err.Error()
+			result.Dead++
+			d.logger.Error("giving up on event", zap.Error(err), zap.String("event_id", entry.Event.ID),
+				zap.Int64("seq", entry.Seq), zap.Int("attempts", entry.Attempts))
+		default:
+			entry.NextAttemptAt = now.Add(backoff(entry.Attempts, d.cfg.RetryWait, d.cfg.RetryMaxWait))
+			entry.LastError = err.Error()
+			result.Retried++
+			d.logger.Warn("event delivery failed", zap.Error(err), zap.String("event_id", entry.Event.ID),
+				zap.Int64("seq", entry.Seq), zap.Int("attempt", entry.Attempts), zap.Time("next_attempt_at", entry.NextAttemptAt))
+		}
+
+		if err := d.storage.UpdateOutboxEntry(ctx, entry); err != nil {
+			return result, contextError(err)
+		}
+	}
+	return result, nil
+}
+
+// publish hands event to every publisher and joins their errors.
+func (d *Dispatcher) publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, p := range d.publishers {
+		if err := p.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// backoff is the wait after the given number of failed attempts: wait,
+// doubling with every further attempt, up to maxWait.
+func backoff(attempts int, wait, maxWait time.Duration) time.Duration {
+	for i := 1; i < attempts && wait < maxWait; i++ {
+		wait *= 2
+	}
+	return min(wait, maxWait)
+}
+
+// Run dispatches every Interval until ctx is done. A full batch is followed
+// right away by another pass, so a backlog drains without waiting.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			result, err := d.DispatchPending(ctx)
+			if err != nil {
+				d.logger.Error("outbox dispatch failed", zap.Error(err))
+				break
+			}
+			if result.Delivered+result.Retried+result.Dead < d.cfg.Batch {
+				break
+			}
+		}
+	}
+}
+
+// Entries returns up to limit outbox entries in status, oldest first. An
+// empty status matches every status and a zero limit means no limit.
+func (d *Dispatcher) Entries(ctx context.Context, status string, limit int) ([]*OutboxEntry, error) {
+	if status != "" && !IsOutboxStatus(status) {
+		return nil, ErrInvalidInput
+	}
+	entries, err := d.storage.ReadOutbox(ctx, OutboxFilter{Status: status, Limit: limit})
+	if err != nil {
+		return nil, contextError(err)
+	}
+	return entries, nil
+}
+
+// Entry returns the outbox entry numbered seq.
+func (d *Dispatcher) Entry(ctx context.Context, seq int64) (*OutboxEntry, error) {
+	entry, err := d.storage.ReadOutboxEntry(ctx, seq)
+	if err != nil {
+		return nil, contextError(err)
+	}
+	return entry, nil
+}
+
+// Replay queues an entry for delivery again right away, whatever its status,
+// with a fresh count of attempts. Typically used on dead entries once the
+// receiver is fixed.
+func (d *Dispatcher) Replay(ctx context.Context, seq int64) (*OutboxEntry, error) {
+	entry, err := d.storage.ReadOutboxEntry(ctx, seq)
+	if err != nil {
+		return nil, contextError(err)
+	}
+
+	entry.Status = OutboxPending
+	entry.Attempts = 0
+	entry.LastError = ""
+	entry.NextAttemptAt = d.now()
+	entry.DeliveredAt = time.Time{}
+	if err := d.storage.UpdateOutboxEntry(ctx, entry); err != nil {
+		return nil, contextError(err)
+	}
+
+	d.logger.Info("outbox entry replayed", zap.Int64("seq", seq), zap.String("event_id", entry.Event.ID))
+	return entry, nil
+}
@@ -0,0 +1,166 @@
+package sale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_QueuesEventsWithHistory(t *testing.T) {
+	users := &mockUserClient{
+		mockGetUser: func(id string) (*User, error) {
+			return &User{ID: id, CreatedAt: time.Now()}, nil
+		},
+	}
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil, "", WithUserClient(users))
+
+			sale := &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")}
+			require.NoError(t, s.CreateSale(ctx, sale))
+			_, err := s.UpdateSale(WithActor(ctx, "ana"), sale.ID, &UpdateFieldsSale{Status: StatusApproved}, 0)
+			require.NoError(t, err)
+			// A refused change announces nothing.
+			_, err = s.UpdateSale(ctx, sale.ID, &UpdateFieldsSale{Status: StatusPending}, 0)
+			require.ErrorIs(t, err, ErrTransactionInvalid)
+
+			entries, err := storage.ReadOutbox(ctx, OutboxFilter{})
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+
+			created := entries[0]
+			require.Equal(t, int64(1), created.Seq)
+			require.Equal(t, OutboxPending, created.Status)
+			require.Equal(t, EventSaleCreated, created.Event.Type)
+			require.Equal(t, "sale.created:"+sale.ID+":1", created.Event.ID)
+			require.Equal(t, StatusPending, created.Event.ToStatus)
+			require.Equal(t, sale.Amount, created.Event.Sale.Amount)
+
+			changed := entries[1]
+			require.Equal(t, EventSaleStatusChanged, changed.Event.Type)
+			require.Equal(t, "1234", changed.Event.UserID)
+			require.Equal(t, 2, changed.Event.Version)
+			require.Equal(t, StatusPending, changed.Event.FromStatus)
+			require.Equal(t, StatusApproved, changed.Event.ToStatus)
+			require.Equal(t, "ana", changed.Event.Actor)
+			require.Equal(t, StatusApproved, changed.Event.Sale.Status)
+		})
+	}
+}
+
+func TestDispatcher_RetriesAndDeadLetters(t *testing.T) {
+	users := &mockUserClient{
+		mockGetUser: func(id string) (*User, error) {
+			return &User{ID: id, CreatedAt: time.Now()}, nil
+		},
+	}
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil, "", WithUserClient(users))
+
+			down := true
+			var delivered []string
+			publisher := PublisherFunc(func(_ context.Context, event Event) error {
+				if down {
+					return errors.New("receiver down")
+				}
+				delivered = append(delivered, event.ID)
+				return nil
+			})
+
+			sale := &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")}
+			require.NoError(t, s.CreateSale(ctx, sale))
+
+			now := time.Now()
+			d := NewDispatcher(storage, DispatcherConfig{MaxAttempts: 2, RetryWait: time.Second}, nil, publisher)
+			d.now = func() time.Time { return now }
+
+			result, err := d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Retried: 1}, result)
+
+			// Not due until the wait is over.
+			result, err = d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{}, result)
+
+			now = now.Add(time.Second)
+			result, err = d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Dead: 1}, result)
+
+			entry, err := d.Entry(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, OutboxDead, entry.Status)
+			require.Equal(t, 2, entry.Attempts)
+			require.Equal(t, "receiver down", entry.LastError)
+
+			down = false
+			_, err = d.Replay(ctx, 1)
+			require.NoError(t, err)
+			result, err = d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Delivered: 1}, result)
+			require.Equal(t, []string{"sale.created:" + sale.ID + ":1"}, delivered)
+
+			pending, err := d.Entries(ctx, OutboxPending, 0)
+			require.NoError(t, err)
+			require.Empty(t, pending)
+			_, err = d.Entry(ctx, 2)
+			require.ErrorIs(t, err, ErrOutboxEntryNotFound)
+		})
+	}
+}
+
+func TestLocalOutbox_KeepsLatestDelivered(t *testing.T) {
+	o := newLocalOutbox()
+	o.keep = 2
+	for i := range 4 {
+		o.add(Event{ID: fmt.Sprint("event-", i+1)})
+	}
+
+	deliver := func(seq int64, at time.Time) {
+		entry, err := o.get(seq)
+		require.NoError(t, err)
+		entry.Status = OutboxDelivered
+		entry.DeliveredAt = at
+		require.NoError(t, o.update(entry))
+	}
+	now := time.Now()
+	deliver(1, now)
+	deliver(3, now)
+	require.Equal(t, []int64{2, 4}, o.pending)
+
+	// Replaying puts an entry back in order among the pending ones.
+	entry, err := o.get(1)
+	require.NoError(t, err)
+	entry.Status = OutboxPending
+	entry.DeliveredAt = time.Time{}
+	require.NoError(t, o.update(entry))
+	pending := o.read(OutboxFilter{Status: OutboxPending})
+	require.Len(t, pending, 3)
+	require.Equal(t, []int64{1, 2, 4}, []int64{pending[0].Seq, pending[1].Seq, pending[2].Seq})
+
+	// Only the two latest deliveries are kept: 3 is dropped, while 1 is kept
+	// for its second delivery.
+	deliver(1, now.Add(time.Second))
+	deliver(2, now.Add(2*time.Second))
+	_, err = o.get(3)
+	require.ErrorIs(t, err, ErrOutboxEntryNotFound)
+
+	delivered := o.read(OutboxFilter{Status: OutboxDelivered})
+	require.Len(t, delivered, 2)
+	require.Equal(t, int64(1), delivered[0].Seq)
+	require.Equal(t, int64(2), delivered[1].Seq)
+	require.Len(t, o.read(OutboxFilter{}), 3)
+}
@@ -0,0 +1,185 @@
+package sale
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Sort keys of a sale listing.
+const (
+	SortCreatedAt = "created_at"
+	SortUpdatedAt = "updated_at"
+
+	// SortAmount orders by currency first, then by amount, since amounts in
+	// different currencies do not compare.
+	SortAmount = "amount"
+)
+
+// IsSort reports whether sort is one of the sort keys of a sale listing.
+func IsSort(sort string) bool {
+	switch sort {
+	case SortCreatedAt, SortUpdatedAt, SortAmount:
+		return true
+	}
+	return false
+}
+
+// Page sizes of a sale listing.
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// PageRequest asks for one page of a sale listing.
+type PageRequest struct {
+	// Sort is one of the Sort keys, SortCreatedAt if empty. Ties are broken
+	// by ID, so the order is the same on every call.
+	Sort string
+	Desc bool
+
+	// Limit is at most MaxPageSize, DefaultPageSize if zero.
+	Limit int
+
+	// Cursor is the NextCursor of the previous page, empty for the first
+	// one. It remembers the order it was made for, so Sort and Desc may be
+	// left empty when it is given.
+	Cursor string
+}
+
+// SalePage is what the storage is asked for: up to Limit sales in the
+// given order, right after After, or from the first if nil.
+type SalePage struct {
+	Sort  string
+	Desc  bool
+	After *SaleCursor
+	Limit int
+}
+
+// SaleCursor is the position of a sale in a listing. Clients get it
+// encoded, as an opaque string.
+type SaleCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+
+	sortKey
+}
+
+// sortKey is what a listing compares sales by, field by field: Currency is
+// only set when sorting by amount, and Value holds unix nanoseconds or
+// minor units.
+type sortKey struct {
+	Currency string `json:"c,omitempty"`
+	Value    int64  `json:"v"`
+	ID       string `json:"id"`
+}
+
+// keyOf returns the key of sale in a listing sorted by sort.
+func keyOf(sale *Sale, sort string) sortKey {
+	switch sort {
+	case SortUpdatedAt:
+		return sortKey{Value: toUnixNano(sale.UpdatedAt), ID: sale.ID}
+	case SortAmount:
+		return sortKey{Currency: sale.Amount.Currency, Value: sale.Amount.Units, ID: sale.ID}
+	default:
+		return sortKey{Value: toUnixNano(sale.CreatedAt), ID: sale.ID}
+	}
+}
+
+func (k sortKey) compare(o sortKey) int {
+	if c := cmp.Compare(k.Currency, o.Currency); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(k.Value, o.Value); c != 0 {
+		return c
+	}
+	return cmp.Compare(k.ID, o.ID)
+}
+
+// cursorAt returns the position of sale in a listing in page's order.
+func cursorAt(sale *Sale, page SalePage) *SaleCursor {
+	return &SaleCursor{Sort: page.Sort, Desc: page.Desc, sortKey: keyOf(sale, page.Sort)}
+}
+
+// Encode returns the opaque form of c handed to clients.
+func (c *SaleCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses the result of SaleCursor.Encode.
+func decodeCursor(s string) (*SaleCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	var c SaleCursor
+	if err := json.Unmarshal(data, &c); err != nil || !IsSort(c.Sort) || c.ID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	return &c, nil
+}
+
+// salePage checks req and turns it into the SalePage asked to the storage.
+func salePage(req PageRequest) (SalePage, error) {
+	page := SalePage{Sort: req.Sort, Desc: req.Desc, Limit: req.Limit}
+
+	if req.Cursor != "" {
+		after, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return page, err
+		}
+		if page.Sort != "" && (page.Sort != after.Sort || page.Desc != after.Desc) {
+			return page, fmt.Errorf("%w: cursor was made for another sort", ErrInvalidInput)
+		}
+		page.Sort, page.Desc, page.After = after.Sort, after.Desc, after
+	}
+
+	if page.Sort == "" {
+		page.Sort = SortCreatedAt
+	}
+	if !IsSort(page.Sort) {
+		return page, fmt.Errorf("%w: unknown sort %q", ErrInvalidInput, page.Sort)
+	}
+	switch {
+	case page.Limit == 0:
+		page.Limit = DefaultPageSize
+	case page.Limit < 0 || page.Limit > MaxPageSize:
+		return page, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidInput, MaxPageSize)
+	}
+	return page, nil
+}
+
+// paginate sorts sales in page's order and returns the page of them, for
+// storages that filter in memory.
+func paginate(sales []*Sale, page SalePage) []*Sale {
+	keys := make(map[*Sale]sortKey, len(sales))
+	for _, sale := range sales {
+		keys[sale] = keyOf(sale, page.Sort)
+	}
+	order := func(k, o sortKey) int {
+		if page.Desc {
+			return o.compare(k)
+		}
+		return k.compare(o)
+	}
+	slices.SortFunc(sales, func(a, b *Sale) int {
+		return order(keys[a], keys[b])
+	})
+
+	if page.After != nil {
+		start, _ := slices.BinarySearchFunc(sales, page.After.sortKey, func(sale *Sale, after sortKey) int {
+			if order(keys[sale], after) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		sales = sales[start:]
+	}
+	if len(sales) > page.Limit {
+		sales = sales[:page.Limit]
+	}
+	return sales
+}
@@ -0,0 +1,106 @@
+package sale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_PaginatesSales(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	seed := []struct {
+		id      string
+		amount  Money
+		created time.Duration
+		updated time.Duration
+	}{
+		{"a", MustParseMoney("30", "ARS"), 1 * time.Hour, 9 * time.Hour},
+		{"b", MustParseMoney("10", "USD"), 2 * time.Hour, 2 * time.Hour},
+		{"c", MustParseMoney("20", "ARS"), 2 * time.Hour, 7 * time.Hour},
+		{"d", MustParseMoney("10", "ARS"), 3 * time.Hour, 3 * time.Hour},
+		{"e", MustParseMoney("20", "ARS"), 4 * time.Hour, 8 * time.Hour},
+	}
+
+	tests := []struct {
+		sort string
+		desc bool
+		want []string
+	}{
+		{sort: "", want: []string{"a", "b", "c", "d", "e"}},
+		{sort: SortCreatedAt, desc: true, want: []string{"e", "d", "c", "b", "a"}},
+		{sort: SortUpdatedAt, want: []string{"b", "d", "c", "e", "a"}},
+		{sort: SortAmount, want: []string{"d", "c", "e", "a", "b"}},
+		{sort: SortAmount, desc: true, want: []string{"b", "a", "e", "c", "d"}},
+	}
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			for _, sd := range seed {
+				require.NoError(t, storage.SetSale(ctx, &Sale{
+					ID: sd.id, UserID: "1234", Amount: sd.amount, Status: StatusPending,
+					CreatedAt: base.Add(sd.created), UpdatedAt: base.Add(sd.updated), Version: 1,
+				}))
+			}
+			require.NoError(t, storage.SetSale(ctx, &Sale{
+				ID: "other", UserID: "5678", Amount: MustParseMoney("1", "ARS"), Status: StatusPending,
+				CreatedAt: base, UpdatedAt: base, Version: 1,
+			}))
+			s := NewService(storage, nil, "")
+
+			for _, tt := range tests {
+				var got []string
+				req := PageRequest{Sort: tt.sort, Desc: tt.desc, Limit: 2}
+				for pages := 0; ; pages++ {
+					require.Less(t, pages, 3, "sort %q desc %v does not end", tt.sort, tt.desc)
+
+					resp, err := s.ListSales(ctx, SaleFilter{UserID: "1234"}, req)
+					require.NoError(t, err)
+					require.LessOrEqual(t, len(resp.Results), 2)
+					require.Equal(t, 5, resp.Metadata.Quantity)
+					for _, sale := range resp.Results {
+						got = append(got, sale.ID)
+					}
+					if resp.NextCursor == "" {
+						break
+					}
+					// The cursor remembers the order.
+					req = PageRequest{Cursor: resp.NextCursor, Limit: 2}
+				}
+				require.Equal(t, tt.want, got, "sort %q desc %v", tt.sort, tt.desc)
+			}
+
+			// A page that ends exactly at the last sale has no next one.
+			resp, err := s.ListSales(ctx, SaleFilter{UserID: "1234"}, PageRequest{Limit: 5})
+			require.NoError(t, err)
+			require.Len(t, resp.Results, 5)
+			require.Empty(t, resp.NextCursor)
+		})
+	}
+}
+
+func TestService_PaginatesSales_InvalidRequest(t *testing.T) {
+	s := NewService(NewLocalStorage(), nil, "")
+	cursor := (&SaleCursor{Sort: SortAmount, sortKey: sortKey{Currency: "ARS", Value: 100, ID: "a"}}).Encode()
+
+	tests := map[string]PageRequest{
+		"unknown sort":     {Sort: "user_id"},
+		"negative limit":   {Limit: -1},
+		"limit too big":    {Limit: MaxPageSize + 1},
+		"malformed cursor": {Cursor: "not a cursor"},
+		"other sort":       {Sort: SortCreatedAt, Cursor: cursor},
+		"other direction":  {Sort: SortAmount, Desc: true, Cursor: cursor},
+	}
+	for name, req := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := s.ListSales(context.Background(), SaleFilter{UserID: "1234"}, req)
+			require.ErrorIs(t, err, ErrInvalidInput)
+		})
+	}
+
+	_, err := s.ListSales(context.Background(), SaleFilter{UserID: "1234"}, PageRequest{Sort: SortAmount, Cursor: cursor})
+	require.NoError(t, err)
+}
@@ -0,0 +1,180 @@
+package sale
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RuleUserVerification is the approval rule recorded on sales accepted in
+// degraded mode, and on those the Reconciler rejects.
+const RuleUserVerification = "user_verification"
+
+// ReasonUserNotFound is the reason given when a sale accepted in degraded
+// mode turns out to have an unknown buyer.
+const ReasonUserNotFound = "user_not_found"
+
+// ReasonUserNotActive is the reason given when a sale accepted in degraded
+// mode turns out to have a suspended or blocked buyer.
+const ReasonUserNotActive = "user_not_active"
+
+// ActorReconciler is recorded in the history of the sales the Reconciler moves.
+const ActorReconciler = "system:reconciler"
+
+// ReconcileResult counts what one ReconcilePending pass did.
+type ReconcileResult struct {
+	// Verified sales moved to the status chosen by the approval policy.
+	Verified int
+
+	// Rejected sales had a buyer unknown to users-api.
+	Rejected int
+
+	// Skipped sales changed under the reconciler and were left alone.
+	Skipped int
+
+	// Unverified sales have a buyer users-api answered for with a payload
+	// that could not be decoded. They are left for a later pass.
+	Unverified int
+
+	// Stopped is true when users-api was still unreachable, so the pass gave
+	// up before going through every sale.
+	Stopped bool
+}
+
+// ReconcilePending verifies up to batch sales in pending_verification, oldest
+// first. Each one moves to the status the approval policy chooses, or to
+// rejected with ReasonUserNotFound if users-api does not know the buyer and
+// with ReasonUserNotActive if the buyer is suspended or blocked. The
+// pass stops at the first sale whose buyer still cannot be looked up, but
+// goes on past one whose buyer comes back undecodable.
+func (s *Service) ReconcilePending(ctx context.Context, batch int) (ReconcileResult, error) {
+	var result ReconcileResult
+
+	sales, err := s.storage.ReadSalesByStatus(ctx, StatusPendingVerification, batch)
+	if err != nil {
+		return result, contextError(err)
+	}
+
+	ctx = WithActor(ctx, ActorReconciler)
+	for _, sale := range sales {
+		decision, err := s.verify(ctx, sale)
+		if errors.Is(err, ErrInvalidUserPayload) {
+			result.Unverified++
+			continue
+		}
+		if errors.Is(err, ErrTryingToGetUser) {
+			result.Stopped = true
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		err = s.settle(ctx, sale, decision)
+		switch {
+		case errors.Is(err, ErrVersionConflict), errors.Is(err, ErrTransactionInvalid):
+			// Someone else moved it first, e.g. cancelled it.
+			result.Skipped++
+		case err != nil:
+			return result, err
+		case decision.Status == StatusRejected && decision.Rule == RuleUserVerification:
+			result.Rejected++
+		default:
+			result.Verified++
+		}
+	}
+	return result, nil
+}
+
+// verify looks the buyer of sale up and decides where the sale goes.
+func (s *Service) verify(ctx context.Context, sale *Sale) (Decision, error) {
+	buyer, err := s.users.GetUser(ctx, sale.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return Decision{Status: StatusRejected, Rule: RuleUserVerification, Reason: ReasonUserNotFound}, nil
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+	// Judge the sale as of when it was placed, not when users-api came back.
+	if buyer.checkActive(sale.CreatedAt) != nil {
+		return Decision{Status: StatusRejected, Rule: RuleUserVerification, Reason: ReasonUserNotActive}, nil
+	}
+	return s.decide(ctx, sale, buyer, sale.CreatedAt)
+}
+
+// settle moves a pending_verification sale to the status of decision, as
+// long as nobody changed it since it was read.
+func (s *Service) settle(ctx context.Context, sale *Sale, decision Decision) error {
+	if err := s.machine.systemTransition(sale, decision.Status); err != nil {
+		return err
+	}
+
+	readVersion, fromStatus := sale.Version, sale.Status
+	sale.Status = decision.Status
+	sale.StatusReason = decision.Reason
+	sale.Approval = &decision
+	sale.UpdatedAt = time.Now()
+	sale.Version++
+
+	entry := newHistoryEntry(ctx, sale, fromStatus)
+	if err := s.storage.CompareAndSetSale(ctx, sale, readVersion, entry); err != nil {
+		return contextError(err)
+	}
+	s.broadcast(sale, entry)
+	s.logger.Info("sale verified", zap.String("id", sale.ID), zap.String("status", sale.Status),
+		zap.String("rule", decision.Rule))
+	return nil
+}
+
+// Reconciler periodically verifies the sales accepted in degraded mode.
+type Reconciler struct {
+	service  *Service
+	interval time.Duration
+	batch    int
+}
+
+// DefaultReconcileInterval is how often a Reconciler runs when no interval
+// is configured.
+const DefaultReconcileInterval = 30 * time.Second
+
+// reconcileBatch bounds how many sales one pass looks at.
+const reconcileBatch = 100
+
+// NewReconciler builds a Reconciler that runs every interval.
+func NewReconciler(service *Service, interval time.Duration) *Reconciler {
+	return &Reconciler{service: service, interval: interval, batch: reconcileBatch}
+}
+
+// Run reconciles every interval until ctx is done. A full batch is followed
+// right away by another pass, so a backlog drains without waiting.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			result, err := r.service.ReconcilePending(ctx, r.batch)
+			if err != nil {
+				r.service.logger.Error("reconciliation failed", zap.Error(err))
+				break
+			}
+			if result.Verified+result.Rejected+result.Skipped+result.Unverified > 0 {
+				r.service.logger.Info("reconciliation pass", zap.Int("verified", result.Verified),
+					zap.Int("rejected", result.Rejected), zap.Int("skipped", result.Skipped),
+					zap.Int("unverified", result.Unverified), zap.Bool("stopped", result.Stopped))
+			}
+			moved := result.Verified + result.Rejected
+			if result.Stopped || moved == 0 || moved+result.Skipped+result.Unverified < r.batch {
+				break
+			}
+		}
+	}
+}
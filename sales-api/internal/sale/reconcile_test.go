@@ -0,0 +1,87 @@
+package sale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_DegradedModeAndReconcile(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			down := true
+			users := &mockUserClient{
+				mockGetUser: func(id string) (*User, error) {
+					switch {
+					case down:
+						return nil, ErrUsersAPIUnavailable
+					case id == "ghost":
+						return nil, ErrUserNotFound
+					case id == "garbled":
+						return nil, ErrInvalidUserPayload
+					}
+					return &User{ID: id, CreatedAt: time.Now().Add(-48 * time.Hour)}, nil
+				},
+			}
+			storage := newStorage()
+
+			// Without degraded mode an outage fails the sale.
+			strict := NewService(storage, nil, "", WithUserClient(users))
+			err := strict.CreateSale(ctx, &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")})
+			require.ErrorIs(t, err, ErrTryingToGetUser)
+
+			s := NewService(storage, nil, "", WithUserClient(users), WithDegradedMode())
+			// The oldest sale cannot be verified, but does not hold the others up.
+			garbled := &Sale{UserID: "garbled", Amount: MustParseMoney("10", "ARS")}
+			require.NoError(t, s.CreateSale(ctx, garbled))
+			good := &Sale{UserID: "1234", Amount: MustParseMoney("10", "ARS")}
+			require.NoError(t, s.CreateSale(ctx, good))
+			require.Equal(t, StatusPendingVerification, good.Status)
+			require.Equal(t, RuleUserVerification, good.Approval.Rule)
+			ghost := &Sale{UserID: "ghost", Amount: MustParseMoney("10", "ARS")}
+			require.NoError(t, s.CreateSale(ctx, ghost))
+
+			informe, err := s.ListSales(ctx, SaleFilter{UserID: "1234"}, PageRequest{})
+			require.NoError(t, err)
+			require.Equal(t, 1, informe.Metadata.PendingVerification)
+
+			// Still down: nothing moves.
+			result, err := s.ReconcilePending(ctx, 10)
+			require.NoError(t, err)
+			require.Equal(t, ReconcileResult{Stopped: true}, result)
+
+			down = false
+			result, err = s.ReconcilePending(ctx, 10)
+			require.NoError(t, err)
+			require.Equal(t, ReconcileResult{Verified: 1, Rejected: 1, Unverified: 1}, result)
+
+			got, err := s.GetSale(ctx, good.ID)
+			require.NoError(t, err)
+			require.Equal(t, StatusApproved, got.Status)
+			require.Equal(t, "auto_approve", got.Approval.Rule)
+			require.Equal(t, 2, got.Version)
+
+			got, err = s.GetSale(ctx, ghost.ID)
+			require.NoError(t, err)
+			require.Equal(t, StatusRejected, got.Status)
+			require.Equal(t, ReasonUserNotFound, got.Approval.Reason)
+
+			history, err := s.GetSaleHistory(ctx, ghost.ID)
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+			require.Equal(t, StatusPendingVerification, history[1].FromStatus)
+			require.Equal(t, ActorReconciler, history[1].Actor)
+
+			// Nothing left to do but the sale still waiting for its buyer.
+			result, err = s.ReconcilePending(ctx, 10)
+			require.NoError(t, err)
+			require.Equal(t, ReconcileResult{Unverified: 1}, result)
+			got, err = s.GetSale(ctx, garbled.ID)
+			require.NoError(t, err)
+			require.Equal(t, StatusPendingVerification, got.Status)
+		})
+	}
+}
@@ -1,11 +1,11 @@
 package sale
 
 import (
+	"context"
 	"errors"
-	"math/rand"
+	"fmt"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
@@ -15,156 +15,295 @@ var (
 	ErrNoFieldsToUpdate   = errors.New("no fields to update")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrSaleNotFound       = errors.New("sale not found")
-	ErrTransactionInvalid = errors.New("transicion invalida")
+	ErrTransactionInvalid = errors.New("transaccion invalida")
 	ErrNotUserFound       = errors.New("not user found")
 	ErrTryingToGetUser    = errors.New("error trying to get user")
+	ErrTimeout            = errors.New("request timed out")
+)
+
+// contextError translates a context error into the service's own errors, so
+// callers can tell a deadline apart from any other failure.
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return err
+}
+
+// Default durations used by DefaultStateMachine when none is configured.
+const (
+	DefaultExpireAfter  = 72 * time.Hour
+	DefaultRefundWindow = 30 * 24 * time.Hour
 )
 
 // Service provides high-level user management operations on a LocalStorage backend.
 type Service struct {
-	storage    Storage
-	logger     *zap.Logger
-	userClient *resty.Client
-	urlUser    string
+	storage Storage
+	logger  *zap.Logger
+	users   UserClient
+	machine *StateMachine
+	policy  ApprovalPolicy
+
+	// degraded accepts sales while users-api is unreachable.
+	degraded bool
+
+	// idempotency remembers POST /sales keys; nil disables them.
+	idempotency    IdempotencyStore
+	idempotencyTTL time.Duration
+
+	// broadcaster streams every change as soon as it is stored; nil
+	// streams nothing.
+	broadcaster *Broadcaster
+}
+
+// Option customizes a Service built by NewService.
+type Option func(*Service)
+
+// WithStateMachine replaces the default sale lifecycle.
+func WithStateMachine(m *StateMachine) Option {
+	return func(s *Service) {
+		s.machine = m
+	}
+}
+
+// WithUserClient replaces the users-api client built from urlUser.
+func WithUserClient(c UserClient) Option {
+	return func(s *Service) {
+		s.users = c
+	}
+}
+
+// WithDegradedMode makes CreateSale accept sales as pending_verification
+// while users-api is unreachable, instead of failing them. Run a Reconciler
+// to verify them once it is back.
+func WithDegradedMode() Option {
+	return func(s *Service) {
+		s.degraded = true
+	}
+}
+
+// WithBroadcaster makes the Service hand every sale it creates or changes
+// to b, right after storing it.
+func WithBroadcaster(b *Broadcaster) Option {
+	return func(s *Service) {
+		s.broadcaster = b
+	}
 }
 
-// NewService creates a new Service.
-func NewService(storage Storage, logger *zap.Logger, urlUser string) *Service {
+// WithApprovalPolicy replaces the rules engine that picks the initial status.
+func WithApprovalPolicy(p ApprovalPolicy) Option {
+	return func(s *Service) {
+		s.policy = p
+	}
+}
+
+// NewService creates a new Service. Buyers are looked up in the users-api
+// at urlUser with the default UserClientConfig, unless WithUserClient says
+// otherwise.
+func NewService(storage Storage, logger *zap.Logger, urlUser string, opts ...Option) *Service {
 	if logger == nil {
 		logger, _ = zap.NewProduction()
 		defer logger.Sync() // flushes buffer, if any
 	}
-	restyClient := resty.New()
 
-	return &Service{
-		storage:    storage,
-		logger:     logger,
-		userClient: restyClient,
-		urlUser:    urlUser,
+	s := &Service{
+		storage: storage,
+		logger:  logger,
+		machine: DefaultStateMachine(DefaultExpireAfter, DefaultRefundWindow),
+		policy:  NewRulesEngine(DefaultRulesConfig()),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.users == nil {
+		cfg := DefaultUserClientConfig()
+		cfg.BaseURL = urlUser
+		s.users = NewHTTPUserClient(cfg, logger)
+	}
+	return s
 }
 
-// CreateSale creates a new sale in the system.
-func (s *Service) CreateSale(sale *Sale) error {
-	if sale.Amount <= 0.0 {
+// StateMachine returns the sale lifecycle enforced by the service.
+func (s *Service) StateMachine() *StateMachine {
+	return s.machine
+}
+
+// CreateSale creates a new sale in the system. Its initial status is chosen
+// by the approval policy and the decision is kept on the sale.
+//
+// A buyer who is suspended or blocked gets a *UserNotActiveError.
+//
+// In degraded mode, a sale whose buyer cannot be looked up because users-api
+// is unreachable is stored as pending_verification for the Reconciler to
+// finish later.
+func (s *Service) CreateSale(ctx context.Context, sale *Sale) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+	if !sale.Amount.IsPositive() {
 		return ErrInvalidInput
 	}
-	sale.ID = uuid.NewString()
-	statuses := []string{"pending", "rejected"}
-	sale.Status = statuses[rand.Intn(len(statuses))]
+	if err := sale.Amount.Validate(); err != nil {
+		return err
+	}
+
 	now := time.Now()
-	sale.CreatedAt = now
-	sale.UpdatedAt = now
-	sale.Version = 1
-	userID := sale.UserID
-	res, err := s.userClient.R().Get(s.urlUser + "/users/" + userID)
+	buyer, err := s.users.GetUser(ctx, sale.UserID)
+	if err != nil {
+		if !s.degraded || !errors.Is(err, ErrTryingToGetUser) {
+			return err
+		}
+		s.logger.Warn("accepting sale without verifying the buyer", zap.Error(err), zap.String("user_id", sale.UserID))
+		return s.insertSale(ctx, sale, Decision{
+			Status: StatusPendingVerification,
+			Rule:   RuleUserVerification,
+			Reason: "users-api unavailable",
+		}, now)
+	}
+	if err := buyer.checkActive(now); err != nil {
+		return err
+	}
+
+	decision, err := s.decide(ctx, sale, buyer, now)
 	if err != nil {
-		return ErrTryingToGetUser
+		return err
 	}
+	return s.insertSale(ctx, sale, decision, now)
+}
 
-	if res.IsError() {
-		return ErrUserNotFound
+// decide asks the approval policy for the initial status of sale.
+func (s *Service) decide(ctx context.Context, sale *Sale, buyer *User, now time.Time) (Decision, error) {
+	history, err := s.storage.SummarizeSales(ctx, SaleFilter{UserID: sale.UserID})
+	if err != nil {
+		return Decision{}, contextError(err)
 	}
 
-	if err := s.storage.SetSale(sale); err != nil {
+	decision, err := s.policy.Decide(ctx, ApprovalInput{Sale: sale, Buyer: buyer, History: history, Now: now})
+	if err != nil {
+		return Decision{}, contextError(err)
+	}
+	if !s.machine.IsInitial(decision.Status) {
+		return Decision{}, fmt.Errorf("approval rule %q chose non-initial status %q", decision.Rule, decision.Status)
+	}
+	return decision, nil
+}
+
+// insertSale stores a new sale in the status chosen by decision.
+func (s *Service) insertSale(ctx context.Context, sale *Sale, decision Decision, now time.Time) error {
+	sale.ID = uuid.NewString()
+	sale.Status = decision.Status
+	sale.StatusReason = decision.Reason
+	sale.Approval = &decision
+	sale.CreatedAt = now
+	sale.UpdatedAt = now
+	sale.Version = 1
+
+	entry := newHistoryEntry(ctx, sale, "")
+	if err := s.storage.SetSale(ctx, sale, entry); err != nil {
 		s.logger.Error("failed to set sale", zap.Error(err), zap.Any("sale", sale))
-		return err
+		return contextError(err)
 	}
 
+	s.broadcast(sale, entry)
 	return nil
 }
 
+// broadcast streams the stored change entry of sale, if streaming is on.
+func (s *Service) broadcast(sale *Sale, entry HistoryEntry) {
+	if s.broadcaster != nil {
+		s.broadcaster.Publish(context.Background(), newEvent(sale, entry))
+	}
+}
+
 // GetUser retrieves a user by its ID.
-func (s *Service) GetSale(id string) (*Sale, error) {
-	sale, err := s.storage.ReadSale(id)
+func (s *Service) GetSale(ctx context.Context, id string) (*Sale, error) {
+	sale, err := s.storage.ReadSale(ctx, id)
 	if err != nil {
-		return nil, err
+		return nil, contextError(err)
 	}
 	return sale, nil
 }
 
-func (s *Service) GetSaleByUserAndStatus(userID string, status string) (informe, error) {
+// ListSales returns one page of the sales matching filter, with the
+// metadata of all of them. NextCursor is set when more pages follow.
+// An unusable filter is reported as FieldErrors.
+func (s *Service) ListSales(ctx context.Context, filter SaleFilter, req PageRequest) (informe, error) {
 	var resp informe
-	var meta metadata
 	resp.Results = []Sale{}
 
-	// Validar estado si se envía
-	if status != "" && status != "approved" && status != "rejected" && status != "pending" {
-		return resp, ErrInvalidInput
+	if err := filter.validate(s.machine); err != nil {
+		return resp, err
+	}
+	page, err := salePage(req)
+	if err != nil {
+		return resp, err
 	}
 
-	salesMap, _ := s.storage.ReadAllSales()
-
-	var filteredSales []Sale
-	for _, sale := range salesMap {
-		if sale.UserID != userID {
-			continue
-		}
-		if status == "" || sale.Status == status {
-			filteredSales = append(filteredSales, *sale)
-		}
+	// One more than asked tells whether there is a next page.
+	limit := page.Limit
+	page.Limit++
+	sales, err := s.storage.ReadSalesPage(ctx, filter, page)
+	if err != nil {
+		return resp, contextError(err)
+	}
+	if len(sales) > limit {
+		sales = sales[:limit]
+		resp.NextCursor = cursorAt(sales[limit-1], page).Encode()
+	}
+	meta, err := s.storage.SummarizeSales(ctx, filter)
+	if err != nil {
+		return resp, contextError(err)
 	}
 
-	meta.Quantity = len(filteredSales)
-	for _, sale := range filteredSales {
-		switch sale.Status {
-		case "approved":
-			meta.Approved++
-		case "rejected":
-			meta.Rejected++
-		case "pending":
-			meta.Pending++
-		}
-		meta.TotalAmount += sale.Amount
+	for _, sale := range sales {
+		resp.Results = append(resp.Results, *sale)
 	}
-	resp.Metadata = meta
-	if len(filteredSales) == 0 {
-		resp.Results = []Sale{}
-	} else {
-		resp.Results = filteredSales
+	if meta.TotalAmount == nil {
+		meta.TotalAmount = []Money{}
 	}
+	resp.Metadata = meta
 	return resp, nil
 }
 
-//UpdateSale updates a sale in the system.
+// maxUpdateAttempts bounds how many times UpdateSale re-reads a sale that
+// changed under it when the caller did not ask for a specific version.
+const maxUpdateAttempts = 3
 
-func (s *Service) UpdateSale(id string, updates *UpdateFieldsSale) (*Sale, error) {
-	existing, err := s.storage.ReadSale(id)
-
-	if err != nil {
-		return nil, err
-	}
-
-	// Chequear si hay cambios
-	updated := false
-
-	if existing.Status == "pending" {
-		if updates.Status == "rejected" || updates.Status == "approved" {
-			existing.Status = updates.Status
-			updated = true
-		} else {
-			return nil, ErrInvalidInput
+// UpdateSale updates a sale in the system.
+// If expectedVersion is not zero, the update only applies to that version of
+// the sale and ErrVersionConflict is returned otherwise. With zero, a
+// concurrent write makes UpdateSale re-read the sale and check the
+// transition again, so no update is silently lost either way.
+func (s *Service) UpdateSale(ctx context.Context, id string, updates *UpdateFieldsSale, expectedVersion int) (*Sale, error) {
+	for attempt := 1; ; attempt++ {
+		existing, err := s.storage.ReadSale(ctx, id)
+		if err != nil {
+			return nil, contextError(err)
 		}
-	} else {
-		if updates.Status == "rejected" || updates.Status == "approved" {
-			return nil, ErrTransactionInvalid
-		} else {
-			return nil, ErrInvalidInput
+		if expectedVersion != 0 && existing.Version != expectedVersion {
+			return nil, ErrVersionConflict
 		}
 
-	}
+		if err := s.machine.Transition(existing, updates.Status); err != nil {
+			return nil, err
+		}
 
-	// Si no se modificó nada, lanzar error 400
-	if !updated {
-		return nil, ErrNoFieldsToUpdate
-	}
+		readVersion, fromStatus := existing.Version, existing.Status
+		existing.Status = updates.Status
+		existing.StatusReason = updates.Reason
+		existing.UpdatedAt = time.Now()
+		existing.Version++
 
-	existing.UpdatedAt = time.Now()
-	existing.Version++
+		entry := newHistoryEntry(ctx, existing, fromStatus)
+		err = s.storage.CompareAndSetSale(ctx, existing, readVersion, entry)
+		if errors.Is(err, ErrVersionConflict) && expectedVersion == 0 && attempt < maxUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, contextError(err)
+		}
 
-	if err := s.storage.SetSale(existing); err != nil {
-		return nil, err
+		s.broadcast(existing, entry)
+		return existing, nil
 	}
-
-	return existing, nil
 }
@@ -1,16 +1,38 @@
 package sale
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
+// storageBackends returns a constructor for every Storage implementation, so
+// the service tests run against each backend.
+func storageBackends(t *testing.T) map[string]func() Storage {
+	return map[string]func() Storage{
+		"local": func() Storage { return NewLocalStorage() },
+		"sqlite": func() Storage {
+			s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "sales.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+}
+
 func TestService_Create_Simple(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) { testServiceCreateSimple(t, newStorage) })
+	}
+}
+
+func testServiceCreateSimple(t *testing.T, newStorage func() Storage) {
 	// Mock externo para la API de usuarios
 	mockHandler := http.NewServeMux()
 	mockHandler.HandleFunc("/users/1234", func(w http.ResponseWriter, r *http.Request) {
@@ -21,14 +43,14 @@ func TestService_Create_Simple(t *testing.T) {
 	defer mockServer.Close()
 
 	// Creamos el servicio usando el mock como baseURL
-	s := NewService(NewLocalStorage(), nil, mockServer.URL)
+	s := NewService(newStorage(), nil, mockServer.URL)
 
 	input := &Sale{
 		UserID: "1234", // simulamos que el UserID fue validado
-		Amount: 100.0,
+		Amount: MustParseMoney("100", "ARS"),
 	}
 
-	err := s.CreateSale(input)
+	err := s.CreateSale(context.Background(), input)
 
 	require.Nil(t, err)
 	require.Equal(t, "1234", input.UserID)
@@ -43,14 +65,23 @@ func TestService_Create_Simple(t *testing.T) {
 		mockSetSale: func(sale *Sale) error {
 			return errors.New("fake error trying to set sale")
 		},
+		mockSummarizeSales: func(filter SaleFilter) (metadata, error) {
+			return metadata{}, nil
+		},
 	}, nil, mockServer.URL)
 
-	err = s.CreateSale(input)
+	err = s.CreateSale(context.Background(), input)
 	require.NotNil(t, err)
 	require.EqualError(t, err, "fake error trying to set sale")
 }
 
 func TestService_Create(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) { testServiceCreate(t, newStorage) })
+	}
+}
+
+func testServiceCreate(t *testing.T, newStorage func() Storage) {
 	type fields struct {
 		storage Storage
 		apiURL  string
@@ -90,11 +121,14 @@ func TestService_Create(t *testing.T) {
 					mockSetSale: func(sale *Sale) error {
 						return errors.New("fake error trying to set sale")
 					},
+					mockSummarizeSales: func(filter SaleFilter) (metadata, error) {
+						return metadata{}, nil
+					},
 				},
 				apiURL: mockServer.URL,
 			},
 			args: args{
-				sale: &Sale{UserID: "1234", Amount: 100.0},
+				sale: &Sale{UserID: "1234", Amount: MustParseMoney("100", "ARS")},
 			},
 			wantErr: func(t *testing.T, err error) {
 				require.NotNil(t, err)
@@ -105,13 +139,13 @@ func TestService_Create(t *testing.T) {
 		{
 			name: "success",
 			fields: fields{
-				storage: NewLocalStorage(),
+				storage: newStorage(),
 				apiURL:  mockServer.URL,
 			},
 			args: args{
 				sale: &Sale{
 					UserID: "1234",
-					Amount: 100.0,
+					Amount: MustParseMoney("100", "ARS"),
 				},
 			},
 			wantErr: func(t *testing.T, err error) {
@@ -122,18 +156,21 @@ func TestService_Create(t *testing.T) {
 				require.NotEmpty(t, input.CreatedAt)
 				require.NotEmpty(t, input.UpdatedAt)
 				require.Equal(t, 1, input.Version)
+				// The mocked user has no created_at, so its age is unknown.
+				require.Equal(t, StatusPending, input.Status)
+				require.Equal(t, &Decision{Status: StatusPending, Rule: "new_account", Reason: "account younger than 24h0m0s"}, input.Approval)
 			},
 		},
 		{
 			name: "invalid amount",
 			fields: fields{
-				storage: NewLocalStorage(),
+				storage: newStorage(),
 				apiURL:  mockServer.URL,
 			},
 			args: args{
 				sale: &Sale{
 					UserID: "1234",
-					Amount: 0.0,
+					Amount: Money{},
 				},
 			},
 			wantErr: func(t *testing.T, err error) {
@@ -145,13 +182,13 @@ func TestService_Create(t *testing.T) {
 		{
 			name: "non-existent user",
 			fields: fields{
-				storage: NewLocalStorage(),
+				storage: newStorage(),
 				apiURL:  mockServer.URL,
 			},
 			args: args{
 				sale: &Sale{
 					UserID: "9999",
-					Amount: 100.0,
+					Amount: MustParseMoney("100", "ARS"),
 				},
 			},
 			wantErr: func(t *testing.T, err error) {
@@ -166,7 +203,7 @@ func TestService_Create(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			s := NewService(tt.fields.storage, nil, tt.fields.apiURL)
 
-			err := s.CreateSale(tt.args.sale)
+			err := s.CreateSale(context.Background(), tt.args.sale)
 
 			if tt.wantErr != nil {
 				tt.wantErr(t, err)
@@ -179,6 +216,12 @@ func TestService_Create(t *testing.T) {
 }
 
 func TestService_UpdateSale(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) { testServiceUpdateSale(t, newStorage) })
+	}
+}
+
+func testServiceUpdateSale(t *testing.T, newStorage func() Storage) {
 	type fields struct {
 		storage Storage
 	}
@@ -199,7 +242,7 @@ func TestService_UpdateSale(t *testing.T) {
 		{
 			name: "sale not found",
 			fields: fields{
-				storage: NewLocalStorage(),
+				storage: newStorage(),
 			},
 			setupData: func(_ Storage) string { return "no existe id" },
 			args: func(id string) args {
@@ -214,11 +257,11 @@ func TestService_UpdateSale(t *testing.T) {
 		{
 			name: "invalid status update",
 			fields: fields{
-				storage: NewLocalStorage(),
+				storage: newStorage(),
 			},
 			setupData: func(storage Storage) string {
-				sale := &Sale{ID: "123", UserID: "1234", Amount: 100, Status: "pending"}
-				_ = storage.SetSale(sale)
+				sale := &Sale{ID: "123", UserID: "1234", Amount: MustParseMoney("100", "ARS"), Status: "pending"}
+				_ = storage.SetSale(context.Background(), sale)
 				return sale.ID
 			},
 			args: func(id string) args {
@@ -233,11 +276,11 @@ func TestService_UpdateSale(t *testing.T) {
 		{
 			name: "invalid transaction - status not pending",
 			fields: fields{
-				storage: NewLocalStorage(),
+				storage: newStorage(),
 			},
 			setupData: func(storage Storage) string {
-				sale := &Sale{ID: "456", UserID: "1234", Amount: 100, Status: "approved"}
-				_ = storage.SetSale(sale)
+				sale := &Sale{ID: "456", UserID: "1234", Amount: MustParseMoney("100", "ARS"), Status: "approved"}
+				_ = storage.SetSale(context.Background(), sale)
 				return sale.ID
 			},
 			args: func(id string) args {
@@ -252,11 +295,11 @@ func TestService_UpdateSale(t *testing.T) {
 		{
 			name: "success",
 			fields: fields{
-				storage: NewLocalStorage(),
+				storage: newStorage(),
 			},
 			setupData: func(storage Storage) string {
-				sale := &Sale{ID: "789", UserID: "1234", Amount: 100, Status: "pending", Version: 1}
-				_ = storage.SetSale(sale)
+				sale := &Sale{ID: "789", UserID: "1234", Amount: MustParseMoney("100", "ARS"), Status: "pending", Version: 1}
+				_ = storage.SetSale(context.Background(), sale)
 				return sale.ID
 			},
 			args: func(id string) args {
@@ -278,7 +321,7 @@ func TestService_UpdateSale(t *testing.T) {
 					mockReadSale: func(id string) (*Sale, error) {
 						return &Sale{ID: id, Status: "pending", Version: 1}, nil
 					},
-					mockSetSale: func(sale *Sale) error {
+					mockCompareAndSetSale: func(sale *Sale, expectedVersion int) error {
 						return errors.New("failed to save")
 					},
 				},
@@ -300,7 +343,7 @@ func TestService_UpdateSale(t *testing.T) {
 			saleID := tt.setupData(tt.fields.storage)
 			service := NewService(tt.fields.storage, nil, "")
 
-			result, err := service.UpdateSale(tt.args(saleID).id, tt.args(saleID).updates)
+			result, err := service.UpdateSale(context.Background(), tt.args(saleID).id, tt.args(saleID).updates, 0)
 
 			if tt.wantErr != nil {
 				tt.wantErr(t, err)
@@ -312,20 +355,129 @@ func TestService_UpdateSale(t *testing.T) {
 	}
 }
 
+func TestService_Create_Timeout(t *testing.T) {
+	mockHandler := http.NewServeMux()
+	mockHandler.HandleFunc("/users/1234", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mockServer := httptest.NewServer(mockHandler)
+	defer mockServer.Close()
+
+	s := NewService(NewLocalStorage(), nil, mockServer.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := s.CreateSale(ctx, &Sale{UserID: "1234", Amount: MustParseMoney("100", "ARS")})
+	require.ErrorIs(t, err, ErrTimeout)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// A request that is already cancelled never reaches users-api nor storage.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = s.CreateSale(ctx, &Sale{UserID: "1234", Amount: MustParseMoney("100", "ARS")})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestService_UpdateSale_Versions(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			require.NoError(t, storage.SetSale(ctx, &Sale{ID: "1", UserID: "u", Amount: MustParseMoney("1", "ARS"), Status: StatusPending, Version: 1}))
+			s := NewService(storage, nil, "")
+
+			// A stale If-Match is refused and nothing changes.
+			_, err := s.UpdateSale(ctx, "1", &UpdateFieldsSale{Status: StatusOnHold}, 7)
+			require.ErrorIs(t, err, ErrVersionConflict)
+
+			sale, err := s.UpdateSale(ctx, "1", &UpdateFieldsSale{Status: StatusOnHold}, 1)
+			require.NoError(t, err)
+			require.Equal(t, 2, sale.Version)
+
+			// Two writers holding version 2: only the first one wins.
+			_, err = s.UpdateSale(ctx, "1", &UpdateFieldsSale{Status: StatusApproved}, 2)
+			require.NoError(t, err)
+			_, err = s.UpdateSale(ctx, "1", &UpdateFieldsSale{Status: StatusRejected}, 2)
+			require.ErrorIs(t, err, ErrVersionConflict)
+
+			// The storage refuses a swap against a stale version on its own.
+			sale.Status = StatusRejected
+			err = storage.CompareAndSetSale(ctx, sale, 2)
+			require.ErrorIs(t, err, ErrVersionConflict)
+
+			got, err := storage.ReadSale(ctx, "1")
+			require.NoError(t, err)
+			require.Equal(t, StatusApproved, got.Status)
+			require.Equal(t, 3, got.Version)
+		})
+	}
+}
+
 type mockStorage struct {
-	mockSetSale      func(sale *Sale) error
-	mockReadSale     func(id string) (*Sale, error)
-	mockReadAllSales func() (map[string]*Sale, error)
+	mockSetSale                  func(sale *Sale) error
+	mockReadSale                 func(id string) (*Sale, error)
+	mockReadAllSales             func() (map[string]*Sale, error)
+	mockReadSalesByUserAndStatus func(userID, status string) ([]*Sale, error)
+	mockReadSalesPage            func(filter SaleFilter, page SalePage) ([]*Sale, error)
+	mockSummarizeSales           func(filter SaleFilter) (metadata, error)
+	mockCompareAndSetSale        func(sale *Sale, expectedVersion int) error
+	mockReadSaleHistory          func(id string) ([]HistoryEntry, error)
+	mockReadSalesByStatus        func(status string, limit int) ([]*Sale, error)
+	mockReadOutbox               func(filter OutboxFilter) ([]*OutboxEntry, error)
+	mockReadOutboxEntry          func(seq int64) (*OutboxEntry, error)
+	mockUpdateOutboxEntry        func(entry *OutboxEntry) error
 }
 
-func (m *mockStorage) SetSale(sale *Sale) error {
+func (m *mockStorage) ReadSalesByStatus(_ context.Context, status string, limit int) ([]*Sale, error) {
+	return m.mockReadSalesByStatus(status, limit)
+}
+
+func (m *mockStorage) SetSale(_ context.Context, sale *Sale, _ ...HistoryEntry) error {
 	return m.mockSetSale(sale)
 }
 
-func (m *mockStorage) ReadSale(id string) (*Sale, error) {
+func (m *mockStorage) CompareAndSetSale(_ context.Context, sale *Sale, expectedVersion int, _ ...HistoryEntry) error {
+	return m.mockCompareAndSetSale(sale, expectedVersion)
+}
+
+func (m *mockStorage) ReadSaleHistory(_ context.Context, id string) ([]HistoryEntry, error) {
+	return m.mockReadSaleHistory(id)
+}
+
+func (m *mockStorage) ReadSale(_ context.Context, id string) (*Sale, error) {
 	return m.mockReadSale(id)
 }
 
-func (m *mockStorage) ReadAllSales() (map[string]*Sale, error) {
+func (m *mockStorage) ReadAllSales(_ context.Context) (map[string]*Sale, error) {
 	return m.mockReadAllSales()
 }
+
+func (m *mockStorage) ReadSalesByUserAndStatus(_ context.Context, userID, status string) ([]*Sale, error) {
+	return m.mockReadSalesByUserAndStatus(userID, status)
+}
+
+func (m *mockStorage) ReadSalesPage(_ context.Context, filter SaleFilter, page SalePage) ([]*Sale, error) {
+	return m.mockReadSalesPage(filter, page)
+}
+
+func (m *mockStorage) SummarizeSales(_ context.Context, filter SaleFilter) (metadata, error) {
+	return m.mockSummarizeSales(filter)
+}
+
+func (m *mockStorage) ReadOutbox(_ context.Context, filter OutboxFilter) ([]*OutboxEntry, error) {
+	return m.mockReadOutbox(filter)
+}
+
+func (m *mockStorage) ReadOutboxEntry(_ context.Context, seq int64) (*OutboxEntry, error) {
+	return m.mockReadOutboxEntry(seq)
+}
+
+func (m *mockStorage) UpdateOutboxEntry(_ context.Context, entry *OutboxEntry) error {
+	return m.mockUpdateOutboxEntry(entry)
+}
@@ -0,0 +1,964 @@
+package sale
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// saleMigrations holds the schema changes for the SQLite backend, in order.
+// The position of each statement (1-based) is its schema version, tracked
+// through PRAGMA user_version. Never edit an applied migration: append a new one.
+var saleMigrations = []string{
+	`CREATE TABLE sales (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT    NOT NULL,
+		amount     REAL    NOT NULL,
+		status     TEXT    NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		version    INTEGER NOT NULL
+	);
+	CREATE INDEX idx_sales_user_id ON sales (user_id);
+	CREATE INDEX idx_sales_status ON sales (status);
+	CREATE INDEX idx_sales_created_at ON sales (created_at);`,
+
+	`ALTER TABLE sales ADD COLUMN approval_status TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sales ADD COLUMN approval_rule TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sales ADD COLUMN approval_reason TEXT NOT NULL DEFAULT '';`,
+
+	// Amounts become exact minor units plus currency. Sales stored before
+	// currencies existed were all in pesos.
+	`ALTER TABLE sales ADD COLUMN amount_units INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE sales ADD COLUMN currency TEXT NOT NULL DEFAULT 'ARS';
+	UPDATE sales SET amount_units = CAST(ROUND(amount * 100) AS INTEGER);
+	ALTER TABLE sales DROP COLUMN amount;`,
+
+	// response holds the created sale as JSON; NULL while the request runs.
+	`CREATE TABLE idempotency_keys (
+		key          TEXT PRIMARY KEY,
+		request_hash TEXT    NOT NULL,
+		response     TEXT,
+		expires_at   INTEGER NOT NULL
+	);
+	CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);`,
+
+	`CREATE TABLE sale_history (
+		sale_id     TEXT    NOT NULL,
+		version     INTEGER NOT NULL,
+		from_status TEXT    NOT NULL,
+		to_status   TEXT    NOT NULL,
+		actor       TEXT    NOT NULL,
+		at          INTEGER NOT NULL,
+		PRIMARY KEY (sale_id, version)
+	);`,
+
+	`ALTER TABLE sales ADD COLUMN status_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sale_history ADD COLUMN reason TEXT NOT NULL DEFAULT '';`,
+
+	`CREATE TABLE outbox (
+		seq             INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id        TEXT    NOT NULL UNIQUE,
+		event           TEXT    NOT NULL,
+		status          TEXT    NOT NULL,
+		attempts        INTEGER NOT NULL,
+		last_error      TEXT    NOT NULL,
+		created_at      INTEGER NOT NULL,
+		next_attempt_at INTEGER NOT NULL,
+		delivered_at    INTEGER NOT NULL
+	);
+	CREATE INDEX idx_outbox_due ON outbox (status, next_attempt_at);`,
+
+	// event_types holds a JSON array. Deliveries are ordered by rowid, which
+	// grows with every insert.
+	`CREATE TABLE webhooks (
+		id          TEXT PRIMARY KEY,
+		url         TEXT    NOT NULL,
+		secret      TEXT    NOT NULL,
+		event_types TEXT    NOT NULL,
+		user_id     TEXT    NOT NULL,
+		created_at  INTEGER NOT NULL,
+		updated_at  INTEGER NOT NULL
+	);
+	CREATE TABLE webhook_deliveries (
+		id               TEXT PRIMARY KEY,
+		webhook_id       TEXT    NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+		event_id         TEXT    NOT NULL,
+		event            TEXT    NOT NULL,
+		status           TEXT    NOT NULL,
+		attempts         INTEGER NOT NULL,
+		last_status_code INTEGER NOT NULL,
+		last_error       TEXT    NOT NULL,
+		created_at       INTEGER NOT NULL,
+		next_attempt_at  INTEGER NOT NULL,
+		delivered_at     INTEGER NOT NULL,
+		UNIQUE (webhook_id, event_id)
+	);
+	CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);`,
+
+	// One index per sort of a sale listing, so a page is a range scan.
+	`CREATE INDEX idx_sales_user_created_at ON sales (user_id, created_at, id);
+	CREATE INDEX idx_sales_user_updated_at ON sales (user_id, updated_at, id);
+	CREATE INDEX idx_sales_user_amount ON sales (user_id, currency, amount_units, id);`,
+}
+
+// SQLiteStorage provides a durable implementation of Storage backed by an
+// embedded SQLite database file.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+// NewSQLiteStorage opens (or creates) the SQLite database at path and brings
+// its schema up to date.
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database: %w", err)
+	}
+	// SQLite serializes writers anyway; a single connection avoids SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db, saleMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStorage{db: db}, nil
+}
+
+// Close releases the underlying database handle.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies every migration newer than the database's user_version.
+func migrate(db *sql.DB, migrations []string) error {
+	var current int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&current); err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error applying migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetSale inserts or replaces a sale, together with its history entries.
+func (s *SQLiteStorage) SetSale(ctx context.Context, sale *Sale, history ...HistoryEntry) error {
+	if sale.ID == "" {
+		return ErrEmptyID
+	}
+
+	var approval Decision
+	if sale.Approval != nil {
+		approval = *sale.Approval
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sales (id, user_id, amount_units, currency, status, created_at, updated_at, version,
+			approval_status, approval_rule, approval_reason, status_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			user_id = excluded.user_id,
+			amount_units = excluded.amount_units,
+			currency = excluded.currency,
+			status = excluded.status,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			version = excluded.version,
+			approval_status = excluded.approval_status,
+			approval_rule = excluded.approval_rule,
+			approval_reason = excluded.approval_reason,
+			status_reason = excluded.status_reason`,
+		sale.ID, sale.UserID, sale.Amount.Units, sale.Amount.Currency, sale.Status,
+		toUnixNano(sale.CreatedAt), toUnixNano(sale.UpdatedAt), sale.Version,
+		approval.Status, approval.Rule, approval.Reason, sale.StatusReason,
+	)
+	if err != nil {
+		return err
+	}
+	if err := insertHistory(ctx, tx, sale, history); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CompareAndSetSale updates the row only where the version still matches.
+func (s *SQLiteStorage) CompareAndSetSale(ctx context.Context, sale *Sale, expectedVersion int, history ...HistoryEntry) error {
+	var approval Decision
+	if sale.Approval != nil {
+		approval = *sale.Approval
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE sales SET
+			user_id = ?, amount_units = ?, currency = ?, status = ?,
+			created_at = ?, updated_at = ?, version = ?,
+			approval_status = ?, approval_rule = ?, approval_reason = ?,
+			status_reason = ?
+		WHERE id = ? AND version = ?`,
+		sale.UserID, sale.Amount.Units, sale.Amount.Currency, sale.Status,
+		toUnixNano(sale.CreatedAt), toUnixNano(sale.UpdatedAt), sale.Version,
+		approval.Status, approval.Rule, approval.Reason,
+		sale.StatusReason,
+		sale.ID, expectedVersion,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		if err := insertHistory(ctx, tx, sale, history); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	tx.Rollback()
+
+	// Nothing matched: tell a missing sale apart from a stale version.
+	if _, err := s.ReadSale(ctx, sale.ID); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// insertHistory appends the history entries of sale within tx, and queues
+// the event of each one in the outbox.
+func insertHistory(ctx context.Context, tx *sql.Tx, sale *Sale, history []HistoryEntry) error {
+	for _, e := range history {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO sale_history (sale_id, version, from_status, to_status, reason, actor, at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			e.SaleID, e.Version, e.FromStatus, e.ToStatus, e.Reason, e.Actor, toUnixNano(e.At),
+		)
+		if err != nil {
+			return err
+		}
+
+		event := newEvent(sale, e)
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		entry := newOutboxEntry(event)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO outbox (event_id, event, status, attempts, last_error, created_at, next_attempt_at, delivered_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			event.ID, string(payload), entry.Status, entry.Attempts, entry.LastError,
+			toUnixNano(entry.CreatedAt), toUnixNano(entry.NextAttemptAt), toUnixNano(entry.DeliveredAt),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSaleHistory returns the entries of a sale ordered by version.
+func (s *SQLiteStorage) ReadSaleHistory(ctx context.Context, id string) ([]HistoryEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT sale_id, version, from_status, to_status, reason, actor, at
+		FROM sale_history WHERE sale_id = ?
+		ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []HistoryEntry{}
+	for rows.Next() {
+		var (
+			e  HistoryEntry
+			at int64
+		)
+		if err := rows.Scan(&e.SaleID, &e.Version, &e.FromStatus, &e.ToStatus, &e.Reason, &e.Actor, &at); err != nil {
+			return nil, err
+		}
+		e.At = fromUnixNano(at)
+		history = append(history, e)
+	}
+	return history, rows.Err()
+}
+
+// ReadSale retrieves a sale by ID.
+// Returns ErrNotFoundSale if the sale is not found.
+func (s *SQLiteStorage) ReadSale(ctx context.Context, id string) (*Sale, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+saleColumns+`
+		FROM sales WHERE id = ?`, id)
+
+	sale, err := scanSale(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFoundSale
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sale, nil
+}
+
+// ReadAllSales returns every stored sale keyed by ID.
+// Returns ErrNotFoundSale if there are no sales, like LocalStorage.
+func (s *SQLiteStorage) ReadAllSales(ctx context.Context) (map[string]*Sale, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+saleColumns+`
+		FROM sales`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sales := map[string]*Sale{}
+	for rows.Next() {
+		sale, err := scanSale(rows)
+		if err != nil {
+			return nil, err
+		}
+		sales[sale.ID] = sale
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(sales) == 0 {
+		return nil, ErrNotFoundSale
+	}
+	return sales, nil
+}
+
+// ReadSalesByUserAndStatus uses the user_id and status indexes.
+func (s *SQLiteStorage) ReadSalesByUserAndStatus(ctx context.Context, userID, status string) ([]*Sale, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+saleColumns+`
+		FROM sales
+		WHERE user_id = ? AND (? = '' OR status = ?)
+		ORDER BY created_at, id`, userID, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sales := []*Sale{}
+	for rows.Next() {
+		sale, err := scanSale(rows)
+		if err != nil {
+			return nil, err
+		}
+		sales = append(sales, sale)
+	}
+	return sales, rows.Err()
+}
+
+// filterWhere translates filter into a WHERE condition and its arguments.
+func filterWhere(filter SaleFilter) (string, []any) {
+	conds := []string{"1 = 1"}
+	var args []any
+	add := func(cond string, arg ...any) {
+		conds = append(conds, cond)
+		args = append(args, arg...)
+	}
+
+	if filter.UserID != "" {
+		add("user_id = ?", filter.UserID)
+	}
+	if len(filter.Statuses) > 0 {
+		in := make([]any, len(filter.Statuses))
+		for i, st := range filter.Statuses {
+			in[i] = st
+		}
+		add("status IN ("+strings.Repeat("?, ", len(in)-1)+"?)", in...)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		add("created_at >= ?", toUnixNano(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		add("created_at < ?", toUnixNano(filter.CreatedTo))
+	}
+	if !filter.UpdatedFrom.IsZero() {
+		add("updated_at >= ?", toUnixNano(filter.UpdatedFrom))
+	}
+	if !filter.UpdatedTo.IsZero() {
+		add("updated_at < ?", toUnixNano(filter.UpdatedTo))
+	}
+	if filter.Currency != "" {
+		add("currency = ?", filter.Currency)
+	}
+	if filter.MinAmount != nil {
+		add("amount_units >= ?", filter.MinAmount.Units)
+	}
+	if filter.MaxAmount != nil {
+		add("amount_units <= ?", filter.MaxAmount.Units)
+	}
+	if filter.Where != nil {
+		cond, condArgs := filter.Where.sql()
+		add(cond, condArgs...)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// sortColumns are the columns compared, in order, for each sort key; id
+// always comes last.
+var sortColumns = map[string][]string{
+	SortCreatedAt: {"created_at"},
+	SortUpdatedAt: {"updated_at"},
+	SortAmount:    {"currency", "amount_units"},
+}
+
+// ReadSalesPage seeks past the cursor with a row value comparison, using
+// the index of the sort.
+func (s *SQLiteStorage) ReadSalesPage(ctx context.Context, filter SaleFilter, page SalePage) ([]*Sale, error) {
+	cols := append(append([]string{}, sortColumns[page.Sort]...), "id")
+	dir, after := "ASC", ">"
+	if page.Desc {
+		dir, after = "DESC", "<"
+	}
+
+	where, args := filterWhere(filter)
+	query := `SELECT ` + saleColumns + ` FROM sales WHERE ` + where
+	if page.After != nil {
+		query += ` AND (` + strings.Join(cols, ", ") + `) ` + after + ` (` + strings.Repeat("?, ", len(cols)-1) + `?)`
+		if page.Sort == SortAmount {
+			args = append(args, page.After.Currency)
+		}
+		args = append(args, page.After.Value, page.After.ID)
+	}
+	order := make([]string, len(cols))
+	for i, col := range cols {
+		order[i] = col + " " + dir
+	}
+	query += ` ORDER BY ` + strings.Join(order, ", ") + ` LIMIT ?`
+	args = append(args, page.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sales := []*Sale{}
+	for rows.Next() {
+		sale, err := scanSale(rows)
+		if err != nil {
+			return nil, err
+		}
+		sales = append(sales, sale)
+	}
+	return sales, rows.Err()
+}
+
+// ReadSalesByStatus uses the status index.
+func (s *SQLiteStorage) ReadSalesByStatus(ctx context.Context, status string, limit int) ([]*Sale, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+saleColumns+`
+		FROM sales
+		WHERE status = ?
+		ORDER BY created_at, id
+		LIMIT ?`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sales := []*Sale{}
+	for rows.Next() {
+		sale, err := scanSale(rows)
+		if err != nil {
+			return nil, err
+		}
+		sales = append(sales, sale)
+	}
+	return sales, rows.Err()
+}
+
+// SummarizeSales aggregates in SQL instead of loading the sales.
+func (s *SQLiteStorage) SummarizeSales(ctx context.Context, filter SaleFilter) (meta metadata, err error) {
+	defer func() { err = overflowError(err) }()
+
+	where, args := filterWhere(filter)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT status, currency, COUNT(*), SUM(amount_units)
+		FROM sales
+		WHERE `+where+`
+		GROUP BY status, currency`, args...)
+	if err != nil {
+		return meta, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			st       string
+			quantity int
+			total    Money
+		)
+		if err := rows.Scan(&st, &total.Currency, &quantity, &total.Units); err != nil {
+			return meta, err
+		}
+		if err := meta.add(st, quantity, total); err != nil {
+			return meta, err
+		}
+	}
+	return meta, rows.Err()
+}
+
+// overflowError turns the error SUM raises when a total does not fit in
+// int64 into one wrapping ErrAmountOverflow.
+func overflowError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && strings.Contains(sqliteErr.Error(), "integer overflow") {
+		return fmt.Errorf("%w: %s", ErrAmountOverflow, sqliteErr.Error())
+	}
+	return err
+}
+
+// ReadOutbox uses the (status, next_attempt_at) index when filtering by
+// status.
+func (s *SQLiteStorage) ReadOutbox(ctx context.Context, filter OutboxFilter) ([]*OutboxEntry, error) {
+	query := `SELECT ` + outboxColumns + ` FROM outbox WHERE 1 = 1`
+	var args []any
+	if filter.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, filter.Status)
+	}
+	if !filter.DueBy.IsZero() {
+		query += ` AND next_attempt_at <= ?`
+		args = append(args, toUnixNano(filter.DueBy))
+	}
+	query += ` ORDER BY seq`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*OutboxEntry{}
+	for rows.Next() {
+		entry, err := scanOutboxEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// ReadOutboxEntry implements OutboxStorage.
+func (s *SQLiteStorage) ReadOutboxEntry(ctx context.Context, seq int64) (*OutboxEntry, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+outboxColumns+` FROM outbox WHERE seq = ?`, seq)
+	entry, err := scanOutboxEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOutboxEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// UpdateOutboxEntry implements OutboxStorage.
+func (s *SQLiteStorage) UpdateOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, delivered_at = ?
+		WHERE seq = ?`,
+		entry.Status, entry.Attempts, entry.LastError,
+		toUnixNano(entry.NextAttemptAt), toUnixNano(entry.DeliveredAt), entry.Seq,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOutboxEntryNotFound
+	}
+	return nil
+}
+
+// outboxColumns lists the columns read by scanOutboxEntry, in order.
+const outboxColumns = `seq, event, status, attempts, last_error, created_at, next_attempt_at, delivered_at`
+
+func scanOutboxEntry(r rowScanner) (*OutboxEntry, error) {
+	var (
+		entry                               OutboxEntry
+		event                               string
+		createdAt, nextAttempt, deliveredAt int64
+	)
+	err := r.Scan(&entry.Seq, &event, &entry.Status, &entry.Attempts, &entry.LastError,
+		&createdAt, &nextAttempt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(event), &entry.Event); err != nil {
+		return nil, fmt.Errorf("error decoding outbox entry %d: %w", entry.Seq, err)
+	}
+	entry.CreatedAt = fromUnixNano(createdAt)
+	entry.NextAttemptAt = fromUnixNano(nextAttempt)
+	entry.DeliveredAt = fromUnixNano(deliveredAt)
+	return &entry, nil
+}
+
+// saleColumns lists the columns read by scanSale, in order.
+const saleColumns = `id, user_id, amount_units, currency, status, created_at, updated_at, version,
+	approval_status, approval_rule, approval_reason, status_reason`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSale(r rowScanner) (*Sale, error) {
+	var (
+		sale                 Sale
+		createdAt, updatedAt int64
+		approval             Decision
+	)
+	err := r.Scan(&sale.ID, &sale.UserID, &sale.Amount.Units, &sale.Amount.Currency, &sale.Status, &createdAt, &updatedAt, &sale.Version,
+		&approval.Status, &approval.Rule, &approval.Reason, &sale.StatusReason)
+	if err != nil {
+		return nil, err
+	}
+	sale.CreatedAt = fromUnixNano(createdAt)
+	sale.UpdatedAt = fromUnixNano(updatedAt)
+	if approval.Rule != "" {
+		sale.Approval = &approval
+	}
+	return &sale, nil
+}
+
+// toUnixNano stores the zero time as 0 so it survives a round trip.
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// ReserveKey implements IdempotencyStore. Expired keys are dropped on the way.
+func (s *SQLiteStorage) ReserveKey(ctx context.Context, key, requestHash string, now, expiresAt time.Time) (*IdempotencyRecord, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= ?`, now.UnixNano()); err != nil {
+		return nil, false, err
+	}
+
+	var (
+		rec       = IdempotencyRecord{Key: key}
+		response  sql.NullString
+		expiresNs int64
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT request_hash, response, expires_at FROM idempotency_keys WHERE key = ?`, key,
+	).Scan(&rec.RequestHash, &response, &expiresNs)
+	switch {
+	case err == nil:
+		rec.ExpiresAt = time.Unix(0, expiresNs)
+		if response.Valid {
+			var sale Sale
+			if err := json.Unmarshal([]byte(response.String), &sale); err != nil {
+				return nil, false, fmt.Errorf("error decoding stored response: %w", err)
+			}
+			rec.Sale = &sale
+		}
+		return &rec, false, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (key, request_hash, expires_at) VALUES (?, ?, ?)`,
+		key, requestHash, expiresAt.UnixNano(),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	return nil, true, tx.Commit()
+}
+
+// CompleteKey implements IdempotencyStore.
+func (s *SQLiteStorage) CompleteKey(ctx context.Context, key string, sale *Sale, expiresAt time.Time) error {
+	response, err := json.Marshal(sale)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET response = ?, expires_at = ? WHERE key = ?`,
+		string(response), expiresAt.UnixNano(), key,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ReleaseKey implements IdempotencyStore.
+func (s *SQLiteStorage) ReleaseKey(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = ?`, key)
+	return err
+}
+
+// SetWebhook implements WebhookStore.
+func (s *SQLiteStorage) SetWebhook(ctx context.Context, w *Webhook) error {
+	eventTypes, err := json.Marshal(w.EventTypes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO webhooks (id, url, secret, event_types, user_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			url = excluded.url,
+			secret = excluded.secret,
+			event_types = excluded.event_types,
+			user_id = excluded.user_id,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at`,
+		w.ID, w.URL, w.Secret, string(eventTypes), w.UserID,
+		toUnixNano(w.CreatedAt), toUnixNano(w.UpdatedAt),
+	)
+	return err
+}
+
+// ReadWebhook implements WebhookStore.
+func (s *SQLiteStorage) ReadWebhook(ctx context.Context, id string) (*Webhook, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id)
+	w, err := scanWebhook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ReadWebhooks implements WebhookStore.
+func (s *SQLiteStorage) ReadWebhooks(ctx context.Context) ([]*Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook implements WebhookStore. Deliveries go with it through the
+// foreign key.
+func (s *SQLiteStorage) DeleteWebhook(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// AddDeliveries implements WebhookStore.
+func (s *SQLiteStorage) AddDeliveries(ctx context.Context, deliveries ...*WebhookDelivery) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, d := range deliveries {
+		event, err := json.Marshal(d.Event)
+		if err != nil {
+			return err
+		}
+		// A webhook deleted meanwhile fails the foreign key; skip it too.
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (id, webhook_id, event_id, event, status, attempts,
+				last_status_code, last_error, created_at, next_attempt_at, delivered_at)
+			SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+			WHERE EXISTS (SELECT 1 FROM webhooks WHERE id = ?)
+			ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+			d.ID, d.WebhookID, d.Event.ID, string(event), d.Status, d.Attempts,
+			d.LastStatusCode, d.LastError, toUnixNano(d.CreatedAt), toUnixNano(d.NextAttemptAt), toUnixNano(d.DeliveredAt),
+			d.WebhookID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ReadDueDeliveries uses the (status, next_attempt_at) index.
+func (s *SQLiteStorage) ReadDueDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY rowid`
+	args := []any{OutboxPending, toUnixNano(now)}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	return s.queryDeliveries(ctx, query, args...)
+}
+
+// ReadDeliveries implements WebhookStore.
+func (s *SQLiteStorage) ReadDeliveries(ctx context.Context, webhookID string, limit int) ([]*WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY rowid DESC`
+	args := []any{webhookID}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	return s.queryDeliveries(ctx, query, args...)
+}
+
+// UpdateDelivery implements WebhookStore. A delivery whose webhook was
+// deleted meanwhile is gone, and updating it does nothing.
+func (s *SQLiteStorage) UpdateDelivery(ctx context.Context, d *WebhookDelivery) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = ?, attempts = ?, last_status_code = ?, last_error = ?,
+			next_attempt_at = ?, delivered_at = ?
+		WHERE id = ?`,
+		d.Status, d.Attempts, d.LastStatusCode, d.LastError,
+		toUnixNano(d.NextAttemptAt), toUnixNano(d.DeliveredAt), d.ID,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) queryDeliveries(ctx context.Context, query string, args ...any) ([]*WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// webhookColumns lists the columns read by scanWebhook, in order.
+const webhookColumns = `id, url, secret, event_types, user_id, created_at, updated_at`
+
+func scanWebhook(r rowScanner) (*Webhook, error) {
+	var (
+		w                    Webhook
+		eventTypes           string
+		createdAt, updatedAt int64
+	)
+	if err := r.Scan(&w.ID, &w.URL, &w.Secret, &eventTypes, &w.UserID, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(eventTypes), &w.EventTypes); err != nil {
+		return nil, fmt.Errorf("error decoding event types of webhook %s: %w", w.ID, err)
+	}
+	w.CreatedAt = fromUnixNano(createdAt)
+	w.UpdatedAt = fromUnixNano(updatedAt)
+	return &w, nil
+}
+
+// deliveryColumns lists the columns read by scanDelivery, in order.
+const deliveryColumns = `id, webhook_id, event, status, attempts, last_status_code, last_error,
+	created_at, next_attempt_at, delivered_at`
+
+func scanDelivery(r rowScanner) (*WebhookDelivery, error) {
+	var (
+		d                                   WebhookDelivery
+		event                               string
+		createdAt, nextAttempt, deliveredAt int64
+	)
+	err := r.Scan(&d.ID, &d.WebhookID, &event, &d.Status, &d.Attempts, &d.LastStatusCode, &d.LastError,
+		&createdAt, &nextAttempt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(event), &d.Event); err != nil {
+		return nil, fmt.Errorf("error decoding webhook delivery %s: %w", d.ID, err)
+	}
+	d.CreatedAt = fromUnixNano(createdAt)
+	d.NextAttemptAt = fromUnixNano(nextAttempt)
+	d.DeliveredAt = fromUnixNano(deliveredAt)
+	return &d, nil
+}
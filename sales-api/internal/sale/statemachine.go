@@ -0,0 +1,250 @@
+package sale
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sale statuses known by the default state machine.
+const (
+	StatusPending   = "pending"
+	StatusApproved  = "approved"
+	StatusRejected  = "rejected"
+	StatusOnHold    = "on_hold"
+	StatusCancelled = "cancelled"
+	StatusRefunded  = "refunded"
+	StatusExpired   = "expired"
+
+	// StatusPendingVerification holds sales accepted while users-api was
+	// unreachable, until the Reconciler can check the buyer.
+	StatusPendingVerification = "pending_verification"
+)
+
+// State describes one sale status.
+type State struct {
+	Name string `json:"name"`
+
+	// Initial states may be assigned when a sale is created.
+	Initial bool `json:"initial"`
+
+	// Terminal states have no outgoing transitions.
+	Terminal bool `json:"terminal"`
+}
+
+// Guard vetoes a transition for a given sale. Check returns a non-nil error
+// explaining why the sale may not move.
+type Guard struct {
+	Name        string
+	Description string
+	Check       func(sale *Sale, now time.Time) error
+}
+
+// Transition allows a sale to move From one status To another, optionally
+// only when Guard passes.
+type Transition struct {
+	From  string
+	To    string
+	Guard *Guard
+
+	// System transitions are only taken by the Service on its own, e.g. by
+	// the Reconciler, and are refused to callers of UpdateSale.
+	System bool
+}
+
+// StateMachine is a declarative description of the sale lifecycle: the
+// statuses a sale can be in and which moves between them are allowed.
+type StateMachine struct {
+	states      []State
+	byName      map[string]State
+	transitions map[string]map[string]Transition // from -> to -> transition
+	now         func() time.Time
+}
+
+// NewStateMachine validates states and transitions and builds a StateMachine.
+// Every transition must join two declared states and none may leave a
+// terminal state.
+func NewStateMachine(states []State, transitions []Transition) (*StateMachine, error) {
+	m := &StateMachine{
+		states:      states,
+		byName:      map[string]State{},
+		transitions: map[string]map[string]Transition{},
+		now:         time.Now,
+	}
+
+	for _, st := range states {
+		if st.Name == "" {
+			return nil, fmt.Errorf("state machine: empty state name")
+		}
+		if _, dup := m.byName[st.Name]; dup {
+			return nil, fmt.Errorf("state machine: duplicated state %q", st.Name)
+		}
+		m.byName[st.Name] = st
+	}
+
+	for _, t := range transitions {
+		from, ok := m.byName[t.From]
+		if !ok {
+			return nil, fmt.Errorf("state machine: unknown state %q", t.From)
+		}
+		if _, ok := m.byName[t.To]; !ok {
+			return nil, fmt.Errorf("state machine: unknown state %q", t.To)
+		}
+		if from.Terminal {
+			return nil, fmt.Errorf("state machine: terminal state %q cannot move to %q", t.From, t.To)
+		}
+		if m.transitions[t.From] == nil {
+			m.transitions[t.From] = map[string]Transition{}
+		}
+		m.transitions[t.From][t.To] = t
+	}
+
+	return m, nil
+}
+
+// DefaultStateMachine returns the sale lifecycle used by the business:
+//
+//	pending  -> approved | rejected | on_hold | cancelled | expired
+//	on_hold  -> pending | approved | rejected | cancelled | expired
+//	approved -> refunded
+//	pending_verification -> pending | approved | rejected | cancelled
+//
+// A sale may only expire once it has been waiting for longer than expireAfter,
+// and a refund is only accepted within refundWindow of the approval. Only the
+// Reconciler, once it has checked the buyer, moves a sale out of
+// pending_verification to anything but cancelled.
+func DefaultStateMachine(expireAfter, refundWindow time.Duration) *StateMachine {
+	expiry := &Guard{
+		Name:        "waiting_longer_than",
+		Description: fmt.Sprintf("the sale was created more than %s ago", expireAfter),
+		Check: func(sale *Sale, now time.Time) error {
+			if now.Sub(sale.CreatedAt) < expireAfter {
+				return fmt.Errorf("sale is not older than %s", expireAfter)
+			}
+			return nil
+		},
+	}
+	refund := &Guard{
+		Name:        "within_refund_window",
+		Description: fmt.Sprintf("the sale was approved less than %s ago", refundWindow),
+		Check: func(sale *Sale, now time.Time) error {
+			if now.Sub(sale.UpdatedAt) > refundWindow {
+				return fmt.Errorf("refund window of %s is over", refundWindow)
+			}
+			return nil
+		},
+	}
+
+	m, err := NewStateMachine(
+		[]State{
+			{Name: StatusPending, Initial: true},
+			{Name: StatusApproved, Initial: true},
+			{Name: StatusRejected, Initial: true, Terminal: true},
+			{Name: StatusOnHold},
+			{Name: StatusCancelled, Terminal: true},
+			{Name: StatusRefunded, Terminal: true},
+			{Name: StatusExpired, Terminal: true},
+			{Name: StatusPendingVerification, Initial: true},
+		},
+		[]Transition{
+			{From: StatusPending, To: StatusApproved},
+			{From: StatusPending, To: StatusRejected},
+			{From: StatusPending, To: StatusOnHold},
+			{From: StatusPending, To: StatusCancelled},
+			{From: StatusPending, To: StatusExpired, Guard: expiry},
+			{From: StatusOnHold, To: StatusPending},
+			{From: StatusOnHold, To: StatusApproved},
+			{From: StatusOnHold, To: StatusRejected},
+			{From: StatusOnHold, To: StatusCancelled},
+			{From: StatusOnHold, To: StatusExpired, Guard: expiry},
+			{From: StatusApproved, To: StatusRefunded, Guard: refund},
+			{From: StatusPendingVerification, To: StatusPending, System: true},
+			{From: StatusPendingVerification, To: StatusApproved, System: true},
+			{From: StatusPendingVerification, To: StatusRejected, System: true},
+			{From: StatusPendingVerification, To: StatusCancelled},
+		},
+	)
+	if err != nil {
+		// The default definition is static; failing here is a programming error.
+		panic(err)
+	}
+	return m
+}
+
+// IsState reports whether status is declared in the machine.
+func (m *StateMachine) IsState(status string) bool {
+	_, ok := m.byName[status]
+	return ok
+}
+
+// IsInitial reports whether a new sale may start in status.
+func (m *StateMachine) IsInitial(status string) bool {
+	return m.byName[status].Initial
+}
+
+// Transition checks whether a caller may move sale to status to.
+// Returns ErrInvalidInput if to is not a known status and ErrTransactionInvalid
+// if the move is not allowed, is a System one or its guard rejects it.
+func (m *StateMachine) Transition(sale *Sale, to string) error {
+	return m.transition(sale, to, false)
+}
+
+// systemTransition is Transition for the moves the Service makes on its own,
+// which may also take System transitions.
+func (m *StateMachine) systemTransition(sale *Sale, to string) error {
+	return m.transition(sale, to, true)
+}
+
+func (m *StateMachine) transition(sale *Sale, to string, system bool) error {
+	if !m.IsState(to) {
+		return ErrInvalidInput
+	}
+
+	t, ok := m.transitions[sale.Status][to]
+	if !ok || (t.System && !system) {
+		return ErrTransactionInvalid
+	}
+	if t.Guard != nil {
+		if err := t.Guard.Check(sale, m.now()); err != nil {
+			return fmt.Errorf("%w: %s", ErrTransactionInvalid, err.Error())
+		}
+	}
+	return nil
+}
+
+// StateDescription is the public view of a state and where it can go next.
+type StateDescription struct {
+	State
+	Next []TransitionDescription `json:"next"`
+}
+
+// TransitionDescription is the public view of a transition.
+type TransitionDescription struct {
+	To               string `json:"to"`
+	Guard            string `json:"guard,omitempty"`
+	GuardDescription string `json:"guard_description,omitempty"`
+	System           bool   `json:"system,omitempty"`
+}
+
+// Describe lists every state, in declaration order, with its outgoing
+// transitions, so clients can discover the lifecycle.
+func (m *StateMachine) Describe() []StateDescription {
+	out := make([]StateDescription, 0, len(m.states))
+	for _, st := range m.states {
+		d := StateDescription{State: st, Next: []TransitionDescription{}}
+		// Walk states again to keep a stable order for the targets.
+		for _, to := range m.states {
+			t, ok := m.transitions[st.Name][to.Name]
+			if !ok {
+				continue
+			}
+			td := TransitionDescription{To: t.To, System: t.System}
+			if t.Guard != nil {
+				td.Guard = t.Guard.Name
+				td.GuardDescription = t.Guard.Description
+			}
+			d.Next = append(d.Next, td)
+		}
+		out = append(out, d)
+	}
+	return out
+}
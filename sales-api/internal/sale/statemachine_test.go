@@ -0,0 +1,92 @@
+package sale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateMachine_Transition(t *testing.T) {
+	now := time.Now()
+	m := DefaultStateMachine(time.Hour, 24*time.Hour)
+	m.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		sale    Sale
+		to      string
+		wantErr error
+	}{
+		{name: "pending to approved", sale: Sale{Status: StatusPending}, to: StatusApproved},
+		{name: "pending to on hold", sale: Sale{Status: StatusPending}, to: StatusOnHold},
+		{name: "on hold back to pending", sale: Sale{Status: StatusOnHold}, to: StatusPending},
+		{name: "unknown status", sale: Sale{Status: StatusPending}, to: "invalid", wantErr: ErrInvalidInput},
+		{name: "leaving a terminal state", sale: Sale{Status: StatusRejected}, to: StatusApproved, wantErr: ErrTransactionInvalid},
+		{name: "approved cannot be cancelled", sale: Sale{Status: StatusApproved}, to: StatusCancelled, wantErr: ErrTransactionInvalid},
+		{
+			name:    "expire too early",
+			sale:    Sale{Status: StatusPending, CreatedAt: now.Add(-time.Minute)},
+			to:      StatusExpired,
+			wantErr: ErrTransactionInvalid,
+		},
+		{name: "expire", sale: Sale{Status: StatusPending, CreatedAt: now.Add(-2 * time.Hour)}, to: StatusExpired},
+		{name: "only the reconciler verifies", sale: Sale{Status: StatusPendingVerification}, to: StatusApproved, wantErr: ErrTransactionInvalid},
+		{name: "cancel unverified", sale: Sale{Status: StatusPendingVerification}, to: StatusCancelled},
+		{name: "refund", sale: Sale{Status: StatusApproved, UpdatedAt: now.Add(-time.Hour)}, to: StatusRefunded},
+		{
+			name:    "refund out of window",
+			sale:    Sale{Status: StatusApproved, UpdatedAt: now.Add(-48 * time.Hour)},
+			to:      StatusRefunded,
+			wantErr: ErrTransactionInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Transition(&tt.sale, tt.to)
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNewStateMachine_Invalid(t *testing.T) {
+	_, err := NewStateMachine([]State{{Name: "a"}}, []Transition{{From: "a", To: "b"}})
+	require.Error(t, err)
+
+	_, err = NewStateMachine(
+		[]State{{Name: "a", Terminal: true}, {Name: "b"}},
+		[]Transition{{From: "a", To: "b"}},
+	)
+	require.Error(t, err)
+}
+
+func TestStateMachine_Describe(t *testing.T) {
+	d := DefaultStateMachine(time.Hour, time.Hour).Describe()
+
+	require.Len(t, d, 8)
+	require.Equal(t, StatusPending, d[0].Name)
+	require.True(t, d[0].Initial)
+	require.Len(t, d[0].Next, 5)
+	require.Equal(t, StatusExpired, d[0].Next[4].To)
+	require.Equal(t, "waiting_longer_than", d[0].Next[4].Guard)
+	require.Empty(t, d[2].Next) // rejected is terminal
+	require.Equal(t, StatusPendingVerification, d[7].Name)
+	require.Len(t, d[7].Next, 4)
+	require.True(t, d[7].Next[1].System)  // approved
+	require.False(t, d[7].Next[3].System) // cancelled
+}
+
+func TestStateMachine_SystemTransition(t *testing.T) {
+	m := DefaultStateMachine(time.Hour, time.Hour)
+	sale := &Sale{Status: StatusPendingVerification}
+
+	require.ErrorIs(t, m.Transition(sale, StatusApproved), ErrTransactionInvalid)
+	require.NoError(t, m.systemTransition(sale, StatusApproved))
+	require.NoError(t, m.systemTransition(sale, StatusRejected))
+	require.ErrorIs(t, m.systemTransition(sale, StatusRefunded), ErrTransactionInvalid)
+}
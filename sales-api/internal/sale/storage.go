@@ -1,6 +1,13 @@
 package sale
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
 
 // ErrNotFound is returned when a user with the given ID is not found.
 var ErrNotFound = errors.New("user not found")
@@ -9,50 +16,492 @@ var ErrNotFoundSale = errors.New("sale not found")
 // ErrEmptyID is returned when trying to store a user with an empty ID.
 var ErrEmptyID = errors.New("empty user ID")
 
+// ErrVersionConflict is returned when a sale changed since it was read.
+var ErrVersionConflict = errors.New("version conflict")
+
 // Storage is the main interface for our storage layer.
+// Every method gives up with the context's error once ctx is done.
+//
+// The write methods append the given history entries in the same atomic
+// step as the sale itself, so the history never misses or invents a change.
+// Each entry is also queued in the outbox as the Event announcing it.
 type Storage interface {
-	SetSale(sale *Sale) error
-	ReadSale(id string) (*Sale, error)
-	ReadAllSales() (map[string]*Sale, error)
+	SetSale(ctx context.Context, sale *Sale, history ...HistoryEntry) error
+	ReadSale(ctx context.Context, id string) (*Sale, error)
+	ReadAllSales(ctx context.Context) (map[string]*Sale, error)
+
+	// CompareAndSetSale replaces an existing sale only if its stored version
+	// is still expectedVersion. Returns ErrNotFoundSale if the sale does not
+	// exist and ErrVersionConflict if someone else wrote it first.
+	CompareAndSetSale(ctx context.Context, sale *Sale, expectedVersion int, history ...HistoryEntry) error
+
+	// ReadSaleHistory returns the history entries of a sale, oldest first.
+	ReadSaleHistory(ctx context.Context, id string) ([]HistoryEntry, error)
+
+	// ReadSalesByUserAndStatus returns the sales of userID, oldest first.
+	// An empty status matches every status.
+	ReadSalesByUserAndStatus(ctx context.Context, userID, status string) ([]*Sale, error)
+
+	// ReadSalesPage returns the page of the sales matching filter that page
+	// asks for.
+	ReadSalesPage(ctx context.Context, filter SaleFilter, page SalePage) ([]*Sale, error)
+
+	// ReadSalesByStatus returns up to limit sales in status, oldest first.
+	ReadSalesByStatus(ctx context.Context, status string, limit int) ([]*Sale, error)
+
+	// SummarizeSales returns the metadata block of every sale matching
+	// filter.
+	SummarizeSales(ctx context.Context, filter SaleFilter) (metadata, error)
+
+	OutboxStorage
+}
+
+// cancelCheckInterval is how many sales a LocalStorage loop visits between
+// checks of its context.
+const cancelCheckInterval = 1024
+
+// counter is the running total of one (user, status) pair.
+type counter struct {
+	quantity int
+	totals   map[string]runningTotal // by currency
+}
+
+// runningTotal is a sum of units that keeps counting past the int64 range:
+// wraps is how many times units went around it, so taking back what was
+// added is always exact, and the sum only fits in units while wraps is zero.
+type runningTotal struct {
+	units int64
+	wraps int
+}
+
+func (t runningTotal) add(units int64) runningTotal {
+	sum := t.units + units
+	switch {
+	case units > 0 && sum < t.units:
+		t.wraps++
+	case units < 0 && sum > t.units:
+		t.wraps--
+	}
+	t.units = sum
+	return t
 }
 
-// LocalStorage provides an in-memory implementation for storing users.
+// LocalStorage provides a concurrency-safe in-memory implementation for
+// storing sales.
+//
+// Besides the primary map it keeps secondary indexes by UserID and by Status
+// and running counters per (UserID, Status), so listing a user's sales and
+// building their metadata never scans the whole store. Sales are copied on
+// the way in and out, so callers cannot change indexed fields behind its back.
+// Only the latest delivered outbox entries are kept.
 type LocalStorage struct {
-	s map[string]*Sale
+	mu sync.RWMutex
+
+	s        map[string]*Sale
+	byUser   map[string]map[string]struct{}
+	byStatus map[string]map[string]struct{}
+	counters map[string]map[string]*counter // userID -> status -> counter
+	history  map[string][]HistoryEntry      // saleID -> entries, oldest first
+	outbox   *localOutbox
 }
 
 // NewLocalStorage instantiates a new LocalStorage with an empty map.
 func NewLocalStorage() *LocalStorage {
 	return &LocalStorage{
-		s: map[string]*Sale{},
+		s:        map[string]*Sale{},
+		byUser:   map[string]map[string]struct{}{},
+		byStatus: map[string]map[string]struct{}{},
+		counters: map[string]map[string]*counter{},
+		history:  map[string][]HistoryEntry{},
+		outbox:   newLocalOutbox(),
 	}
 }
 
-// Set stores or updates a user in the local storage.
+// SetSale stores or updates a sale, keeping indexes and counters in sync.
 
-func (l *LocalStorage) SetSale(sale *Sale) error {
+func (l *LocalStorage) SetSale(ctx context.Context, sale *Sale, history ...HistoryEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if sale.ID == "" {
 		return ErrEmptyID
 	}
 
-	l.s[sale.ID] = sale
+	stored := *sale
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if old, ok := l.s[sale.ID]; ok {
+		l.unindex(old)
+	}
+	l.s[sale.ID] = &stored
+	l.index(&stored)
+	l.appendHistoryLocked(&stored, history)
+	return nil
+}
+
+// CompareAndSetSale swaps the sale under the write lock, so two concurrent
+// updates of the same version cannot both succeed.
+func (l *LocalStorage) CompareAndSetSale(ctx context.Context, sale *Sale, expectedVersion int, history ...HistoryEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stored := *sale
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old, ok := l.s[sale.ID]
+	if !ok {
+		return ErrNotFoundSale
+	}
+	if old.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+
+	l.unindex(old)
+	l.s[sale.ID] = &stored
+	l.index(&stored)
+	l.appendHistoryLocked(&stored, history)
 	return nil
 }
 
+// appendHistoryLocked records history for sale and queues its events.
+// Callers hold l.mu.
+func (l *LocalStorage) appendHistoryLocked(sale *Sale, history []HistoryEntry) {
+	l.history[sale.ID] = append(l.history[sale.ID], history...)
+	for _, h := range history {
+		l.outbox.add(newEvent(sale, h))
+	}
+}
+
+// ReadSaleHistory returns a copy of the entries recorded for the sale.
+func (l *LocalStorage) ReadSaleHistory(ctx context.Context, id string) ([]HistoryEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return append([]HistoryEntry{}, l.history[id]...), nil
+}
+
 // Read retrieves a sale from the local storage by ID.
 
-func (l *LocalStorage) ReadSale(id string) (*Sale, error) {
+func (l *LocalStorage) ReadSale(ctx context.Context, id string) (*Sale, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	u, ok := l.s[id]
 	if !ok {
 		return nil, ErrNotFoundSale
 	}
-	return u, nil
+	sale := *u
+	return &sale, nil
 }
 
-func (l *LocalStorage) ReadAllSales() (map[string]*Sale, error) {
-	u := l.s
-	if len(u) == 0 {
+// ReadAllSales returns a snapshot of every stored sale keyed by ID.
+func (l *LocalStorage) ReadAllSales(ctx context.Context) (map[string]*Sale, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.s) == 0 {
 		return nil, ErrNotFoundSale
 	}
+
+	u := make(map[string]*Sale, len(l.s))
+	for id, stored := range l.s {
+		if len(u)%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		sale := *stored
+		u[id] = &sale
+	}
 	return u, nil
 }
+
+// ReadSalesByUserAndStatus walks the smaller of the user and status indexes.
+func (l *LocalStorage) ReadSalesByUserAndStatus(ctx context.Context, userID, status string) ([]*Sale, error) {
+	filter := SaleFilter{UserID: userID}
+	if status != "" {
+		filter.Statuses = []string{status}
+	}
+	sales, err := l.salesMatching(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	sortByCreation(sales)
+	return sales, nil
+}
+
+// ReadSalesPage sorts the sales matching filter in memory.
+func (l *LocalStorage) ReadSalesPage(ctx context.Context, filter SaleFilter, page SalePage) ([]*Sale, error) {
+	sales, err := l.salesMatching(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(sales, page), nil
+}
+
+// salesMatching copies the sales matching filter, in no particular order.
+func (l *LocalStorage) salesMatching(ctx context.Context, filter SaleFilter) ([]*Sale, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	sales := []*Sale{}
+	n := 0
+	err := l.candidatesLocked(filter, func(stored *Sale) error {
+		if n++; n%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if filter.matches(stored) {
+			sale := *stored
+			sales = append(sales, &sale)
+		}
+		return nil
+	})
+	return sales, err
+}
+
+// candidatesLocked calls fn with every sale that may match filter, walking
+// the smallest index that covers them, until fn fails. Callers hold l.mu.
+func (l *LocalStorage) candidatesLocked(filter SaleFilter, fn func(*Sale) error) error {
+	var indexes []map[string]struct{}
+	switch {
+	case filter.UserID != "":
+		indexes = []map[string]struct{}{l.byUser[filter.UserID]}
+		if len(filter.Statuses) > 0 {
+			byStatus, size := l.statusIndexes(filter.Statuses)
+			if size < len(indexes[0]) {
+				indexes = byStatus
+			}
+		}
+	case len(filter.Statuses) > 0:
+		indexes, _ = l.statusIndexes(filter.Statuses)
+	default:
+		for _, sale := range l.s {
+			if err := fn(sale); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, ids := range indexes {
+		for id := range ids {
+			if err := fn(l.s[id]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// statusIndexes returns the status indexes of statuses, each once, and how
+// many sales they hold. Callers hold l.mu.
+func (l *LocalStorage) statusIndexes(statuses []string) ([]map[string]struct{}, int) {
+	var (
+		indexes []map[string]struct{}
+		size    int
+	)
+	seen := map[string]bool{}
+	for _, st := range statuses {
+		if !seen[st] {
+			seen[st] = true
+			indexes = append(indexes, l.byStatus[st])
+			size += len(l.byStatus[st])
+		}
+	}
+	return indexes, size
+}
+
+// ReadSalesByStatus walks the status index.
+func (l *LocalStorage) ReadSalesByStatus(ctx context.Context, status string, limit int) ([]*Sale, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	ids := l.byStatus[status]
+	sales := make([]*Sale, 0, len(ids))
+	n := 0
+	for id := range ids {
+		if n++; n%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		sale := *l.s[id]
+		sales = append(sales, &sale)
+	}
+
+	sortByCreation(sales)
+	if len(sales) > limit {
+		sales = sales[:limit]
+	}
+	return sales, nil
+}
+
+// SummarizeSales answers from the running counters in O(number of statuses)
+// when filtering a user's sales by status alone, and scans the candidates
+// of ReadSalesPage otherwise.
+func (l *LocalStorage) SummarizeSales(ctx context.Context, filter SaleFilter) (metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return metadata{}, err
+	}
+
+	var meta metadata
+	if filter.UserID == "" || !filter.onlyUserAndStatus() {
+		sales, err := l.salesMatching(ctx, filter)
+		if err != nil {
+			return meta, err
+		}
+		for _, sale := range sales {
+			if err := meta.add(sale.Status, 1, sale.Amount); err != nil {
+				return meta, err
+			}
+		}
+		return meta, nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for st, c := range l.counters[filter.UserID] {
+		if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, st) {
+			continue
+		}
+		totals := make([]Money, 0, len(c.totals))
+		for currency, total := range c.totals {
+			if total.wraps != 0 {
+				return meta, fmt.Errorf("%w: total in %s", ErrAmountOverflow, currency)
+			}
+			totals = append(totals, Money{Units: total.units, Currency: currency})
+		}
+		if err := meta.add(st, c.quantity, totals...); err != nil {
+			return meta, err
+		}
+	}
+	return meta, nil
+}
+
+// index adds sale to the secondary indexes and counters. Callers hold l.mu.
+func (l *LocalStorage) index(sale *Sale) {
+	addToIndex(l.byUser, sale.UserID, sale.ID)
+	addToIndex(l.byStatus, sale.Status, sale.ID)
+
+	byStatus, ok := l.counters[sale.UserID]
+	if !ok {
+		byStatus = map[string]*counter{}
+		l.counters[sale.UserID] = byStatus
+	}
+	c, ok := byStatus[sale.Status]
+	if !ok {
+		c = &counter{totals: map[string]runningTotal{}}
+		byStatus[sale.Status] = c
+	}
+	c.quantity++
+	c.totals[sale.Amount.Currency] = c.totals[sale.Amount.Currency].add(sale.Amount.Units)
+}
+
+// unindex removes sale from the secondary indexes and counters. Callers hold l.mu.
+func (l *LocalStorage) unindex(sale *Sale) {
+	removeFromIndex(l.byUser, sale.UserID, sale.ID)
+	removeFromIndex(l.byStatus, sale.Status, sale.ID)
+
+	byStatus := l.counters[sale.UserID]
+	c := byStatus[sale.Status]
+	c.quantity--
+	c.totals[sale.Amount.Currency] = c.totals[sale.Amount.Currency].add(-sale.Amount.Units)
+	if c.totals[sale.Amount.Currency] == (runningTotal{}) {
+		delete(c.totals, sale.Amount.Currency)
+	}
+	if c.quantity == 0 {
+		delete(byStatus, sale.Status)
+		if len(byStatus) == 0 {
+			delete(l.counters, sale.UserID)
+		}
+	}
+}
+
+func addToIndex(index map[string]map[string]struct{}, key, id string) {
+	ids, ok := index[key]
+	if !ok {
+		ids = map[string]struct{}{}
+		index[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func removeFromIndex(index map[string]map[string]struct{}, key, id string) {
+	ids := index[key]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(index, key)
+	}
+}
+
+// sortByCreation orders sales oldest first, breaking ties by ID so the order
+// is stable between calls.
+func sortByCreation(sales []*Sale) {
+	sort.Slice(sales, func(i, j int) bool {
+		if !sales[i].CreatedAt.Equal(sales[j].CreatedAt) {
+			return sales[i].CreatedAt.Before(sales[j].CreatedAt)
+		}
+		return sales[i].ID < sales[j].ID
+	})
+}
+
+// ReadOutbox implements OutboxStorage.
+func (l *LocalStorage) ReadOutbox(ctx context.Context, filter OutboxFilter) ([]*OutboxEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.outbox.read(filter), nil
+}
+
+// ReadOutboxEntry implements OutboxStorage.
+func (l *LocalStorage) ReadOutboxEntry(ctx context.Context, seq int64) (*OutboxEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.outbox.get(seq)
+}
+
+// UpdateOutboxEntry implements OutboxStorage.
+func (l *LocalStorage) UpdateOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.outbox.update(entry)
+}
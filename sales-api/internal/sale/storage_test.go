@@ -0,0 +1,187 @@
+package sale
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage_IndexesFollowUpdates(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocalStorage()
+
+	require.NoError(t, l.SetSale(ctx, &Sale{ID: "1", UserID: "u1", Amount: ars(10), Status: "pending"}))
+	require.NoError(t, l.SetSale(ctx, &Sale{ID: "2", UserID: "u1", Amount: ars(20), Status: "pending"}))
+	require.NoError(t, l.SetSale(ctx, &Sale{ID: "3", UserID: "u2", Amount: ars(40), Status: "rejected"}))
+
+	// Changing a sale read from the store must not touch the stored copy.
+	sale, err := l.ReadSale(ctx, "1")
+	require.NoError(t, err)
+	sale.Status = "approved"
+
+	pending, err := l.ReadSalesByUserAndStatus(ctx, "u1", "pending")
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+
+	require.NoError(t, l.SetSale(ctx, sale))
+
+	pending, err = l.ReadSalesByUserAndStatus(ctx, "u1", "pending")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, "2", pending[0].ID)
+
+	meta, err := l.SummarizeSales(ctx, SaleFilter{UserID: "u1"})
+	require.NoError(t, err)
+	require.Equal(t, metadata{Quantity: 2, Approved: 1, Pending: 1, TotalAmount: []Money{ars(30)}}, meta)
+
+	meta, err = l.SummarizeSales(ctx, SaleFilter{UserID: "u1", Statuses: []string{"approved"}})
+	require.NoError(t, err)
+	require.Equal(t, metadata{Quantity: 1, Approved: 1, TotalAmount: []Money{ars(10)}}, meta)
+
+	meta, err = l.SummarizeSales(ctx, SaleFilter{UserID: "nobody"})
+	require.NoError(t, err)
+	require.Equal(t, metadata{}, meta)
+}
+
+// ars returns a whole amount of pesos.
+func ars(pesos int64) Money {
+	return Money{Units: pesos * 100, Currency: "ARS"}
+}
+
+func TestLocalStorage_ConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocalStorage()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+				require.NoError(t, l.SetSale(ctx, &Sale{ID: id, UserID: "u1", Amount: ars(1), Status: "pending"}))
+				require.NoError(t, l.SetSale(ctx, &Sale{ID: id, UserID: "u1", Amount: ars(1), Status: "approved"}))
+				_, err := l.ReadSalesByUserAndStatus(ctx, "u1", "approved")
+				require.NoError(t, err)
+				_, err = l.SummarizeSales(ctx, SaleFilter{UserID: "u1"})
+				require.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	meta, err := l.SummarizeSales(ctx, SaleFilter{UserID: "u1"})
+	require.NoError(t, err)
+	require.Equal(t, 1600, meta.Quantity)
+	require.Equal(t, 1600, meta.Approved)
+	require.Equal(t, 0, meta.Pending)
+}
+
+func TestSQLiteStorage_MigratesLegacyAmounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sales.db")
+
+	// Build a database as it was before amounts became exact.
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	require.NoError(t, migrate(db, saleMigrations[:2]))
+	_, err = db.Exec(`INSERT INTO sales (id, user_id, amount, status, created_at, updated_at, version)
+		VALUES ('1', 'u1', 19.99, 'pending', 1, 1, 1)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	s, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	sale, err := s.ReadSale(context.Background(), "1")
+	require.NoError(t, err)
+	require.Equal(t, MustParseMoney("19.99", "ARS"), sale.Amount)
+}
+
+// benchSales is a store with 1M sales spread over 10k users, shared by the
+// benchmarks below because building it dominates their run time.
+var benchSales = sync.OnceValue(func() *LocalStorage {
+	ctx := context.Background()
+	l := NewLocalStorage()
+	statuses := []string{"pending", "approved", "rejected"}
+	now := time.Now()
+	for i := 0; i < 1_000_000; i++ {
+		l.SetSale(ctx, &Sale{
+			ID:        fmt.Sprintf("sale-%d", i),
+			UserID:    fmt.Sprintf("user-%d", i%10_000),
+			Amount:    ars(int64(i%500) + 1),
+			Status:    statuses[i%len(statuses)],
+			CreatedAt: now,
+			Version:   1,
+		})
+	}
+	return l
+})
+
+// BenchmarkGetSaleByUserAndStatus_FullScan reproduces the previous
+// implementation: filter and aggregate every sale on each request.
+func BenchmarkGetSaleByUserAndStatus_FullScan(b *testing.B) {
+	l := benchSales()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var meta metadata
+		var filtered []Sale
+		l.mu.RLock()
+		for _, sale := range l.s {
+			if sale.UserID != "user-42" {
+				continue
+			}
+			if sale.Status == "pending" {
+				filtered = append(filtered, *sale)
+			}
+		}
+		l.mu.RUnlock()
+		for _, sale := range filtered {
+			meta.add(sale.Status, 1, sale.Amount)
+		}
+	}
+}
+
+func BenchmarkGetSaleByUserAndStatus_Indexed(b *testing.B) {
+	s := NewService(benchSales(), nil, "")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := s.ListSales(context.Background(), SaleFilter{UserID: "user-42", Statuses: []string{"pending"}}, PageRequest{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStorage_SummarizeSalesOverflow(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			huge := Money{Units: math.MaxInt64/2 + 1, Currency: "ARS"}
+			for _, id := range []string{"1", "2"} {
+				require.NoError(t, storage.SetSale(ctx, &Sale{ID: id, UserID: "u1", Amount: huge, Status: StatusPending}))
+			}
+
+			// From the running counters and from the sales themselves.
+			for _, filter := range []SaleFilter{{UserID: "u1"}, {UserID: "u1", MinAmount: &Money{Units: 1, Currency: "ARS"}}} {
+				_, err := storage.SummarizeSales(ctx, filter)
+				require.ErrorIs(t, err, ErrAmountOverflow)
+			}
+
+			// Taking a sale back out leaves an exact total.
+			require.NoError(t, storage.SetSale(ctx, &Sale{ID: "2", UserID: "u1", Amount: huge, Status: StatusCancelled}))
+			meta, err := storage.SummarizeSales(ctx, SaleFilter{UserID: "u1", Statuses: []string{StatusPending}})
+			require.NoError(t, err)
+			require.Equal(t, []Money{huge}, meta.TotalAmount)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package sale
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// UserCacheConfig tunes a CachingUserClient. Zero fields take the defaults of
+// DefaultUserCacheConfig.
+type UserCacheConfig struct {
+	// Size is the most users kept; the least recently used goes first.
+	Size int
+
+	// TTL is how long a found user is trusted.
+	TTL time.Duration
+
+	// NegativeTTL is how long a "user not found" answer is trusted. It is
+	// kept short so a user created right after a failed sale can buy.
+	NegativeTTL time.Duration
+}
+
+// DefaultUserCacheConfig returns the settings used for fields left empty.
+func DefaultUserCacheConfig() UserCacheConfig {
+	return UserCacheConfig{
+		Size:        10000,
+		TTL:         time.Minute,
+		NegativeTTL: 10 * time.Second,
+	}
+}
+
+// UserCacheStats counts how a CachingUserClient has been doing since it
+// started.
+type UserCacheStats struct {
+	Hits          uint64 `json:"hits"`
+	NegativeHits  uint64 `json:"negative_hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Size          int    `json:"size"`
+	Capacity      int    `json:"capacity"`
+}
+
+// CachingUserClient is a UserClient that remembers the answers of another
+// one in a bounded LRU cache with TTL. Both found users and ErrUserNotFound
+// are cached; failures to reach users-api never are.
+type CachingUserClient struct {
+	next UserClient
+	cfg  UserCacheConfig
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+	stats   UserCacheStats
+}
+
+// userCacheEntry is the value of each lru element.
+type userCacheEntry struct {
+	id        string
+	user      *User // nil for a cached ErrUserNotFound
+	expiresAt time.Time
+}
+
+// NewCachingUserClient puts a cache configured by cfg in front of next.
+func NewCachingUserClient(next UserClient, cfg UserCacheConfig) *CachingUserClient {
+	def := DefaultUserCacheConfig()
+	if cfg.Size == 0 {
+		cfg.Size = def.Size
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = def.TTL
+	}
+	if cfg.NegativeTTL == 0 {
+		cfg.NegativeTTL = def.NegativeTTL
+	}
+	return &CachingUserClient{
+		next:    next,
+		cfg:     cfg,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		stats:   UserCacheStats{Capacity: cfg.Size},
+	}
+}
+
+// GetUser implements UserClient.
+func (c *CachingUserClient) GetUser(ctx context.Context, id string) (*User, error) {
+	if user, found, ok := c.lookup(id); ok {
+		if !found {
+			return nil, ErrUserNotFound
+		}
+		return user, nil
+	}
+
+	user, err := c.next.GetUser(ctx, id)
+	switch {
+	case err == nil:
+		c.store(id, user, c.cfg.TTL)
+	case errors.Is(err, ErrUserNotFound):
+		c.store(id, nil, c.cfg.NegativeTTL)
+	}
+	return user, err
+}
+
+// lookup returns a copy of the cached user. ok is false on a miss, and found
+// is false for a cached ErrUserNotFound.
+func (c *CachingUserClient) lookup(id string) (user *User, found, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		c.stats.Misses++
+		return nil, false, false
+	}
+	entry := el.Value.(*userCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.removeLocked(el)
+		c.stats.Misses++
+		return nil, false, false
+	}
+
+	c.lru.MoveToFront(el)
+	if entry.user == nil {
+		c.stats.NegativeHits++
+		return nil, false, true
+	}
+	c.stats.Hits++
+	u := *entry.user
+	return &u, true, true
+}
+
+// store caches user (nil meaning not found) for ttl, evicting the least
+// recently used entry if the cache is full.
+func (c *CachingUserClient) store(id string, user *User, ttl time.Duration) {
+	var stored *User
+	if user != nil {
+		u := *user
+		stored = &u
+	}
+	entry := &userCacheEntry{id: id, user: stored, expiresAt: c.now().Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	if c.lru.Len() >= c.cfg.Size {
+		c.removeLocked(c.lru.Back())
+		c.stats.Evictions++
+	}
+	c.entries[id] = c.lru.PushFront(entry)
+}
+
+// Invalidate forgets what is cached about a user, e.g. because users-api
+// reported it deleted.
+func (c *CachingUserClient) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		c.removeLocked(el)
+		c.stats.Invalidations++
+	}
+}
+
+// Stats returns the counters of the cache.
+func (c *CachingUserClient) Stats() UserCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// removeLocked drops an element. Callers hold c.mu.
+func (c *CachingUserClient) removeLocked(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*userCacheEntry).id)
+}
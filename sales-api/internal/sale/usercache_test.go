@@ -0,0 +1,91 @@
+package sale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockUserClient struct {
+	mockGetUser func(id string) (*User, error)
+}
+
+func (m *mockUserClient) GetUser(_ context.Context, id string) (*User, error) {
+	return m.mockGetUser(id)
+}
+
+func TestCachingUserClient(t *testing.T) {
+	ctx := context.Background()
+	calls := map[string]int{}
+	down := false
+	next := &mockUserClient{
+		mockGetUser: func(id string) (*User, error) {
+			calls[id]++
+			switch {
+			case down:
+				return nil, ErrTryingToGetUser
+			case id == "ghost":
+				return nil, ErrUserNotFound
+			}
+			return &User{ID: id, Status: "active"}, nil
+		},
+	}
+
+	now := time.Now()
+	c := NewCachingUserClient(next, UserCacheConfig{Size: 2, TTL: time.Minute, NegativeTTL: time.Second})
+	c.now = func() time.Time { return now }
+
+	// Found and not-found answers are both remembered.
+	for i := 0; i < 3; i++ {
+		user, err := c.GetUser(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "a", user.ID)
+		_, err = c.GetUser(ctx, "ghost")
+		require.ErrorIs(t, err, ErrUserNotFound)
+	}
+	require.Equal(t, 1, calls["a"])
+	require.Equal(t, 1, calls["ghost"])
+	require.Equal(t, UserCacheStats{Hits: 2, NegativeHits: 2, Misses: 2, Size: 2, Capacity: 2}, c.Stats())
+
+	// A caller changing its copy does not change the cache.
+	user, _ := c.GetUser(ctx, "a")
+	user.Status = "deleted"
+	user, _ = c.GetUser(ctx, "a")
+	require.Equal(t, "active", user.Status)
+
+	// Negative answers expire sooner.
+	now = now.Add(2 * time.Second)
+	_, _ = c.GetUser(ctx, "ghost")
+	_, _ = c.GetUser(ctx, "a")
+	require.Equal(t, 2, calls["ghost"])
+	require.Equal(t, 1, calls["a"])
+
+	// "ghost" was used last, so "a" is evicted to make room for "b".
+	_, _ = c.GetUser(ctx, "ghost")
+	_, _ = c.GetUser(ctx, "b")
+	_, _ = c.GetUser(ctx, "a")
+	require.Equal(t, 2, calls["a"])
+	require.EqualValues(t, 2, c.Stats().Evictions)
+
+	// Positive answers expire too.
+	now = now.Add(time.Minute)
+	_, _ = c.GetUser(ctx, "a")
+	require.Equal(t, 3, calls["a"])
+
+	// Failing to reach users-api is never cached.
+	down = true
+	_, err := c.GetUser(ctx, "c")
+	require.ErrorIs(t, err, ErrTryingToGetUser)
+	down = false
+	_, err = c.GetUser(ctx, "c")
+	require.NoError(t, err)
+	require.Equal(t, 2, calls["c"])
+
+	c.Invalidate("c")
+	c.Invalidate("never-cached")
+	_, _ = c.GetUser(ctx, "c")
+	require.Equal(t, 3, calls["c"])
+	require.EqualValues(t, 1, c.Stats().Invalidations)
+}
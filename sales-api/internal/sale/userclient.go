@@ -0,0 +1,154 @@
+package sale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+)
+
+// ErrUsersAPIUnavailable is returned without calling users-api while its
+// circuit breaker is open. It wraps ErrTryingToGetUser.
+var ErrUsersAPIUnavailable = fmt.Errorf("%w: users-api unavailable", ErrTryingToGetUser)
+
+// ErrInvalidUserPayload is returned when users-api answers with a user that
+// cannot be decoded. It wraps ErrTryingToGetUser.
+var ErrInvalidUserPayload = fmt.Errorf("%w: invalid user payload", ErrTryingToGetUser)
+
+// UserClient looks up buyers in users-api.
+type UserClient interface {
+	// GetUser returns the user with the given ID.
+	// Returns ErrUserNotFound if users-api does not know the user, and an
+	// error wrapping ErrTryingToGetUser if users-api could not be reached or
+	// refused the request.
+	GetUser(ctx context.Context, id string) (*User, error)
+}
+
+// UserClientConfig tunes an HTTPUserClient. Zero fields other than
+// MaxRetries take the defaults of DefaultUserClientConfig.
+type UserClientConfig struct {
+	// BaseURL is the base URL of users-api.
+	BaseURL string
+
+	// Timeout bounds each attempt, not the whole call.
+	Timeout time.Duration
+
+	// MaxRetries is how many times a 5xx or a network error is retried, with
+	// a jittered exponential backoff between RetryWait and RetryMaxWait.
+	// Zero disables retries.
+	MaxRetries   int
+	RetryWait    time.Duration
+	RetryMaxWait time.Duration
+
+	// BreakerThreshold consecutive failed calls open the circuit breaker for
+	// BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// DefaultUserClientConfig returns the settings used for fields left empty.
+func DefaultUserClientConfig() UserClientConfig {
+	return UserClientConfig{
+		Timeout:          2 * time.Second,
+		MaxRetries:       2,
+		RetryWait:        100 * time.Millisecond,
+		RetryMaxWait:     time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// HTTPUserClient is the UserClient that talks to users-api over HTTP.
+type HTTPUserClient struct {
+	client  *resty.Client
+	breaker *CircuitBreaker
+	logger  *zap.Logger
+}
+
+// NewHTTPUserClient builds an HTTPUserClient from cfg.
+func NewHTTPUserClient(cfg UserClientConfig, logger *zap.Logger) *HTTPUserClient {
+	def := DefaultUserClientConfig()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.RetryWait == 0 {
+		cfg.RetryWait = def.RetryWait
+	}
+	if cfg.RetryMaxWait == 0 {
+		cfg.RetryMaxWait = def.RetryMaxWait
+	}
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = def.BreakerThreshold
+	}
+	if cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = def.BreakerCooldown
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	client := resty.New().
+		SetBaseURL(cfg.BaseURL).
+		SetTimeout(cfg.Timeout).
+		SetRetryCount(cfg.MaxRetries).
+		SetRetryWaitTime(cfg.RetryWait).
+		SetRetryMaxWaitTime(cfg.RetryMaxWait).
+		AddRetryCondition(func(r *resty.Response, err error) bool {
+			return err != nil || r.StatusCode() >= http.StatusInternalServerError
+		})
+
+	return &HTTPUserClient{
+		client:  client,
+		breaker: NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		logger:  logger,
+	}
+}
+
+// Breaker returns the circuit breaker guarding users-api.
+func (c *HTTPUserClient) Breaker() *CircuitBreaker {
+	return c.breaker
+}
+
+// GetUser implements UserClient. A 404 answer means the user does not exist,
+// while other 4xx answers, such as a throttling 429, wrap
+// ErrTryingToGetUser. Only network errors and 5xx answers, once retries are
+// exhausted, count as failures for the circuit breaker.
+func (c *HTTPUserClient) GetUser(ctx context.Context, id string) (*User, error) {
+	if !c.breaker.Allow() {
+		return nil, ErrUsersAPIUnavailable
+	}
+
+	res, err := c.client.R().SetContext(ctx).Get("/users/" + url.PathEscape(id))
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		c.breaker.Ignore()
+		return nil, contextError(ctxErr)
+	}
+	if err != nil || res.StatusCode() >= http.StatusInternalServerError {
+		c.breaker.Failure()
+		c.logger.Warn("users-api call failed", zap.Error(err), zap.String("user_id", id),
+			zap.String("breaker", c.breaker.State().State))
+		return nil, ErrTryingToGetUser
+	}
+	if res.IsError() && res.StatusCode() != http.StatusNotFound {
+		c.breaker.Ignore()
+		c.logger.Warn("users-api refused the call", zap.String("user_id", id), zap.Int("status", res.StatusCode()))
+		return nil, fmt.Errorf("%w: users-api answered %s", ErrTryingToGetUser, res.Status())
+	}
+	c.breaker.Success()
+
+	if res.IsError() {
+		return nil, ErrUserNotFound
+	}
+
+	var user User
+	if err := json.Unmarshal(res.Body(), &user); err != nil {
+		c.logger.Error("invalid user payload", zap.Error(err), zap.String("user_id", id))
+		return nil, ErrInvalidUserPayload
+	}
+	return &user, nil
+}
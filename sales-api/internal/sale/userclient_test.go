@@ -0,0 +1,161 @@
+package sale
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testClientConfig keeps waits short so the tests run fast.
+func testClientConfig(url string) UserClientConfig {
+	return UserClientConfig{
+		BaseURL:          url,
+		Timeout:          200 * time.Millisecond,
+		MaxRetries:       2,
+		RetryWait:        time.Millisecond,
+		RetryMaxWait:     5 * time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	}
+}
+
+func TestHTTPUserClient_GetUser(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/users/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(`{"id":"flaky","status":"active"}`))
+		case "/users/down":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/users/throttled":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/users/garbled":
+			w.Write([]byte(`{"id":`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("retries 5xx", func(t *testing.T) {
+		calls.Store(0)
+		c := NewHTTPUserClient(testClientConfig(server.URL), nil)
+
+		user, err := c.GetUser(context.Background(), "flaky")
+		require.NoError(t, err)
+		require.Equal(t, "flaky", user.ID)
+		require.EqualValues(t, 3, calls.Load())
+		require.Equal(t, BreakerClosed, c.Breaker().State().State)
+	})
+
+	t.Run("does not retry 4xx", func(t *testing.T) {
+		calls.Store(0)
+		c := NewHTTPUserClient(testClientConfig(server.URL), nil)
+
+		_, err := c.GetUser(context.Background(), "missing")
+		require.ErrorIs(t, err, ErrUserNotFound)
+		require.EqualValues(t, 1, calls.Load())
+		require.Equal(t, 0, c.Breaker().State().ConsecutiveFailures)
+	})
+
+	t.Run("other 4xx are not a missing user", func(t *testing.T) {
+		calls.Store(0)
+		c := NewHTTPUserClient(testClientConfig(server.URL), nil)
+
+		for range 3 {
+			_, err := c.GetUser(context.Background(), "throttled")
+			require.ErrorIs(t, err, ErrTryingToGetUser)
+			require.NotErrorIs(t, err, ErrUserNotFound)
+		}
+		require.EqualValues(t, 3, calls.Load())
+		require.Equal(t, BreakerClosed, c.Breaker().State().State)
+	})
+
+	t.Run("undecodable user", func(t *testing.T) {
+		c := NewHTTPUserClient(testClientConfig(server.URL), nil)
+
+		_, err := c.GetUser(context.Background(), "garbled")
+		require.ErrorIs(t, err, ErrInvalidUserPayload)
+		require.ErrorIs(t, err, ErrTryingToGetUser)
+	})
+
+	t.Run("opens the breaker", func(t *testing.T) {
+		calls.Store(0)
+		c := NewHTTPUserClient(testClientConfig(server.URL), nil)
+		now := time.Now()
+		c.Breaker().now = func() time.Time { return now }
+
+		for i := 0; i < 2; i++ {
+			_, err := c.GetUser(context.Background(), "down")
+			require.ErrorIs(t, err, ErrTryingToGetUser)
+		}
+		require.EqualValues(t, 6, calls.Load(), "each call is tried three times")
+		require.Equal(t, BreakerOpen, c.Breaker().State().State)
+
+		// While open, users-api is not called at all.
+		_, err := c.GetUser(context.Background(), "flaky")
+		require.ErrorIs(t, err, ErrUsersAPIUnavailable)
+		require.EqualValues(t, 6, calls.Load())
+
+		// After the cooldown one trial goes through and closes it again.
+		now = now.Add(time.Minute)
+		require.Equal(t, BreakerHalfOpen, c.Breaker().State().State)
+		calls.Store(2)
+		user, err := c.GetUser(context.Background(), "flaky")
+		require.NoError(t, err)
+		require.Equal(t, "flaky", user.ID)
+		require.Equal(t, BreakerClosed, c.Breaker().State().State)
+	})
+
+	t.Run("times out each attempt", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+		defer slow.Close()
+
+		cfg := testClientConfig(slow.URL)
+		cfg.Timeout = 10 * time.Millisecond
+		cfg.MaxRetries = 0
+		c := NewHTTPUserClient(cfg, nil)
+
+		start := time.Now()
+		_, err := c.GetUser(context.Background(), "1234")
+		require.ErrorIs(t, err, ErrTryingToGetUser)
+		require.Less(t, time.Since(start), 90*time.Millisecond)
+	})
+}
+
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(1, time.Second)
+	b.now = func() time.Time { return now }
+
+	require.True(t, b.Allow())
+	b.Failure()
+	require.False(t, b.Allow())
+
+	now = now.Add(time.Second)
+	require.True(t, b.Allow(), "one trial call")
+	require.False(t, b.Allow(), "only one")
+
+	// A failed trial keeps it open for another cooldown.
+	b.Failure()
+	require.Equal(t, BreakerOpen, b.State().State)
+
+	now = now.Add(time.Second)
+	require.True(t, b.Allow())
+	b.Ignore()
+	require.True(t, b.Allow(), "an ignored trial frees the slot")
+	b.Success()
+	require.Equal(t, BreakerState{State: BreakerClosed}, b.State())
+}
@@ -0,0 +1,77 @@
+package sale
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// User event types sent by users-api that the Service reacts to.
+const (
+	UserEventDeleted = "user.deleted"
+)
+
+// ReasonUserDeleted is the reason given to sales cancelled because their
+// buyer was deleted.
+const ReasonUserDeleted = "user_deleted"
+
+// ActorUsersAPI is recorded in the history of the sales changed because of a
+// users-api event.
+const ActorUsersAPI = "users-api"
+
+// UserEvent is a notification from users-api about one of its users.
+//
+// users-api delivers each event at least once, so handling the same event
+// twice must leave the sales as handling it once would.
+type UserEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	UserID     string    `json:"user_id"`
+	Version    int       `json:"version"`
+}
+
+// HandleUserEvent applies a users-api event. Any event drops the cached
+// copy of the user, so a suspension or a block is honored right away. For
+// user.deleted every pending sale of the user is also cancelled with
+// ReasonUserDeleted, and cancelled counts them; a redelivery finds nothing
+// left to cancel. Sales on hold or awaiting verification are left for their
+// own review.
+func (s *Service) HandleUserEvent(ctx context.Context, event UserEvent) (cancelled int, err error) {
+	if event.UserID == "" {
+		return 0, ErrInvalidInput
+	}
+
+	if cache, ok := s.users.(interface{ Invalidate(id string) }); ok {
+		cache.Invalidate(event.UserID)
+	}
+	if event.Type != UserEventDeleted {
+		return 0, nil
+	}
+
+	ctx = WithActor(ctx, ActorUsersAPI)
+	updates := &UpdateFieldsSale{Status: StatusCancelled, Reason: ReasonUserDeleted}
+	sales, err := s.storage.ReadSalesByUserAndStatus(ctx, event.UserID, StatusPending)
+	if err != nil {
+		return 0, contextError(err)
+	}
+	for _, sale := range sales {
+		_, err := s.UpdateSale(ctx, sale.ID, updates, 0)
+		if errors.Is(err, ErrTransactionInvalid) {
+			// It moved past cancellation since it was read.
+			continue
+		}
+		if err != nil {
+			return cancelled, err
+		}
+		cancelled++
+	}
+
+	if cancelled > 0 {
+		s.logger.Info("cancelled sales of deleted user", zap.String("user_id", event.UserID),
+			zap.String("event_id", event.ID), zap.Int("cancelled", cancelled))
+	}
+	return cancelled, nil
+}
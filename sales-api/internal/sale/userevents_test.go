@@ -0,0 +1,78 @@
+package sale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_HandleUserEvent_Deleted(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			users := &mockUserClient{
+				mockGetUser: func(id string) (*User, error) {
+					// A new account keeps its sales pending.
+					return &User{ID: id, CreatedAt: time.Now()}, nil
+				},
+			}
+			s := NewService(newStorage(), nil, "", WithUserClient(users))
+
+			create := func(userID string) *Sale {
+				sale := &Sale{UserID: userID, Amount: MustParseMoney("10", "ARS")}
+				require.NoError(t, s.CreateSale(ctx, sale))
+				require.Equal(t, StatusPending, sale.Status)
+				return sale
+			}
+			pending, other := create("1234"), create("5678")
+			onHold, approved := create("1234"), create("1234")
+			_, err := s.UpdateSale(ctx, onHold.ID, &UpdateFieldsSale{Status: StatusOnHold}, 0)
+			require.NoError(t, err)
+			_, err = s.UpdateSale(ctx, approved.ID, &UpdateFieldsSale{Status: StatusApproved}, 0)
+			require.NoError(t, err)
+
+			event := UserEvent{ID: "user.deleted:1234:2", Type: UserEventDeleted, UserID: "1234", Version: 2}
+			cancelled, err := s.HandleUserEvent(ctx, event)
+			require.NoError(t, err)
+			require.Equal(t, 1, cancelled)
+
+			for id, status := range map[string]string{
+				pending.ID:  StatusCancelled,
+				onHold.ID:   StatusOnHold,
+				approved.ID: StatusApproved,
+				other.ID:    StatusPending,
+			} {
+				got, err := s.GetSale(ctx, id)
+				require.NoError(t, err)
+				require.Equal(t, status, got.Status)
+			}
+
+			got, err := s.GetSale(ctx, pending.ID)
+			require.NoError(t, err)
+			require.Equal(t, ReasonUserDeleted, got.StatusReason)
+			history, err := s.GetSaleHistory(ctx, pending.ID)
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+			require.Equal(t, StatusPending, history[1].FromStatus)
+			require.Equal(t, ReasonUserDeleted, history[1].Reason)
+			require.Equal(t, ActorUsersAPI, history[1].Actor)
+
+			// A redelivery changes nothing.
+			cancelled, err = s.HandleUserEvent(ctx, event)
+			require.NoError(t, err)
+			require.Zero(t, cancelled)
+			history, err = s.GetSaleHistory(ctx, pending.ID)
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+
+			cancelled, err = s.HandleUserEvent(ctx, UserEvent{ID: "x", Type: "user.renamed", UserID: "5678"})
+			require.NoError(t, err)
+			require.Zero(t, cancelled)
+
+			_, err = s.HandleUserEvent(ctx, UserEvent{ID: "x", Type: UserEventDeleted})
+			require.ErrorIs(t, err, ErrInvalidInput)
+		})
+	}
+}
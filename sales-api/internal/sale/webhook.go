@@ -0,0 +1,715 @@
+package sale
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrWebhookNotFound is returned when no webhook has the given ID.
+	ErrWebhookNotFound = errors.New("webhook not found")
+
+	// ErrInvalidWebhook is returned for a webhook with a bad URL or an
+	// unknown event type. It wraps ErrInvalidInput.
+	ErrInvalidWebhook = fmt.Errorf("%w: invalid webhook", ErrInvalidInput)
+)
+
+// Webhook subscribes a URL to sale events. Only events of EventTypes, and
+// only sales of UserID, are sent; an empty filter lets everything through.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+
+	// Secret signs every delivery. It is only shown when it is set.
+	Secret string `json:"secret,omitempty"`
+
+	EventTypes []string `json:"event_types"`
+	UserID     string   `json:"user_id,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// matches reports whether event passes the filters of the webhook.
+func (w *Webhook) matches(event Event) bool {
+	if len(w.EventTypes) > 0 && !slices.Contains(w.EventTypes, event.Type) {
+		return false
+	}
+	return w.UserID == "" || w.UserID == event.UserID
+}
+
+// redacted returns a copy of w without its secret.
+func (w *Webhook) redacted() *Webhook {
+	c := *w
+	c.Secret = ""
+	return &c
+}
+
+// validate checks the URL and event types of w.
+func (w *Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalidWebhook)
+	}
+	for _, t := range w.EventTypes {
+		if t != EventSaleCreated && t != EventSaleStatusChanged {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, t)
+		}
+	}
+	return nil
+}
+
+// UpdateFieldsWebhook holds the fields a PATCH may change; nil means keep.
+type UpdateFieldsWebhook struct {
+	URL        *string   `json:"url"`
+	Secret     *string   `json:"secret"`
+	EventTypes *[]string `json:"event_types"`
+	UserID     *string   `json:"user_id"`
+}
+
+// WebhookDelivery is one event on its way to one webhook. Its Status is one
+// of the outbox statuses: pending until the receiver answers 2xx, dead after
+// too many failed attempts.
+type WebhookDelivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	Event     Event  `json:"event"`
+
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	LastStatusCode int    `json:"last_status_code,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+
+	CreatedAt     time.Time `json:"created_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	DeliveredAt   time.Time `json:"delivered_at,omitzero"`
+}
+
+// WebhookStore keeps webhooks and their deliveries.
+type WebhookStore interface {
+	// SetWebhook inserts or replaces a webhook.
+	SetWebhook(ctx context.Context, w *Webhook) error
+
+	// ReadWebhook returns ErrWebhookNotFound if there is no such webhook.
+	ReadWebhook(ctx context.Context, id string) (*Webhook, error)
+
+	// ReadWebhooks returns every webhook, oldest first.
+	ReadWebhooks(ctx context.Context) ([]*Webhook, error)
+
+	// DeleteWebhook removes a webhook and its deliveries.
+	// Returns ErrWebhookNotFound if there is no such webhook.
+	DeleteWebhook(ctx context.Context, id string) error
+
+	// AddDeliveries stores new deliveries, skipping any whose webhook
+	// already has a delivery of the same event.
+	AddDeliveries(ctx context.Context, deliveries ...*WebhookDelivery) error
+
+	// ReadDueDeliveries returns up to limit pending deliveries whose next
+	// attempt is not after now, oldest first.
+	ReadDueDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error)
+
+	// ReadDeliveries returns up to limit deliveries of a webhook, newest
+	// first. A zero limit means no limit.
+	ReadDeliveries(ctx context.Context, webhookID string, limit int) ([]*WebhookDelivery, error)
+
+	// UpdateDelivery stores the delivery state of an existing delivery.
+	UpdateDelivery(ctx context.Context, d *WebhookDelivery) error
+}
+
+// Headers sent with every webhook delivery.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// SignWebhook returns the value of WebhookSignatureHeader for body sent at
+// timestamp (unix seconds): "sha256=" and the hex HMAC-SHA256, keyed by
+// secret, of the timestamp, a dot and the body. Receivers should recompute
+// it, compare with hmac.Equal and reject stale timestamps.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSecret returns a random secret for a webhook created without one.
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// WebhookConfig tunes a WebhookService. Zero fields take the defaults of
+// DefaultWebhookConfig.
+type WebhookConfig struct {
+	// Timeout bounds each POST.
+	Timeout time.Duration
+
+	// Interval is how often due deliveries are looked up, and Batch bounds
+	// how many one pass sends.
+	Interval time.Duration
+	Batch    int
+
+	// MaxAttempts is how many times a delivery is tried before it is marked
+	// dead, waiting from RetryWait up to RetryMaxWait, doubling, in between.
+	MaxAttempts  int
+	RetryWait    time.Duration
+	RetryMaxWait time.Duration
+}
+
+// DefaultWebhookConfig returns the settings used for fields left empty.
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Timeout:      10 * time.Second,
+		Interval:     time.Second,
+		Batch:        100,
+		MaxAttempts:  8,
+		RetryWait:    time.Second,
+		RetryMaxWait: 10 * time.Minute,
+	}
+}
+
+// WebhookService manages webhook subscriptions and delivers sale events to
+// them. It is a Publisher: the outbox hands it every event, it stores a
+// delivery per matching webhook, and Run sends them, so a slow or broken
+// receiver never holds back the others.
+type WebhookService struct {
+	store  WebhookStore
+	client *resty.Client
+	cfg    WebhookConfig
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewWebhookService builds a WebhookService keeping its state in store.
+func NewWebhookService(store WebhookStore, cfg WebhookConfig, logger *zap.Logger) *WebhookService {
+	def := DefaultWebhookConfig()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.Batch == 0 {
+		cfg.Batch = def.Batch
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.RetryWait == 0 {
+		cfg.RetryWait = def.RetryWait
+	}
+	if cfg.RetryMaxWait == 0 {
+		cfg.RetryMaxWait = def.RetryMaxWait
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &WebhookService{
+		store:  store,
+		client: resty.New().SetTimeout(cfg.Timeout),
+		cfg:    cfg,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// CreateWebhook validates and stores w, generating its ID and, if empty,
+// its secret. The returned webhook is the only one that shows the secret.
+func (s *WebhookService) CreateWebhook(ctx context.Context, w *Webhook) error {
+	if err := w.validate(); err != nil {
+		return err
+	}
+	if w.EventTypes == nil {
+		w.EventTypes = []string{}
+	}
+	if w.Secret == "" {
+		w.Secret = newWebhookSecret()
+	}
+	w.ID = uuid.NewString()
+	w.CreatedAt = s.now()
+	w.UpdatedAt = w.CreatedAt
+
+	if err := s.store.SetWebhook(ctx, w); err != nil {
+		return contextError(err)
+	}
+	s.logger.Info("webhook created", zap.String("id", w.ID), zap.String("url", w.URL))
+	return nil
+}
+
+// GetWebhook returns a webhook, without its secret.
+func (s *WebhookService) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	w, err := s.store.ReadWebhook(ctx, id)
+	if err != nil {
+		return nil, contextError(err)
+	}
+	return w.redacted(), nil
+}
+
+// ListWebhooks returns every webhook, oldest first, without their secrets.
+func (s *WebhookService) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	webhooks, err := s.store.ReadWebhooks(ctx)
+	if err != nil {
+		return nil, contextError(err)
+	}
+	for i, w := range webhooks {
+		webhooks[i] = w.redacted()
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook applies updates to a webhook. A new secret is shown in the
+// result; otherwise the secret is left out.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, id string, updates *UpdateFieldsWebhook) (*Webhook, error) {
+	w, err := s.store.ReadWebhook(ctx, id)
+	if err != nil {
+		return nil, contextError(err)
+	}
+
+	if updates.URL != nil {
+		w.URL = *updates.URL
+	}
+	if updates.EventTypes != nil {
+		w.EventTypes = *updates.EventTypes
+	}
+	if updates.UserID != nil {
+		w.UserID = *updates.UserID
+	}
+	if updates.Secret != nil {
+		if *updates.Secret == "" {
+			return nil, fmt.Errorf("%w: secret cannot be empty", ErrInvalidWebhook)
+		}
+		w.Secret = *updates.Secret
+	}
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	if w.EventTypes == nil {
+		w.EventTypes = []string{}
+	}
+	w.UpdatedAt = s.now()
+
+	if err := s.store.SetWebhook(ctx, w); err != nil {
+		return nil, contextError(err)
+	}
+	if updates.Secret == nil {
+		return w.redacted(), nil
+	}
+	return w, nil
+}
+
+// DeleteWebhook removes a webhook; its pending deliveries are dropped.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id string) error {
+	if err := s.store.DeleteWebhook(ctx, id); err != nil {
+		return contextError(err)
+	}
+	s.logger.Info("webhook deleted", zap.String("id", id))
+	return nil
+}
+
+// Deliveries returns up to limit deliveries of a webhook, newest first.
+// Returns ErrWebhookNotFound if the webhook does not exist.
+func (s *WebhookService) Deliveries(ctx context.Context, id string, limit int) ([]*WebhookDelivery, error) {
+	if _, err := s.store.ReadWebhook(ctx, id); err != nil {
+		return nil, contextError(err)
+	}
+	deliveries, err := s.store.ReadDeliveries(ctx, id, limit)
+	if err != nil {
+		return nil, contextError(err)
+	}
+	return deliveries, nil
+}
+
+// Publish implements Publisher by queuing a delivery of event for every
+// matching webhook. Being handed the same event again queues nothing new.
+func (s *WebhookService) Publish(ctx context.Context, event Event) error {
+	webhooks, err := s.store.ReadWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	var deliveries []*WebhookDelivery
+	for _, w := range webhooks {
+		if !w.matches(event) {
+			continue
+		}
+		deliveries = append(deliveries, &WebhookDelivery{
+			ID:            uuid.NewString(),
+			WebhookID:     w.ID,
+			Event:         event,
+			Status:        OutboxPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return s.store.AddDeliveries(ctx, deliveries...)
+}
+
+// DeliverPending sends every due delivery once, up to a batch, oldest first.
+func (s *WebhookService) DeliverPending(ctx context.Context) (DispatchResult, error) {
+	var result DispatchResult
+
+	now := s.now()
+	deliveries, err := s.store.ReadDueDeliveries(ctx, now, s.cfg.Batch)
+	if err != nil {
+		return result, contextError(err)
+	}
+
+	for _, d := range deliveries {
+		w, err := s.store.ReadWebhook(ctx, d.WebhookID)
+		if errors.Is(err, ErrWebhookNotFound) {
+			// Deleted since the pass started.
+			continue
+		}
+		if err != nil {
+			return result, contextError(err)
+		}
+
+		d.Attempts++
+		code, err := s.post(ctx, w, d)
+		d.LastStatusCode = code
+		switch {
+		case err == nil:
+			d.Status = OutboxDelivered
+			d.DeliveredAt = s.now()
+			d.LastError = ""
+			result.Delivered++
+		case d.Attempts >= s.cfg.MaxAttempts:
+			d.Status = OutboxDead
+			d.LastError = err.Error()
+			result.Dead++
+			s.logger.Error("giving up on webhook delivery", zap.Error(err), zap.String("webhook_id", w.ID),
+				zap.String("event_id", d.Event.ID), zap.Int("attempts", d.Attempts))
+		default:
+			d.NextAttemptAt = now.Add(backoff(d.Attempts, s.cfg.RetryWait, s.cfg.RetryMaxWait))
+			d.LastError = err.Error()
+			result.Retried++
+			s.logger.Warn("webhook delivery failed", zap.Error(err), zap.String("webhook_id", w.ID),
+				zap.String("event_id", d.Event.ID), zap.Int("attempt", d.Attempts), zap.Time("next_attempt_at", d.NextAttemptAt))
+		}
+
+		if err := s.store.UpdateDelivery(ctx, d); err != nil {
+			return result, contextError(err)
+		}
+	}
+	return result, nil
+}
+
+// post sends d to w, signed, and returns the status code of the answer, if
+// any. Anything but a 2xx is an error.
+func (s *WebhookService) post(ctx context.Context, w *Webhook, d *WebhookDelivery) (int, error) {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := s.now().Unix()
+
+	res, err := s.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader(WebhookSignatureHeader, SignWebhook(w.Secret, timestamp, body)).
+		SetHeader(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10)).
+		SetHeader(WebhookEventHeader, d.Event.Type).
+		SetHeader(WebhookDeliveryHeader, d.ID).
+		SetBody(body).
+		Post(w.URL)
+	if err != nil {
+		return 0, err
+	}
+	if res.StatusCode()/100 != 2 {
+		return res.StatusCode(), fmt.Errorf("webhook answered %s", res.Status())
+	}
+	return res.StatusCode(), nil
+}
+
+// Run delivers every Interval until ctx is done. A full batch is followed
+// right away by another pass, so a backlog drains without waiting.
+func (s *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			result, err := s.DeliverPending(ctx)
+			if err != nil {
+				s.logger.Error("webhook delivery pass failed", zap.Error(err))
+				break
+			}
+			if result.Delivered+result.Retried+result.Dead < s.cfg.Batch {
+				break
+			}
+		}
+	}
+}
+
+// keptCompletedDeliveries is how many delivered or dead deliveries a
+// LocalWebhookStore keeps around to be listed; older ones are dropped.
+const keptCompletedDeliveries = 1000
+
+// LocalWebhookStore is an in-memory WebhookStore. Deliveries are kept in the
+// order they were added and the pending ones are indexed, so a delivery pass
+// never goes through the completed ones, of which only the latest keep are
+// kept. An event handed again after its delivery was dropped is delivered
+// again.
+type LocalWebhookStore struct {
+	mu       sync.RWMutex
+	webhooks map[string]*Webhook
+
+	deliveries map[int64]*WebhookDelivery
+	lastSeq    int64
+	seqs       map[string]int64      // delivery ID -> seq
+	events     map[deliveryKey]int64 // webhook and event -> seq
+	pending    []int64               // seq of the pending deliveries, ascending
+	completed  []int64               // seq of the delivered or dead ones, oldest first
+	keep       int
+}
+
+// deliveryKey identifies the delivery of an event to a webhook.
+type deliveryKey struct {
+	webhookID string
+	eventID   string
+}
+
+// NewLocalWebhookStore instantiates an empty LocalWebhookStore.
+func NewLocalWebhookStore() *LocalWebhookStore {
+	return &LocalWebhookStore{
+		webhooks:   map[string]*Webhook{},
+		deliveries: map[int64]*WebhookDelivery{},
+		seqs:       map[string]int64{},
+		events:     map[deliveryKey]int64{},
+		keep:       keptCompletedDeliveries,
+	}
+}
+
+// SetWebhook implements WebhookStore.
+func (l *LocalWebhookStore) SetWebhook(ctx context.Context, w *Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stored := *w
+	stored.EventTypes = slices.Clone(w.EventTypes)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.webhooks[w.ID] = &stored
+	return nil
+}
+
+// ReadWebhook implements WebhookStore.
+func (l *LocalWebhookStore) ReadWebhook(ctx context.Context, id string) (*Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	w, ok := l.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	c := *w
+	c.EventTypes = slices.Clone(w.EventTypes)
+	return &c, nil
+}
+
+// ReadWebhooks implements WebhookStore.
+func (l *LocalWebhookStore) ReadWebhooks(ctx context.Context) ([]*Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(l.webhooks))
+	for _, w := range l.webhooks {
+		c := *w
+		c.EventTypes = slices.Clone(w.EventTypes)
+		webhooks = append(webhooks, &c)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+// DeleteWebhook implements WebhookStore.
+func (l *LocalWebhookStore) DeleteWebhook(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(l.webhooks, id)
+	for seq, d := range l.deliveries {
+		if d.WebhookID == id {
+			l.dropDeliveryLocked(seq)
+		}
+	}
+	gone := func(seq int64) bool {
+		_, ok := l.deliveries[seq]
+		return !ok
+	}
+	l.pending = slices.DeleteFunc(l.pending, gone)
+	l.completed = slices.DeleteFunc(l.completed, gone)
+	return nil
+}
+
+// AddDeliveries implements WebhookStore.
+func (l *LocalWebhookStore) AddDeliveries(ctx context.Context, deliveries ...*WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, d := range deliveries {
+		key := deliveryKey{webhookID: d.WebhookID, eventID: d.Event.ID}
+		if _, duplicate := l.events[key]; duplicate {
+			continue
+		}
+
+		l.lastSeq++
+		stored := *d
+		l.deliveries[l.lastSeq] = &stored
+		l.seqs[d.ID] = l.lastSeq
+		l.events[key] = l.lastSeq
+		if d.Status == OutboxPending {
+			l.pending = append(l.pending, l.lastSeq)
+		} else {
+			l.completeLocked(l.lastSeq)
+		}
+	}
+	return nil
+}
+
+// ReadDueDeliveries implements WebhookStore.
+func (l *LocalWebhookStore) ReadDueDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	due := []*WebhookDelivery{}
+	for _, seq := range l.pending {
+		if limit > 0 && len(due) == limit {
+			break
+		}
+		if d := l.deliveries[seq]; !d.NextAttemptAt.After(now) {
+			c := *d
+			due = append(due, &c)
+		}
+	}
+	return due, nil
+}
+
+// ReadDeliveries implements WebhookStore.
+func (l *LocalWebhookStore) ReadDeliveries(ctx context.Context, webhookID string, limit int) ([]*WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	seqs := slices.Sorted(maps.Keys(l.deliveries))
+	deliveries := []*WebhookDelivery{}
+	for i := len(seqs) - 1; i >= 0; i-- {
+		if limit > 0 && len(deliveries) == limit {
+			break
+		}
+		if d := l.deliveries[seqs[i]]; d.WebhookID == webhookID {
+			c := *d
+			deliveries = append(deliveries, &c)
+		}
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery implements WebhookStore.
+func (l *LocalWebhookStore) UpdateDelivery(ctx context.Context, d *WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seq, ok := l.seqs[d.ID]
+	if !ok {
+		// Its webhook was deleted meanwhile.
+		return nil
+	}
+	stored := l.deliveries[seq]
+	was := stored.Status
+	stored.Status = d.Status
+	stored.Attempts = d.Attempts
+	stored.LastStatusCode = d.LastStatusCode
+	stored.LastError = d.LastError
+	stored.NextAttemptAt = d.NextAttemptAt
+	stored.DeliveredAt = d.DeliveredAt
+
+	if was == OutboxPending && stored.Status != OutboxPending {
+		if i, indexed := slices.BinarySearch(l.pending, seq); indexed {
+			l.pending = slices.Delete(l.pending, i, i+1)
+		}
+		l.completeLocked(seq)
+	}
+	return nil
+}
+
+// completeLocked records the delivery seq as delivered or dead, dropping
+// the oldest completed deliveries beyond keep. Callers hold l.mu.
+func (l *LocalWebhookStore) completeLocked(seq int64) {
+	l.completed = append(l.completed, seq)
+	for len(l.completed) > l.keep {
+		l.dropDeliveryLocked(l.completed[0])
+		l.completed = l.completed[1:]
+	}
+}
+
+// dropDeliveryLocked forgets the delivery seq, leaving the pending and
+// completed indexes to the caller. Callers hold l.mu.
+func (l *LocalWebhookStore) dropDeliveryLocked(seq int64) {
+	d := l.deliveries[seq]
+	delete(l.deliveries, seq)
+	delete(l.seqs, d.ID)
+	delete(l.events, deliveryKey{webhookID: d.WebhookID, eventID: d.Event.ID})
+}
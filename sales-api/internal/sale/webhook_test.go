@@ -0,0 +1,192 @@
+package sale
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// webhookStores returns a constructor for every WebhookStore implementation.
+func webhookStores(t *testing.T) map[string]func() WebhookStore {
+	return map[string]func() WebhookStore{
+		"local": func() WebhookStore { return NewLocalWebhookStore() },
+		"sqlite": func() WebhookStore {
+			s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "sales.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+}
+
+func TestWebhookService_CRUD(t *testing.T) {
+	for backend, newStore := range webhookStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewWebhookService(newStore(), WebhookConfig{}, nil)
+
+			err := s.CreateWebhook(ctx, &Webhook{URL: "erp.local/hooks"})
+			require.ErrorIs(t, err, ErrInvalidWebhook)
+			err = s.CreateWebhook(ctx, &Webhook{URL: "https://erp.local/hooks", EventTypes: []string{"sale.deleted"}})
+			require.ErrorIs(t, err, ErrInvalidInput)
+
+			w := &Webhook{URL: "https://erp.local/hooks", EventTypes: []string{EventSaleStatusChanged}, UserID: "1234"}
+			require.NoError(t, s.CreateWebhook(ctx, w))
+			require.NotEmpty(t, w.ID)
+			require.NotEmpty(t, w.Secret)
+
+			got, err := s.GetWebhook(ctx, w.ID)
+			require.NoError(t, err)
+			require.Empty(t, got.Secret)
+			require.Equal(t, w.EventTypes, got.EventTypes)
+			require.Equal(t, "1234", got.UserID)
+
+			url := "https://erp.local/v2/hooks"
+			updated, err := s.UpdateWebhook(ctx, w.ID, &UpdateFieldsWebhook{URL: &url})
+			require.NoError(t, err)
+			require.Equal(t, url, updated.URL)
+			require.Empty(t, updated.Secret)
+			bad := []string{"nope"}
+			_, err = s.UpdateWebhook(ctx, w.ID, &UpdateFieldsWebhook{EventTypes: &bad})
+			require.ErrorIs(t, err, ErrInvalidWebhook)
+
+			list, err := s.ListWebhooks(ctx)
+			require.NoError(t, err)
+			require.Len(t, list, 1)
+			require.Equal(t, url, list[0].URL)
+
+			require.NoError(t, s.DeleteWebhook(ctx, w.ID))
+			_, err = s.GetWebhook(ctx, w.ID)
+			require.ErrorIs(t, err, ErrWebhookNotFound)
+			require.ErrorIs(t, s.DeleteWebhook(ctx, w.ID), ErrWebhookNotFound)
+		})
+	}
+}
+
+func TestWebhookService_DeliversSignedEvents(t *testing.T) {
+	for backend, newStore := range webhookStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+
+			fail := true
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				ts, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+				require.NoError(t, err)
+				want := SignWebhook("s3cret", ts, body)
+				require.True(t, hmac.Equal([]byte(want), []byte(r.Header.Get(WebhookSignatureHeader))))
+				require.Equal(t, EventSaleStatusChanged, r.Header.Get(WebhookEventHeader))
+
+				bodies = append(bodies, string(body))
+				if fail {
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			}))
+			defer server.Close()
+
+			s := NewWebhookService(newStore(), WebhookConfig{RetryWait: time.Minute}, nil)
+			now := time.Now()
+			s.now = func() time.Time { return now }
+
+			erp := &Webhook{URL: server.URL, Secret: "s3cret", EventTypes: []string{EventSaleStatusChanged}}
+			require.NoError(t, s.CreateWebhook(ctx, erp))
+			other := &Webhook{URL: server.URL, UserID: "5678"}
+			require.NoError(t, s.CreateWebhook(ctx, other))
+
+			sale := &Sale{ID: "s1", UserID: "1234", Amount: MustParseMoney("10", "ARS"), Status: StatusApproved, Version: 2}
+			created := newEvent(sale, HistoryEntry{SaleID: "s1", Version: 1, ToStatus: StatusPending})
+			approved := newEvent(sale, HistoryEntry{SaleID: "s1", Version: 2, FromStatus: StatusPending, ToStatus: StatusApproved})
+			require.NoError(t, s.Publish(ctx, created))
+			require.NoError(t, s.Publish(ctx, approved))
+			// The outbox may hand the same event over again.
+			require.NoError(t, s.Publish(ctx, approved))
+
+			result, err := s.DeliverPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Retried: 1}, result)
+
+			deliveries, err := s.Deliveries(ctx, erp.ID, 0)
+			require.NoError(t, err)
+			require.Len(t, deliveries, 1)
+			require.Equal(t, OutboxPending, deliveries[0].Status)
+			require.Equal(t, http.StatusBadGateway, deliveries[0].LastStatusCode)
+			require.True(t, deliveries[0].NextAttemptAt.Equal(now.Add(time.Minute)))
+
+			fail = false
+			now = now.Add(time.Minute)
+			result, err = s.DeliverPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Delivered: 1}, result)
+
+			deliveries, err = s.Deliveries(ctx, erp.ID, 0)
+			require.NoError(t, err)
+			require.Equal(t, OutboxDelivered, deliveries[0].Status)
+			require.Equal(t, 2, deliveries[0].Attempts)
+			require.Equal(t, http.StatusOK, deliveries[0].LastStatusCode)
+			require.Empty(t, deliveries[0].LastError)
+			require.Equal(t, approved.ID, deliveries[0].Event.ID)
+			require.Len(t, bodies, 2)
+			require.Contains(t, bodies[1], `"id":"sale.status_changed:s1:2"`)
+
+			deliveries, err = s.Deliveries(ctx, other.ID, 0)
+			require.NoError(t, err)
+			require.Empty(t, deliveries)
+			_, err = s.Deliveries(ctx, "missing", 0)
+			require.ErrorIs(t, err, ErrWebhookNotFound)
+		})
+	}
+}
+
+func TestLocalWebhookStore_KeepsLatestCompleted(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocalWebhookStore()
+	l.keep = 2
+	require.NoError(t, l.SetWebhook(ctx, &Webhook{ID: "w"}))
+
+	for i := range 4 {
+		d := &WebhookDelivery{ID: fmt.Sprint("d", i+1), WebhookID: "w", Event: Event{ID: fmt.Sprint("event-", i+1)}, Status: OutboxPending}
+		require.NoError(t, l.AddDeliveries(ctx, d))
+	}
+	// Handing an event over again queues nothing new.
+	require.NoError(t, l.AddDeliveries(ctx, &WebhookDelivery{ID: "again", WebhookID: "w", Event: Event{ID: "event-1"}, Status: OutboxPending}))
+
+	now := time.Now()
+	complete := func(id, status string) {
+		require.NoError(t, l.UpdateDelivery(ctx, &WebhookDelivery{ID: id, Status: status}))
+	}
+	complete("d1", OutboxDelivered)
+	complete("d2", OutboxDead)
+	complete("d3", OutboxDelivered)
+
+	due, err := l.ReadDueDeliveries(ctx, now, 0)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.Equal(t, "d4", due[0].ID)
+
+	// Only the two latest completed deliveries are kept.
+	deliveries, err := l.ReadDeliveries(ctx, "w", 0)
+	require.NoError(t, err)
+	var ids []string
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+	}
+	require.Equal(t, []string{"d4", "d3", "d2"}, ids)
+	require.Len(t, l.events, 3)
+	require.Len(t, l.seqs, 3)
+
+	require.NoError(t, l.DeleteWebhook(ctx, "w"))
+	require.Empty(t, l.deliveries)
+	require.Empty(t, l.events)
+	require.Empty(t, l.pending)
+	require.Empty(t, l.completed)
+}
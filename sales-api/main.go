@@ -1,17 +1,171 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
 	"sales-api/api"
+	"sales-api/internal/sale"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	r := gin.Default()
-	api.InitRoutes(r, "http://localhost:8080")
 
-	if err := r.Run(":8081"); err != nil {
-		panic(fmt.Errorf("error trying to start server: %v", err))
+	currency := getEnv("SALES_DEFAULT_CURRENCY", "ARS")
+
+	rules := sale.DefaultRulesConfig()
+	rules.RejectAboveAmount = getMoneyEnv("SALES_REJECT_ABOVE_AMOUNT", currency, rules.RejectAboveAmount)
+	rules.AutoApproveMaxAmount = getMoneyEnv("SALES_AUTO_APPROVE_MAX_AMOUNT", currency, rules.AutoApproveMaxAmount)
+	rules.MaxRejectionRatio = getFloatEnv("SALES_MAX_REJECTION_RATIO", rules.MaxRejectionRatio)
+	rules.MinUserAge = getDurationEnv("SALES_MIN_USER_AGE", rules.MinUserAge)
+
+	client := sale.DefaultUserClientConfig()
+	client.Timeout = getDurationEnv("USERS_API_TIMEOUT", client.Timeout)
+	client.MaxRetries = getIntEnv("USERS_API_MAX_RETRIES", client.MaxRetries)
+	client.BreakerThreshold = getIntEnv("USERS_API_BREAKER_THRESHOLD", client.BreakerThreshold)
+	client.BreakerCooldown = getDurationEnv("USERS_API_BREAKER_COOLDOWN", client.BreakerCooldown)
+
+	cache := sale.DefaultUserCacheConfig()
+	cache.Size = getIntEnv("USERS_CACHE_SIZE", cache.Size)
+	cache.TTL = getDurationEnv("USERS_CACHE_TTL", cache.TTL)
+	cache.NegativeTTL = getDurationEnv("USERS_CACHE_NEGATIVE_TTL", cache.NegativeTTL)
+
+	cfg := api.Config{
+		UserAPIURL: getEnv("USERS_API_URL", "http://localhost:8080"),
+		Storage:    getEnv("SALES_STORAGE", api.StorageMemory),
+		SQLitePath: getEnv("SALES_SQLITE_PATH", "sales.db"),
+		Approval:   rules,
+		UserClient: client,
+		UserCache:  cache,
+
+		DefaultCurrency: currency,
+
+		RequestTimeout: getDurationEnv("SALES_REQUEST_TIMEOUT", 10*time.Second),
+		IdempotencyTTL: getDurationEnv("SALES_IDEMPOTENCY_TTL", sale.DefaultIdempotencyTTL),
+
+		DegradedMode:      getBoolEnv("SALES_DEGRADED_MODE", false),
+		ReconcileInterval: getDurationEnv("SALES_RECONCILE_INTERVAL", sale.DefaultReconcileInterval),
+
+		Outbox: sale.DispatcherConfig{
+			Interval:    getDurationEnv("SALES_OUTBOX_INTERVAL", sale.DefaultDispatcherConfig().Interval),
+			MaxAttempts: getIntEnv("SALES_OUTBOX_MAX_ATTEMPTS", sale.DefaultDispatcherConfig().MaxAttempts),
+		},
+		Webhooks: sale.WebhookConfig{
+			Timeout:     getDurationEnv("SALES_WEBHOOK_TIMEOUT", sale.DefaultWebhookConfig().Timeout),
+			MaxAttempts: getIntEnv("SALES_WEBHOOK_MAX_ATTEMPTS", sale.DefaultWebhookConfig().MaxAttempts),
+		},
+		StreamReplayBuffer: getIntEnv("SALES_STREAM_REPLAY_BUFFER", sale.DefaultReplayBuffer),
+	}
+	// ctx is done on SIGINT or SIGTERM, which stops the background workers.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := api.InitRoutes(ctx, r, cfg); err != nil {
+		panic(fmt.Errorf("error trying to init routes: %v", err))
+	}
+
+	srv := &http.Server{Addr: ":8081", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(fmt.Errorf("error trying to start server: %v", err))
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "error trying to stop server: %v\n", err)
+	}
+}
+
+// shutdownTimeout is how long the requests in flight get to finish once the
+// server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+// getEnv returns the value of the environment variable key, or def if unset.
+func getEnv(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// getDurationEnv parses the environment variable key as a time.Duration,
+// falling back to def if it is unset.
+func getDurationEnv(key string, def time.Duration) time.Duration {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic(fmt.Errorf("invalid duration in %s: %v", key, err))
+	}
+	return d
+}
+
+// getBoolEnv parses the environment variable key as a bool, falling back to
+// def if it is unset.
+func getBoolEnv(key string, def bool) bool {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		panic(fmt.Errorf("invalid boolean in %s: %v", key, err))
+	}
+	return b
+}
+
+// getIntEnv parses the environment variable key as an int, falling back to
+// def if it is unset.
+func getIntEnv(key string, def int) int {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		panic(fmt.Errorf("invalid integer in %s: %v", key, err))
+	}
+	return n
+}
+
+// getFloatEnv parses the environment variable key as a float, falling back
+// to def if it is unset.
+func getFloatEnv(key string, def float64) float64 {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		panic(fmt.Errorf("invalid number in %s: %v", key, err))
+	}
+	return f
+}
+
+// getMoneyEnv parses the environment variable key as an amount in currency,
+// falling back to def if it is unset.
+func getMoneyEnv(key, currency string, def sale.Money) sale.Money {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	m, err := sale.ParseMoney(v, currency)
+	if err != nil {
+		panic(fmt.Errorf("invalid amount in %s: %v", key, err))
 	}
+	return m
 }
@@ -1,12 +1,21 @@
 package tests
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sales-api/api"
 	"sales-api/internal/sale"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -19,14 +28,14 @@ func TestIntegrationCreateAndPatchAndGet(t *testing.T) {
 
 	mockHandler.HandleFunc("/users/1234", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`1234`))
+		w.Write([]byte(`{"id":"1234","status":"active"}`))
 	})
 
 	mockServer := httptest.NewServer(mockHandler)
 	defer mockServer.Close()
 
 	app := gin.Default()
-	api.InitRoutes(app, mockServer.URL)
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{UserAPIURL: mockServer.URL}))
 
 	req, _ := http.NewRequest(http.MethodGet, "/ping", nil)
 	res := fakeRequest(app, req)
@@ -35,6 +44,12 @@ func TestIntegrationCreateAndPatchAndGet(t *testing.T) {
 	require.Equal(t, http.StatusOK, res.Code)
 	require.Contains(t, res.Body.String(), "pong")
 
+	req, _ = http.NewRequest(http.MethodGet, "/health", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"users_api":{"state":"closed","consecutive_failures":0}`)
+
 	//flujo completo de POST → PATCH → GET en el happy path.
 
 	req, _ = http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{
@@ -43,9 +58,10 @@ func TestIntegrationCreateAndPatchAndGet(t *testing.T) {
 	}`))
 
 	res = fakeRequest(app, req)
-	var amount1 float32 = 100.0
+	amount1 := sale.MustParseMoney("100", "ARS")
 	require.NotNil(t, res)
 	require.Equal(t, http.StatusCreated, res.Code)
+	require.Equal(t, `"1"`, res.Header().Get("ETag"))
 
 	var resSale *sale.Sale
 	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &resSale))
@@ -56,26 +72,93 @@ func TestIntegrationCreateAndPatchAndGet(t *testing.T) {
 	require.NotEmpty(t, resSale.ID)
 	require.NotEmpty(t, resSale.CreatedAt)
 	require.NotEmpty(t, resSale.UpdatedAt)
+	require.Contains(t, res.Body.String(), `"amount":"100.00","currency":"ARS"`)
+
+	// A buyer of unknown age always goes to manual review.
+	require.Equal(t, "pending", resSale.Status)
+	require.Equal(t, "new_account", resSale.Approval.Rule)
 
-	statusActual := resSale.Status
 	req, _ = http.NewRequest(http.MethodPatch, "/sales/"+resSale.ID, bytes.NewBufferString(`{
 		"status":"approved"
 	}`))
+	req.Header.Set("X-Actor", "backoffice:ana")
 	res = fakeRequest(app, req)
 
+	require.NotNil(t, res)
+	require.Equal(t, http.StatusOK, res.Code)
 	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &resSale))
+	require.Equal(t, "approved", resSale.Status)
+	require.Equal(t, 2, resSale.Version)
+	require.WithinDuration(t, time.Now(), resSale.UpdatedAt, time.Second)
+	require.Equal(t, `"2"`, res.Header().Get("ETag"))
+
+	// A client still holding version 1 must not overwrite version 2.
+	req, _ = http.NewRequest(http.MethodPatch, "/sales/"+resSale.ID, bytes.NewBufferString(`{
+		"status":"refunded"
+	}`))
+	req.Header.Set("If-Match", `"1"`)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusPreconditionFailed, res.Code)
+
+	req, _ = http.NewRequest(http.MethodPatch, "/sales/"+resSale.ID, bytes.NewBufferString(`{
+		"status":"rejected"
+	}`))
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusConflict, res.Code)
+	require.Contains(t, res.Body.String(), "transaccion invalida")
+
+	// Who approved it, and what it looked like before.
+	req, _ = http.NewRequest(http.MethodGet, "/sales/"+resSale.ID+"/history", nil)
+	res = fakeRequest(app, req)
 
-	if statusActual == "pending" {
-		require.NotNil(t, res)
-		require.Equal(t, "approved", resSale.Status)
-		require.Equal(t, 2, resSale.Version)
-		require.WithinDuration(t, time.Now(), resSale.UpdatedAt, time.Second)
-		require.Equal(t, http.StatusOK, res.Code)
-	} else {
-		require.NotNil(t, res)
-		require.Equal(t, http.StatusConflict, res.Code)
-		require.Contains(t, res.Body.String(), "transaccion invalida")
+	require.Equal(t, http.StatusOK, res.Code)
+	var history struct {
+		History []sale.HistoryEntry `json:"history"`
 	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &history))
+	require.Len(t, history.History, 2)
+	require.Equal(t, "pending", history.History[1].FromStatus)
+	require.Equal(t, "approved", history.History[1].ToStatus)
+	require.Equal(t, "backoffice:ana", history.History[1].Actor)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales/"+resSale.ID+"?version=1", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, `"1"`, res.Header().Get("ETag"))
+	require.Contains(t, res.Body.String(), `"status":"pending"`)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales/"+resSale.ID+"?version=9", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusNotFound, res.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales/statuses", nil)
+
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"name":"on_hold"`)
+
+	req, _ = http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{
+		"user_id": "1234",
+		"amount": "10.005",
+		"currency": "USD"
+	}`))
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{
+		"user_id": "1234",
+		"amount": "0.10",
+		"currency": "USD"
+	}`))
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusCreated, res.Code)
 
 	req, _ = http.NewRequest(http.MethodGet, "/sales?user_id="+resSale.UserID, nil)
 
@@ -83,6 +166,8 @@ func TestIntegrationCreateAndPatchAndGet(t *testing.T) {
 
 	require.NotNil(t, res)
 	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(),
+		`"total_amount":[{"amount":"100.00","currency":"ARS"},{"amount":"0.10","currency":"USD"}]`)
 
 	req, _ = http.NewRequest(http.MethodGet, "/sales?user_id="+resSale.UserID+"&status="+resSale.Status, nil)
 
@@ -90,6 +175,377 @@ func TestIntegrationCreateAndPatchAndGet(t *testing.T) {
 
 	require.NotNil(t, res)
 	require.Equal(t, http.StatusOK, res.Code)
+
+	// Biggest amount first, one per page; totals still cover both sales.
+	req, _ = http.NewRequest(http.MethodGet, "/sales?user_id="+resSale.UserID+"&sort=-amount&limit=1", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	var page struct {
+		Metadata struct {
+			Quantity int `json:"quantity"`
+		} `json:"metadata"`
+		Results    []sale.Sale `json:"results"`
+		NextCursor string      `json:"next_cursor"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &page))
+	require.Equal(t, 2, page.Metadata.Quantity)
+	require.Len(t, page.Results, 1)
+	require.Equal(t, "USD", page.Results[0].Amount.Currency)
+	require.NotEmpty(t, page.NextCursor)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales?user_id="+resSale.UserID+"&limit=1&cursor="+page.NextCursor, nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	page.NextCursor = ""
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &page))
+	require.Len(t, page.Results, 1)
+	require.Equal(t, "ARS", page.Results[0].Amount.Currency)
+	require.Empty(t, page.NextCursor)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales?user_id="+resSale.UserID+"&sort=user_id", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	// Across every user, filtered by status list and amount.
+	req, _ = http.NewRequest(http.MethodGet, "/sales?status=pending,approved&currency=USD&min_amount=0.10", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"quantity":1`)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales?status=lost&created_from=2026-02-01&created_to=2026-01-01&min_amount=x", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	require.JSONEq(t, `{"error":"invalid input","fields":[{"field":"min_amount","message":"invalid amount \"x\""}]}`, res.Body.String())
+
+	query := url.Values{"filter": {`amount < 1 and currency = "USD"`}}
+	req, _ = http.NewRequest(http.MethodGet, "/sales?"+query.Encode(), nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"quantity":1`)
+
+	query = url.Values{"filter": {`amount < 1 and currency = USD`}}
+	req, _ = http.NewRequest(http.MethodGet, "/sales?"+query.Encode(), nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	require.JSONEq(t, `{"error":"invalid input","fields":[
+		{"field":"filter","message":"currency compares with a string, got \"USD\"","position":27}]}`, res.Body.String())
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales?status=lost&created_from=2026-02-01&created_to=2026-01-01", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	require.JSONEq(t, `{"error":"invalid input","fields":[
+		{"field":"status","message":"unknown status \"lost\""},
+		{"field":"created_to","message":"must be after created_from"}]}`, res.Body.String())
+
+	// The buyer was only looked up in users-api for the first sale.
+	req, _ = http.NewRequest(http.MethodGet, "/admin/cache/users", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"hits":1,"negative_hits":0,"misses":1`)
+
+	req, _ = http.NewRequest(http.MethodDelete, "/admin/cache/users/1234", nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusNoContent, res.Code)
+}
+
+func TestIntegrationIdempotentCreate(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1234","status":"active"}`))
+	}))
+	defer mockServer.Close()
+
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{UserAPIURL: mockServer.URL}))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(body))
+		req.Header.Set("Idempotency-Key", "checkout-42")
+		return fakeRequest(app, req)
+	}
+
+	res := post(`{"user_id":"1234","amount":"250"}`)
+	require.Equal(t, http.StatusCreated, res.Code)
+	require.Empty(t, res.Header().Get("Idempotent-Replayed"))
+	var created *sale.Sale
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+
+	// The checkout flow retries: same sale, nothing new stored.
+	res = post(`{"user_id":"1234","amount":"250"}`)
+	require.Equal(t, http.StatusCreated, res.Code)
+	require.Equal(t, "true", res.Header().Get("Idempotent-Replayed"))
+	var replayed *sale.Sale
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &replayed))
+	require.Equal(t, created.ID, replayed.ID)
+
+	res = post(`{"user_id":"1234","amount":"300"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+
+	req, _ := http.NewRequest(http.MethodGet, "/sales?user_id=1234", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"quantity":1`)
+}
+
+func TestIntegrationDegradedMode(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{
+		UserAPIURL:   mockServer.URL,
+		UserClient:   sale.UserClientConfig{MaxRetries: 0, Timeout: time.Second},
+		DegradedMode: true,
+	}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{"user_id":"1234","amount":"10"}`))
+	res := fakeRequest(app, req)
+
+	require.Equal(t, http.StatusAccepted, res.Code)
+	require.Contains(t, res.Body.String(), `"status":"pending_verification"`)
+
+	var created *sale.Sale
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+
+	// Only the reconciler approves a sale whose buyer was never checked.
+	req, _ = http.NewRequest(http.MethodPatch, "/sales/"+created.ID, bytes.NewBufferString(`{"status":"approved"}`))
+	req.Header.Set("X-Actor", "system:reconciler")
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusConflict, res.Code)
+
+	req, _ = http.NewRequest(http.MethodPatch, "/sales/"+created.ID, bytes.NewBufferString(`{"status":"cancelled"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestIntegrationOutbox(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1234"}`))
+	}))
+	defer mockServer.Close()
+
+	events := make(chan sale.Event, 1)
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{
+		UserAPIURL: mockServer.URL,
+		EventPublishers: []sale.Publisher{sale.PublisherFunc(func(_ context.Context, event sale.Event) error {
+			events <- event
+			return nil
+		})},
+		Outbox: sale.DispatcherConfig{Interval: 10 * time.Millisecond},
+	}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{"user_id":"1234","amount":"10"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+	var created *sale.Sale
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+
+	select {
+	case event := <-events:
+		require.Equal(t, sale.EventSaleCreated, event.Type)
+		require.Equal(t, created.ID, event.SaleID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sale.created was not delivered")
+	}
+
+	require.Eventually(t, func() bool {
+		req, _ := http.NewRequest(http.MethodGet, "/admin/outbox/1", nil)
+		res := fakeRequest(app, req)
+		return res.Code == http.StatusOK && strings.Contains(res.Body.String(), `"status":"delivered"`)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	req, _ = http.NewRequest(http.MethodGet, "/admin/outbox?status=dead", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `{"results":[]}`, res.Body.String())
+
+	req, _ = http.NewRequest(http.MethodPost, "/admin/outbox/7/replay", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestIntegrationWebhooks(t *testing.T) {
+	usersServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1234"}`))
+	}))
+	defer usersServer.Close()
+
+	received := make(chan *http.Request, 1)
+	erp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer erp.Close()
+
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{
+		UserAPIURL: usersServer.URL,
+		Outbox:     sale.DispatcherConfig{Interval: 10 * time.Millisecond},
+		Webhooks:   sale.WebhookConfig{Interval: 10 * time.Millisecond},
+	}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(
+		`{"url":"`+erp.URL+`","event_types":["sale.status_changed"],"user_id":"1234"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+	var webhook *sale.Webhook
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &webhook))
+	require.NotEmpty(t, webhook.Secret)
+
+	req, _ = http.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(`{"url":"ftp://erp"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{"user_id":"1234","amount":"10"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+	var created *sale.Sale
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+
+	req, _ = http.NewRequest(http.MethodPatch, "/sales/"+created.ID, bytes.NewBufferString(`{"status":"approved"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	select {
+	case r := <-received:
+		require.Equal(t, sale.EventSaleStatusChanged, r.Header.Get(sale.WebhookEventHeader))
+		require.True(t, strings.HasPrefix(r.Header.Get(sale.WebhookSignatureHeader), "sha256="))
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+
+	require.Eventually(t, func() bool {
+		req, _ := http.NewRequest(http.MethodGet, "/webhooks/"+webhook.ID+"/deliveries", nil)
+		res := fakeRequest(app, req)
+		return res.Code == http.StatusOK && strings.Contains(res.Body.String(), `"status":"delivered"`)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	req, _ = http.NewRequest(http.MethodGet, "/webhooks/"+webhook.ID, nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NotContains(t, res.Body.String(), "secret")
+
+	req, _ = http.NewRequest(http.MethodDelete, "/webhooks/"+webhook.ID, nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusNoContent, res.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, "/webhooks/"+webhook.ID+"/deliveries", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestIntegrationSaleStream(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1234"}`))
+	}))
+	defer mockServer.Close()
+
+	app := gin.Default()
+	ctx, shutdown := context.WithCancel(t.Context())
+	defer shutdown()
+	require.NoError(t, api.InitRoutes(ctx, app, api.Config{
+		UserAPIURL:     mockServer.URL,
+		RequestTimeout: 50 * time.Millisecond,
+	}))
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	open := func(query, lastEventID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/sales/stream"+query, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+		return bufio.NewReader(res.Body), func() {
+			cancel()
+			res.Body.Close()
+		}
+	}
+
+	stream, closeStream := open("?user_id=1234", "")
+	defer closeStream()
+
+	req, _ := http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{"user_id":"1234","amount":"10"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+	var created *sale.Sale
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+
+	id, event, data := readStreamEvent(t, stream)
+	require.Equal(t, "1", id)
+	require.Equal(t, sale.EventSaleCreated, event)
+	require.Contains(t, data, `"sale_id":"`+created.ID+`"`)
+
+	// Outlives the request timeout.
+	time.Sleep(100 * time.Millisecond)
+
+	req, _ = http.NewRequest(http.MethodPatch, "/sales/"+created.ID, bytes.NewBufferString(`{"status":"approved"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	id, event, data = readStreamEvent(t, stream)
+	require.Equal(t, "2", id)
+	require.Equal(t, sale.EventSaleStatusChanged, event)
+	require.Contains(t, data, `"to_status":"approved"`)
+
+	// Reconnecting after the first event replays the second.
+	resumed, closeResumed := open("", "1")
+	defer closeResumed()
+	id, event, _ = readStreamEvent(t, resumed)
+	require.Equal(t, "2", id)
+	require.Equal(t, sale.EventSaleStatusChanged, event)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales/stream?status=unknown", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales/stream", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	// Shutting down ends the open streams.
+	shutdown()
+	_, err := resumed.ReadString('\n')
+	for err == nil {
+		_, err = resumed.ReadString('\n')
+	}
+	require.ErrorIs(t, err, io.EOF)
+}
+
+// readStreamEvent reads the next Server-Sent Event, skipping comments.
+func readStreamEvent(t *testing.T, r *bufio.Reader) (id, event, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return id, event, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
 }
 
 func fakeRequest(e *gin.Engine, r *http.Request) *httptest.ResponseRecorder {
@@ -98,3 +554,160 @@ func fakeRequest(e *gin.Engine, r *http.Request) *httptest.ResponseRecorder {
 
 	return w
 }
+
+func TestIntegrationUserDeletedEvent(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1234"}`))
+	}))
+	defer mockServer.Close()
+
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{UserAPIURL: mockServer.URL}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{"user_id":"1234","amount":"10"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+	var created *sale.Sale
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+	require.Equal(t, sale.StatusPending, created.Status)
+
+	event := `{"id":"user.deleted:1234:2","type":"user.deleted","user_id":"1234","version":2}`
+	req, _ = http.NewRequest(http.MethodPost, "/events/users", bytes.NewBufferString(event))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `{"cancelled":1}`, res.Body.String())
+
+	// users-api may deliver it again.
+	req, _ = http.NewRequest(http.MethodPost, "/events/users", bytes.NewBufferString(event))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `{"cancelled":0}`, res.Body.String())
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales/"+created.ID, nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"status":"cancelled"`)
+	require.Contains(t, res.Body.String(), `"status_reason":"user_deleted"`)
+
+	req, _ = http.NewRequest(http.MethodPost, "/events/users", bytes.NewBufferString(`{"type":"user.deleted"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestIntegrationExpandUser(t *testing.T) {
+	var deleted atomic.Bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/5678" && deleted.Load() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/users/")
+		fmt.Fprintf(w, `{"id":%q,"name":"Ana","nickname":"ana%s","address":"Calle 1","status":"active","version":1}`, id, id)
+	}))
+	defer mockServer.Close()
+
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{UserAPIURL: mockServer.URL}))
+
+	var ids []string
+	for _, userID := range []string{"1234", "5678", "1234"} {
+		req, _ := http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{"user_id":"`+userID+`","amount":"10"}`))
+		res := fakeRequest(app, req)
+		require.Equal(t, http.StatusCreated, res.Code)
+		var created *sale.Sale
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+		ids = append(ids, created.ID)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/sales/"+ids[0]+"?expand=user", nil)
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	var one struct {
+		ID   string     `json:"id"`
+		User *sale.User `json:"user"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &one))
+	require.Equal(t, ids[0], one.ID)
+	require.Equal(t, "ana1234", one.User.NickName)
+
+	// Without expand the sale stays as it was.
+	req, _ = http.NewRequest(http.MethodGet, "/sales/"+ids[0], nil)
+	res = fakeRequest(app, req)
+	require.NotContains(t, res.Body.String(), `"user"`)
+
+	// The buyer of the second sale is deleted from users-api.
+	deleted.Store(true)
+	req, _ = http.NewRequest(http.MethodDelete, "/admin/cache/users/5678", nil)
+	fakeRequest(app, req)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales?expand=user", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	var list struct {
+		Metadata struct {
+			Quantity int `json:"quantity"`
+		} `json:"metadata"`
+		Results []struct {
+			ID   string     `json:"id"`
+			User *sale.User `json:"user"`
+		} `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &list))
+	require.Equal(t, 3, list.Metadata.Quantity)
+	require.Len(t, list.Results, 3)
+	require.Equal(t, "Ana", list.Results[0].User.Name)
+	require.Equal(t, &sale.User{ID: "5678", Status: sale.UserStatusUnknown}, list.Results[1].User)
+	require.Equal(t, "ana1234", list.Results[2].User.NickName)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales?expand=seller", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	req, _ = http.NewRequest(http.MethodGet, "/sales/"+ids[0]+"?expand=seller", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestIntegrationInactiveBuyer(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/1234":
+			fmt.Fprint(w, `{"id":"1234","status":"blocked","status_reason":"fraud","version":2}`)
+		default:
+			fmt.Fprint(w, `{"id":"5678","status":"suspended","suspended_until":"2999-01-01T00:00:00Z","version":2}`)
+		}
+	}))
+	defer mockServer.Close()
+
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{UserAPIURL: mockServer.URL}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{"user_id":"1234","amount":"10"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusForbidden, res.Code)
+	require.Contains(t, res.Body.String(), `"user_status":"blocked"`)
+	require.Contains(t, res.Body.String(), `"status_reason":"fraud"`)
+
+	req, _ = http.NewRequest(http.MethodPost, "/sales", bytes.NewBufferString(`{"user_id":"5678","amount":"10"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusForbidden, res.Code)
+	require.Contains(t, res.Body.String(), `"user_status":"suspended"`)
+	require.Contains(t, res.Body.String(), `"suspended_until":"2999-01-01T00:00:00Z"`)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sales", nil)
+	res = fakeRequest(app, req)
+	require.Contains(t, res.Body.String(), `"quantity":0`)
+}
+
+func TestIntegrationInvalidConfigOpensNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sales.db")
+
+	err := api.InitRoutes(t.Context(), gin.New(), api.Config{
+		Storage:         api.StorageSQLite,
+		SQLitePath:      path,
+		DefaultCurrency: "PESOS",
+	})
+	require.ErrorContains(t, err, "unknown default currency")
+
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
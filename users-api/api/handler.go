@@ -1,8 +1,12 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 	"users-api/internal/user"
 
 	"go.uber.org/zap"
@@ -13,7 +17,14 @@ import (
 // handler holds the user service and implements HTTP handlers for user CRUD.
 type handler struct {
 	userService *user.Service
+	outbox      *user.Dispatcher
 	logger      *zap.Logger
+
+	// purger removes the users deleted longer than the retention window.
+	purger *user.Purger
+
+	// exporter dumps the in-memory storage; nil for persistent backends.
+	exporter interface{ ExportJSON(w io.Writer) error }
 }
 
 // handleCreate handles POST /users
@@ -34,7 +45,10 @@ func (h *handler) handleCreate(ctx *gin.Context) {
 		Address:  req.Address,
 		NickName: req.NickName,
 	}
-	if err := h.userService.CreateUser(u); err != nil {
+	if err := h.userService.CreateUser(ctx.Request.Context(), u); err != nil {
+		if h.handleContextError(ctx, err) || h.handleNickNameTaken(ctx, err) {
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -43,13 +57,59 @@ func (h *handler) handleCreate(ctx *gin.Context) {
 	ctx.JSON(http.StatusCreated, u)
 }
 
+// handleList handles GET /users
+// Filters as described in user.ParseUserFilter and pages with ?limit and
+// ?cursor, the next_cursor of the previous page. A syntax error in ?filter
+// is answered with 400 and its position in the expression.
+func (h *handler) handleList(ctx *gin.Context) {
+	filter, err := user.ParseUserFilter(ctx.Request.URL.Query())
+	if err != nil {
+		var syntax *user.FilterSyntaxError
+		if errors.As(err, &syntax) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "position": syntax.Pos})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page := user.PageRequest{Cursor: ctx.Query("cursor")}
+	if v := ctx.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be an integer"})
+			return
+		}
+		page.Limit = n
+	}
+
+	users, err := h.userService.ListUsers(ctx.Request.Context(), filter, page)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, user.ErrInvalidInput) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("error trying to list users", zap.Error(err))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, users)
+}
+
 // handleRead handles GET /users/:id
 // Si el usuario no existe: 400 bad request.
 func (h *handler) handleRead(ctx *gin.Context) {
 	id := ctx.Param("id")
 
-	u, err := h.userService.GetUser(id)
+	u, err := h.userService.GetUser(ctx.Request.Context(), id)
 	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
 		if errors.Is(err, user.ErrNotFound) {
 			h.logger.Warn("user not found", zap.String("id", id))
 			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -62,6 +122,7 @@ func (h *handler) handleRead(ctx *gin.Context) {
 	}
 
 	h.logger.Info("get user succeed", zap.Any("user", u))
+	setETag(ctx, u.Version)
 	ctx.JSON(http.StatusOK, u)
 }
 
@@ -76,29 +137,83 @@ func (h *handler) handleUpdate(ctx *gin.Context) {
 		return
 	}
 
-	u, err := h.userService.UpdateUser(id, fields)
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, err := h.userService.UpdateUser(ctx.Request.Context(), id, fields, version)
 	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
 		if errors.Is(err, user.ErrNotFound) {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
+		if h.handleVersionConflict(ctx, err, version) || h.handleNickNameTaken(ctx, err) {
+			return
+		}
 
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(ctx, u.Version)
 	ctx.JSON(http.StatusOK, u)
 }
 
+// handleNickNameTaken answers 409 if err says the nickname belongs to
+// someone else, and reports whether it did.
+func (h *handler) handleNickNameTaken(ctx *gin.Context, err error) bool {
+	if !errors.Is(err, user.ErrNickNameTaken) {
+		return false
+	}
+	ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	return true
+}
+
+// handleNickNameAvailability handles GET /users/nicknames/:nick/availability
+// Tells whether the nickname is free and, if not, suggests some that are.
+func (h *handler) handleNickNameAvailability(ctx *gin.Context) {
+	availability, err := h.userService.CheckNickName(ctx.Request.Context(), ctx.Param("nick"))
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, user.ErrInvalidInput) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "a nickname has only letters"})
+			return
+		}
+		h.logger.Error("error trying to check nickname", zap.Error(err))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, availability)
+}
+
 // handleDelete handles DELETE /users/:id
 func (h *handler) handleDelete(ctx *gin.Context) {
 	id := ctx.Param("id")
 
-	if err := h.userService.Delete(id); err != nil {
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.Delete(ctx.Request.Context(), id, version); err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
 		if errors.Is(err, user.ErrNotFound) {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
+		if h.handleVersionConflict(ctx, err, version) {
+			return
+		}
 
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -106,3 +221,247 @@ func (h *handler) handleDelete(ctx *gin.Context) {
 
 	ctx.Status(http.StatusNoContent)
 }
+
+// handleRestore handles POST /users/:id/restore
+// Reactivates a deleted user. Honors If-Match like DELETE does.
+func (h *handler) handleRestore(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, err := h.userService.Restore(ctx.Request.Context(), id, version)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, user.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, user.ErrUserNotDeleted) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if h.handleVersionConflict(ctx, err, version) || h.handleNickNameTaken(ctx, err) {
+			return
+		}
+
+		h.logger.Error("error trying to restore user", zap.Error(err), zap.String("id", id))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(ctx, u.Version)
+	ctx.JSON(http.StatusOK, u)
+}
+
+// statusChange is the body of the admin endpoints that change a user's
+// status.
+type statusChange struct {
+	Reason string `json:"reason"`
+
+	// Until ends a suspension.
+	Until time.Time `json:"until"`
+}
+
+// handleSuspend handles POST /admin/users/:id/suspend
+// Takes {"reason": ..., "until": <RFC 3339 time>}.
+func (h *handler) handleSuspend(ctx *gin.Context) {
+	h.changeStatus(ctx, func(ctx context.Context, id string, req statusChange, version int) (*user.User, error) {
+		return h.userService.Suspend(ctx, id, req.Until, req.Reason, version)
+	})
+}
+
+// handleBlock handles POST /admin/users/:id/block
+// Takes {"reason": ...}.
+func (h *handler) handleBlock(ctx *gin.Context) {
+	h.changeStatus(ctx, func(ctx context.Context, id string, req statusChange, version int) (*user.User, error) {
+		return h.userService.Block(ctx, id, req.Reason, version)
+	})
+}
+
+// handleReactivate handles POST /admin/users/:id/reactivate
+// Takes an optional {"reason": ...}.
+func (h *handler) handleReactivate(ctx *gin.Context) {
+	h.changeStatus(ctx, func(ctx context.Context, id string, req statusChange, version int) (*user.User, error) {
+		return h.userService.Reactivate(ctx, id, req.Reason, version)
+	})
+}
+
+// changeStatus runs change on the user of the request with its statusChange
+// body, honoring If-Match, and answers the user as it was left.
+func (h *handler) changeStatus(ctx *gin.Context, change func(ctx context.Context, id string, req statusChange, version int) (*user.User, error)) {
+	id := ctx.Param("id")
+
+	var req statusChange
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, err := change(ctx.Request.Context(), id, req, version)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, user.ErrInvalidInput):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, user.ErrNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, user.ErrInvalidTransition):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if h.handleVersionConflict(ctx, err, version) {
+			return
+		}
+
+		h.logger.Error("error trying to change user status", zap.Error(err), zap.String("id", id))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("user status changed", zap.String("id", id), zap.String("status", u.Status),
+		zap.String("reason", u.StatusReason))
+	setETag(ctx, u.Version)
+	ctx.JSON(http.StatusOK, u)
+}
+
+// handlePurgeReport handles GET /admin/users/purge
+// Answers the report of the latest purge of deleted users, 404 before the
+// first one.
+func (h *handler) handlePurgeReport(ctx *gin.Context) {
+	report, ok := h.purger.LastReport()
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no purge has run yet"})
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
+
+// handleExport handles GET /admin/users/export
+// Dumps every user, deleted ones included, for cmd/import-users.
+func (h *handler) handleExport(ctx *gin.Context) {
+	ctx.Header("Content-Type", "application/json; charset=utf-8")
+	if err := h.exporter.ExportJSON(ctx.Writer); err != nil {
+		h.logger.Error("error trying to export users", zap.Error(err))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// handleOutbox handles GET /admin/outbox
+// Accepts ?status=pending|delivered|dead and ?limit=N.
+func (h *handler) handleOutbox(ctx *gin.Context) {
+	limit := 0
+	if v := ctx.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative integer"})
+			return
+		}
+		limit = n
+	}
+
+	entries, err := h.outbox.Entries(ctx.Request.Context(), ctx.Query("status"), limit)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, user.ErrInvalidInput) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "unknown outbox status"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"results": entries})
+}
+
+// handleOutboxEntry handles GET /admin/outbox/:seq
+func (h *handler) handleOutboxEntry(ctx *gin.Context) {
+	h.withOutboxEntry(ctx, h.outbox.Entry)
+}
+
+// handleReplayOutboxEntry handles POST /admin/outbox/:seq/replay
+// Queues the entry for delivery again, e.g. a dead one once the receiver is
+// fixed.
+func (h *handler) handleReplayOutboxEntry(ctx *gin.Context) {
+	h.withOutboxEntry(ctx, h.outbox.Replay)
+}
+
+// withOutboxEntry answers with the entry fn returns for the :seq parameter.
+func (h *handler) withOutboxEntry(ctx *gin.Context, fn func(context.Context, int64) (*user.OutboxEntry, error)) {
+	seq, err := strconv.ParseInt(ctx.Param("seq"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "seq must be an integer"})
+		return
+	}
+
+	entry, err := fn(ctx.Request.Context(), seq)
+	if err != nil {
+		if h.handleContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, user.ErrOutboxEntryNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, entry)
+}
+
+// handleVersionConflict answers writes that lost against a concurrent one:
+// 412 when the client sent If-Match, 409 when it did not and retrying did not
+// help. It reports whether err was handled.
+func (h *handler) handleVersionConflict(ctx *gin.Context, err error, version int) bool {
+	if !errors.Is(err, user.ErrVersionConflict) {
+		return false
+	}
+	status := http.StatusConflict
+	if version != 0 {
+		status = http.StatusPreconditionFailed
+	}
+	h.logger.Info("user version conflict", zap.String("id", ctx.Param("id")), zap.Int("if_match", version))
+	ctx.JSON(status, gin.H{"error": err.Error()})
+	return true
+}
+
+// statusClientClosedRequest is the non-standard status logged when the
+// client goes away before we answer.
+const statusClientClosedRequest = 499
+
+// handleContextError answers requests whose context ended before the service
+// finished: 504 on timeout, and no body at all if the client disconnected.
+// It reports whether err was handled.
+func (h *handler) handleContextError(ctx *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, user.ErrTimeout):
+		h.logger.Warn("request timed out", zap.String("path", ctx.FullPath()))
+		ctx.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
+		return true
+	case errors.Is(err, context.Canceled):
+		h.logger.Info("request cancelled by client", zap.String("path", ctx.FullPath()))
+		ctx.AbortWithStatus(statusClientClosedRequest)
+		return true
+	}
+	return false
+}
@@ -1,36 +1,144 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 	"users-api/internal/user"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// Storage backends accepted in Config.Storage.
+const (
+	StorageMemory = "memory"
+	StorageSQLite = "sqlite"
+)
+
+// Config holds the settings needed to wire the users API.
+type Config struct {
+	// Storage selects the user.Storage backend: StorageMemory (default) or StorageSQLite.
+	Storage string
+
+	// SQLitePath is the database file used when Storage is StorageSQLite.
+	SQLitePath string
+
+	// RequestTimeout bounds how long a request may run before it is answered
+	// with 504. Zero means no deadline.
+	RequestTimeout time.Duration
+
+	// EventsWebhookURL receives user events, such as user.deleted, as JSON
+	// POSTs. Empty means events are not sent anywhere.
+	EventsWebhookURL string
+
+	// EventPublishers receive user events besides EventsWebhookURL.
+	EventPublishers []user.Publisher
+
+	// ReleaseDeletedNickNames lets a new user take the nickname of a
+	// deleted one. By default deleted users keep theirs.
+	ReleaseDeletedNickNames bool
+
+	// Purge tunes the removal of deleted users once their retention window
+	// is over. Zero fields mean user.DefaultPurgeConfig.
+	Purge user.PurgeConfig
+
+	// Outbox tunes the delivery of user events. Zero fields mean
+	// user.DefaultDispatcherConfig.
+	Outbox user.DispatcherConfig
+}
+
 // InitRoutes registers all user CRUD endpoints on the given Gin engine.
 // It initializes the storage, service, and handler, then binds each HTTP
-// method and path to the appropriate handler function.
-func InitRoutes(e *gin.Engine) {
+// method and path to the appropriate handler function. The background
+// workers run until ctx is done.
+func InitRoutes(ctx context.Context, e *gin.Engine, cfg Config) error {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	storage := user.NewLocalStorage()
+	storage, err := newStorage(cfg)
+	if err != nil {
+		return err
+	}
 	service := user.NewService(storage, logger)
 
+	publishers := cfg.EventPublishers
+	if cfg.EventsWebhookURL != "" {
+		publishers = append(publishers, user.NewWebhookPublisher(cfg.EventsWebhookURL, 0))
+	}
+	outbox := user.NewDispatcher(storage, cfg.Outbox, logger, publishers...)
+	go outbox.Run(ctx)
+
+	purger := user.NewPurger(storage, cfg.Purge, logger)
+	go purger.Run(ctx)
+
 	h := handler{
 		userService: service,
+		outbox:      outbox,
+		purger:      purger,
 		logger:      logger,
 	}
 
+	if cfg.RequestTimeout > 0 {
+		e.Use(withTimeout(cfg.RequestTimeout))
+	}
+
 	e.POST("/users", h.handleCreate)
+	e.GET("/users", h.handleList)
+	e.GET("/users/nicknames/:nick/availability", h.handleNickNameAvailability)
 	e.GET("/users/:id", h.handleRead)
 	e.PATCH("/users/:id", h.handleUpdate)
 	e.DELETE("/users/:id", h.handleDelete)
+	e.POST("/users/:id/restore", h.handleRestore)
+
+	e.GET("/admin/outbox", h.handleOutbox)
+	e.GET("/admin/outbox/:seq", h.handleOutboxEntry)
+	e.POST("/admin/outbox/:seq/replay", h.handleReplayOutboxEntry)
+	e.GET("/admin/users/purge", h.handlePurgeReport)
+	e.POST("/admin/users/:id/suspend", h.handleSuspend)
+	e.POST("/admin/users/:id/block", h.handleBlock)
+	e.POST("/admin/users/:id/reactivate", h.handleReactivate)
+
+	// The in-memory map can be dumped so it can be migrated with cmd/import-users.
+	if local, ok := storage.(*user.LocalStorage); ok {
+		h.exporter = local
+		e.GET("/admin/users/export", h.handleExport)
+	}
 
 	e.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "pong",
 		})
 	})
+
+	return nil
+}
+
+// newStorage builds the user.Storage selected by cfg.
+func newStorage(cfg Config) (user.Storage, error) {
+	opts := []user.StorageOption{user.WithReleaseDeletedNickNames(cfg.ReleaseDeletedNickNames)}
+	switch cfg.Storage {
+	case "", StorageMemory:
+		return user.NewLocalStorage(opts...), nil
+	case StorageSQLite:
+		if cfg.SQLitePath == "" {
+			return nil, fmt.Errorf("sqlite storage requires a database path")
+		}
+		return user.NewSQLiteStorage(cfg.SQLitePath, opts...)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage)
+	}
+}
+
+// withTimeout attaches a deadline to every request context, so the service
+// and storage stop working once it expires.
+func withTimeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
 }
@@ -0,0 +1,58 @@
+// Command import-users migrates a JSON dump of the in-memory user map (as
+// served by GET /admin/users/export) into the SQLite user storage.
+//
+// Usage:
+//
+//	import-users -dump users.json -db users.db
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"users-api/internal/user"
+)
+
+func main() {
+	dumpPath := flag.String("dump", "", "path to the JSON dump of the in-memory users")
+	dbPath := flag.String("db", "users.db", "path to the SQLite database")
+	flag.Parse()
+
+	if *dumpPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*dumpPath, *dbPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dumpPath, dbPath string) error {
+	f, err := os.Open(dumpPath)
+	if err != nil {
+		return fmt.Errorf("error opening dump: %w", err)
+	}
+	defer f.Close()
+
+	storage, err := user.NewSQLiteStorage(dbPath)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	result, err := user.ImportJSON(context.Background(), f, storage)
+	if err != nil {
+		return fmt.Errorf("imported %d users before failing: %w", result.Imported, err)
+	}
+
+	fmt.Printf("imported %d users into %s\n", result.Imported, dbPath)
+	if len(result.SharedNickNames) > 0 {
+		fmt.Printf("%d users share their nickname with an older user: %s\n",
+			len(result.SharedNickNames), strings.Join(result.SharedNickNames, ", "))
+	}
+	return nil
+}
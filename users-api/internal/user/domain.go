@@ -12,6 +12,21 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Version   int       `json:"version"`
 	Status    string    `json:"status"`
+
+	// StatusReason says why an admin suspended, blocked or reactivated the
+	// user.
+	StatusReason string `json:"status_reason,omitempty"`
+
+	// SuspendedUntil is when a suspension ends by itself.
+	SuspendedUntil time.Time `json:"suspended_until,omitzero"`
+
+	// StatusBeforeDeletion is the status a deleted user had when they were
+	// deleted, which Restore returns them to.
+	StatusBeforeDeletion string `json:"status_before_deletion,omitempty"`
+
+	// DeletedAt is when a deleted user was deleted; their retention window
+	// runs from then.
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
 }
 
 // UpdateFields represents the optional fields for updating a User.
@@ -0,0 +1,85 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ExportJSON writes every user held by the LocalStorage, deleted ones
+// included, as a JSON object keyed by user ID. The output is the dump format
+// read by ImportJSON.
+func (l *LocalStorage) ExportJSON(w io.Writer) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l.m)
+}
+
+// ImportResult tells what ImportJSON brought in.
+type ImportResult struct {
+	Imported int
+
+	// SharedNickNames lists the users imported with a nickname another user
+	// already held, ignoring case. They keep it, but cannot change back to
+	// it once they change it.
+	SharedNickNames []string
+}
+
+// ImportJSON loads a JSON dump of the in-memory map (as written by
+// ExportJSON) into storage, keeping each user's Status, Version and
+// timestamps untouched. Users sharing a nickname, as those written before
+// nicknames were unique may, are imported anyway and reported.
+func ImportJSON(ctx context.Context, r io.Reader, storage Storage) (ImportResult, error) {
+	var result ImportResult
+
+	var dump map[string]*User
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return result, fmt.Errorf("error decoding users dump: %w", err)
+	}
+
+	// Import in a stable order so a failure is easy to resume from, oldest
+	// first so the users reported as sharing a nickname are the later ones.
+	ids := make([]string, 0, len(dump))
+	for id := range dump {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := dump[ids[i]], dump[ids[j]]
+		if a != nil && b != nil && !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		user := dump[id]
+		if user == nil {
+			return result, fmt.Errorf("user %q: %w", id, ErrInvalidInput)
+		}
+		if user.ID == "" {
+			user.ID = id
+		}
+		if user.ID != id {
+			return result, fmt.Errorf("user %q is stored under key %q: %w", user.ID, id, ErrInvalidInput)
+		}
+		if user.Status == UserStatusDeleted && user.DeletedAt.IsZero() {
+			// Dumped before DeletedAt was kept.
+			user.DeletedAt = user.UpdatedAt
+		}
+		shared, err := storage.ImportUser(ctx, user)
+		if err != nil {
+			return result, fmt.Errorf("user %q: %w", id, err)
+		}
+		result.Imported++
+		if shared {
+			result.SharedNickNames = append(result.SharedNickNames, id)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportJSON_KeepsStatusVersionAndTimestamps(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage()
+	s := NewService(local, nil)
+
+	active := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+	require.NoError(t, s.CreateUser(ctx, active))
+	deleted := &User{Name: "Juan", Address: "Tandil", NickName: "Juancho"}
+	require.NoError(t, s.CreateUser(ctx, deleted))
+	require.NoError(t, s.Delete(ctx, deleted.ID, 0))
+	deleted, err := local.ReadUser(ctx, deleted.ID)
+	require.NoError(t, err)
+
+	var dump bytes.Buffer
+	require.NoError(t, local.ExportJSON(&dump))
+
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+
+	result, err := ImportJSON(ctx, &dump, db)
+	require.NoError(t, err)
+	require.Equal(t, ImportResult{Imported: 2}, result)
+	require.NoError(t, db.Close())
+
+	// Reopen to make sure everything survived a restart.
+	db, err = NewSQLiteStorage(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	got, err := db.ReadUser(ctx, deleted.ID)
+	require.NoError(t, err)
+	require.Equal(t, UserStatusDeleted, got.Status)
+	require.Equal(t, 2, got.Version)
+	require.WithinDuration(t, deleted.CreatedAt, got.CreatedAt, time.Microsecond)
+	require.WithinDuration(t, deleted.UpdatedAt, got.UpdatedAt, time.Microsecond)
+
+	_, err = NewService(db, nil).GetUser(ctx, deleted.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	got, err = NewService(db, nil).GetUser(ctx, active.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Chiche", got.NickName)
+	require.Equal(t, UserStatusActive, got.Status)
+}
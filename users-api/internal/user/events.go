@@ -0,0 +1,141 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types published by the Service.
+const (
+	EventUserCreated  = "user.created"
+	EventUserUpdated  = "user.updated"
+	EventUserDeleted  = "user.deleted"
+	EventUserRestored = "user.restored"
+
+	EventUserSuspended   = "user.suspended"
+	EventUserBlocked     = "user.blocked"
+	EventUserReactivated = "user.reactivated"
+)
+
+// Event tells other services that something happened to a user.
+//
+// Delivery is at least once, so consumers must be idempotent. ID is the same
+// for every delivery of one event and can be used to drop duplicates.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	UserID     string    `json:"user_id"`
+
+	// Version is the user version the event describes.
+	Version int `json:"version"`
+
+	// User is the user as it was left by the change.
+	User *User `json:"user,omitempty"`
+}
+
+// newEvent builds the event of the given type for user as it is now.
+func newEvent(eventType string, user *User) Event {
+	snapshot := *user
+	return Event{
+		ID:         eventType + ":" + user.ID + ":" + strconv.Itoa(user.Version),
+		Type:       eventType,
+		OccurredAt: user.UpdatedAt,
+		UserID:     user.ID,
+		Version:    user.Version,
+		User:       &snapshot,
+	}
+}
+
+// Publisher sends events to whoever listens to them. Publishers are the
+// sinks a Dispatcher delivers the outbox to: an error means the event was not
+// taken and will be offered again.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// InProcessPublisher hands events to handlers registered in the same
+// process, in registration order.
+type InProcessPublisher struct {
+	mu       sync.RWMutex
+	handlers []func(ctx context.Context, event Event) error
+}
+
+// NewInProcessPublisher instantiates an InProcessPublisher with no handlers.
+func NewInProcessPublisher() *InProcessPublisher {
+	return &InProcessPublisher{}
+}
+
+// Subscribe registers handler for every published event.
+func (p *InProcessPublisher) Subscribe(handler func(ctx context.Context, event Event) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handlers = append(p.handlers, handler)
+}
+
+// Publish calls every handler, even if one fails, and returns their errors
+// joined.
+func (p *InProcessPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.RLock()
+	handlers := p.handlers
+	p.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DefaultWebhookTimeout bounds each POST of a WebhookPublisher when no
+// timeout is given.
+const DefaultWebhookTimeout = 5 * time.Second
+
+// WebhookPublisher POSTs each event as JSON to a URL. Any answer other than
+// 2xx is an error, left for the Dispatcher to retry.
+type WebhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookPublisher builds a WebhookPublisher for url whose requests give
+// up after timeout, or DefaultWebhookTimeout if zero.
+func NewWebhookPublisher(url string, timeout time.Duration) *WebhookPublisher {
+	if timeout == 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	return &WebhookPublisher{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Publish implements Publisher.
+func (p *WebhookPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
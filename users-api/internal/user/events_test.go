@@ -0,0 +1,35 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPublisher_Publish(t *testing.T) {
+	var received []Event
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		received = append(received, event)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	p := NewWebhookPublisher(server.URL, 0)
+	event := Event{ID: "user.deleted:1:2", Type: EventUserDeleted, UserID: "1", Version: 2}
+
+	// Anything but a 2xx is left for the dispatcher to retry.
+	require.Error(t, p.Publish(ctx, event))
+
+	status = http.StatusNoContent
+	require.NoError(t, p.Publish(ctx, event))
+	require.Len(t, received, 2)
+	require.Equal(t, event, received[1])
+}
@@ -0,0 +1,84 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilterExpr_Errors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{src: `email = "a"`, pos: 1, msg: `unknown field "email"`},
+		{src: `version >= "2"`, pos: 12, msg: `version compares with a number, got "2"`},
+		{src: `nickname in ("a", )`, pos: 19, msg: `nickname compares with a string, got ")"`},
+		{src: `status = "active" and`, pos: 22, msg: "expected a field name, got end of filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := ParseFilterExpr(tt.src)
+			var syntax *FilterSyntaxError
+			require.ErrorAs(t, err, &syntax)
+			require.ErrorIs(t, err, ErrInvalidInput)
+			require.Equal(t, tt.pos, syntax.Pos)
+			require.Equal(t, tt.msg, syntax.Msg)
+		})
+	}
+}
+
+func TestService_ListUsers_FilterExpr(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC) }
+	seed := []*User{
+		{ID: "a", Name: "Ana", NickName: "anita", Status: UserStatusActive, CreatedAt: day(1), UpdatedAt: day(1), Version: 1},
+		{ID: "b", Name: "Beto", NickName: "beto", Status: UserStatusActive, CreatedAt: day(2), UpdatedAt: day(3), Version: 2},
+		{ID: "c", Name: "Caro", NickName: "caro", Status: UserStatusDeleted, CreatedAt: day(3), UpdatedAt: day(4), Version: 3},
+		{ID: "d", Name: "Dani", NickName: "dani", Status: UserStatusActive, CreatedAt: day(4), UpdatedAt: day(4), Version: 1},
+	}
+
+	tests := []struct {
+		filter string
+		all    bool
+		want   []string
+	}{
+		{filter: ``, want: []string{"a", "b", "d"}},
+		{filter: ``, all: true, want: []string{"a", "b", "c", "d"}},
+		{filter: `nickname >= "b" and created_at < "2026-01-04"`, all: true, want: []string{"b", "c"}},
+		{filter: `status in ("deleted")`, want: []string{}},
+		{filter: `status in ("deleted")`, all: true, want: []string{"c"}},
+		{filter: `not (name = "Ana" or version > 1)`, want: []string{"d"}},
+		{filter: `updated_at >= "2026-01-03T12:00:00Z" and version != 3`, all: true, want: []string{"b", "d"}},
+	}
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			for _, u := range seed {
+				require.NoError(t, storage.SetUser(ctx, u))
+			}
+			s := NewService(storage, nil)
+
+			for _, tt := range tests {
+				filter := UserFilter{IncludeDeleted: tt.all}
+				if tt.filter != "" {
+					expr, err := ParseFilterExpr(tt.filter)
+					require.NoError(t, err, tt.filter)
+					filter.Where = expr
+				}
+
+				list, err := s.ListUsers(ctx, filter, PageRequest{})
+				require.NoError(t, err, tt.filter)
+				got := []string{}
+				for _, u := range list.Results {
+					got = append(got, u.ID)
+				}
+				require.Equal(t, tt.want, got, tt.filter)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// UserFilter selects users. Zero fields match everything but deleted users.
+//
+// Time ranges include From and exclude To.
+// NickNamePrefix and Search ignore case and accents.
+type UserFilter struct {
+	// IncludeDeleted also matches users whose status is deleted.
+	IncludeDeleted bool
+
+	// Statuses matches a user in any of them, by their currentStatus.
+	// Deleted users are matched only if listed, whatever IncludeDeleted
+	// says.
+	Statuses []string
+
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	UpdatedFrom time.Time
+	UpdatedTo   time.Time
+
+	// DeletedTo matches the users deleted before it.
+	DeletedTo time.Time
+
+	NickNamePrefix string
+
+	// Search matches users whose name or nickname contains it.
+	Search string
+
+	// Where is a filter expression the users must match.
+	Where *FilterExpr
+}
+
+// ParseUserFilter reads a UserFilter from the query parameters status
+// (comma separated), created_from, created_to, updated_from, updated_to,
+// nickname_prefix, q, the search, and filter, a FilterExpr. Times are RFC
+// 3339 or plain dates; a plain date as an upper bound includes that whole
+// day.
+//
+// A malformed filter expression is returned as a *FilterSyntaxError, any
+// other invalid parameter as an error wrapping ErrInvalidInput.
+func ParseUserFilter(query url.Values) (UserFilter, error) {
+	var f UserFilter
+
+	if v := query.Get("status"); v != "" {
+		for _, st := range strings.Split(v, ",") {
+			if st = strings.TrimSpace(st); st == "" {
+				continue
+			}
+			if !isUserStatus(st) {
+				return f, fmt.Errorf("%w: unknown status %q", ErrInvalidInput, st)
+			}
+			f.Statuses = append(f.Statuses, st)
+		}
+	}
+
+	var errs []error
+	parseTime := func(field string, upper bool) time.Time {
+		v := query.Get(field)
+		if v == "" {
+			return time.Time{}
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s must be an RFC 3339 time or a YYYY-MM-DD date", ErrInvalidInput, field))
+			return time.Time{}
+		}
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t
+	}
+	f.CreatedFrom = parseTime("created_from", false)
+	f.CreatedTo = parseTime("created_to", true)
+	f.UpdatedFrom = parseTime("updated_from", false)
+	f.UpdatedTo = parseTime("updated_to", true)
+	if len(errs) > 0 {
+		return f, errors.Join(errs...)
+	}
+	if !f.CreatedTo.IsZero() && !f.CreatedFrom.Before(f.CreatedTo) {
+		return f, fmt.Errorf("%w: created_to must be after created_from", ErrInvalidInput)
+	}
+	if !f.UpdatedTo.IsZero() && !f.UpdatedFrom.Before(f.UpdatedTo) {
+		return f, fmt.Errorf("%w: updated_to must be after updated_from", ErrInvalidInput)
+	}
+
+	f.NickNamePrefix = query.Get("nickname_prefix")
+	f.Search = strings.TrimSpace(query.Get("q"))
+
+	if v := query.Get("filter"); v != "" {
+		expr, err := ParseFilterExpr(v)
+		if err != nil {
+			return f, err
+		}
+		f.Where = expr
+	}
+	return f, nil
+}
+
+// matches reports whether user passes the filter.
+func (f UserFilter) matches(user *User) bool {
+	switch {
+	case len(f.Statuses) > 0 && !slices.Contains(f.Statuses, currentStatus(user)):
+		return false
+	case len(f.Statuses) == 0 && !f.IncludeDeleted && user.Status == UserStatusDeleted:
+		return false
+	case !inRange(user.CreatedAt, f.CreatedFrom, f.CreatedTo):
+		return false
+	case !inRange(user.UpdatedAt, f.UpdatedFrom, f.UpdatedTo):
+		return false
+	case !f.DeletedTo.IsZero() && (user.DeletedAt.IsZero() || !user.DeletedAt.Before(f.DeletedTo)):
+		return false
+	case f.NickNamePrefix != "" && !strings.HasPrefix(fold(user.NickName), fold(f.NickNamePrefix)):
+		return false
+	case f.Search != "" && !strings.Contains(fold(user.Name), fold(f.Search)) &&
+		!strings.Contains(fold(user.NickName), fold(f.Search)):
+		return false
+	case f.Where != nil && !f.Where.Match(user):
+		return false
+	}
+	return true
+}
+
+// inRange reports whether t is in [from, to), a zero bound being open.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// isUserStatus reports whether status is one a user can be in.
+func isUserStatus(status string) bool {
+	switch status {
+	case UserStatusActive, UserStatusSuspended, UserStatusBlocked, UserStatusDeleted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUserFilter(t *testing.T) {
+	f, err := ParseUserFilter(url.Values{
+		"status":          {"active, deleted"},
+		"created_from":    {"2026-01-02"},
+		"created_to":      {"2026-01-03"},
+		"nickname_prefix": {"Jo"},
+		"q":               {" josé "},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{UserStatusActive, UserStatusDeleted}, f.Statuses)
+	require.Equal(t, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), f.CreatedFrom)
+	require.Equal(t, time.Date(2026, 1, 4, 0, 0, 0, 0, time.UTC), f.CreatedTo)
+	require.Equal(t, "Jo", f.NickNamePrefix)
+	require.Equal(t, "josé", f.Search)
+
+	for _, query := range []url.Values{
+		{"status": {"banned"}},
+		{"created_from": {"yesterday"}},
+		{"created_from": {"2026-01-05"}, "created_to": {"2026-01-04"}},
+		{"filter": {"name ="}},
+	} {
+		_, err := ParseUserFilter(query)
+		require.ErrorIs(t, err, ErrInvalidInput, query.Encode())
+	}
+}
+
+func TestService_ListUsers_Search(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC) }
+	seed := []*User{
+		{ID: "a", Name: "José Pérez", NickName: "Pepe", Status: UserStatusActive, CreatedAt: day(1)},
+		{ID: "b", Name: "Ana", NickName: "JOSECITO", Status: UserStatusActive, CreatedAt: day(2)},
+		{ID: "c", Name: "Joaquín", NickName: "joaco", Status: UserStatusDeleted, CreatedAt: day(3)},
+		{ID: "d", Name: "Müller", NickName: "Ñandú", Status: UserStatusActive, CreatedAt: day(4)},
+		{ID: "e", Name: "Jorge", NickName: "jorgito", Status: UserStatusActive, CreatedAt: day(4)},
+	}
+
+	tests := []struct {
+		name   string
+		filter UserFilter
+		want   []string
+	}{
+		{name: "all", want: []string{"a", "b", "d", "e"}},
+		{name: "search", filter: UserFilter{Search: "jose"}, want: []string{"a", "b"}},
+		{name: "search accents", filter: UserFilter{Search: "ÑANDU"}, want: []string{"d"}},
+		{name: "search in name", filter: UserFilter{Search: "perez"}, want: []string{"a"}},
+		{name: "search muller", filter: UserFilter{Search: "mül"}, want: []string{"d"}},
+		{name: "prefix", filter: UserFilter{NickNamePrefix: "JO"}, want: []string{"b", "e"}},
+		{name: "prefix not infix", filter: UserFilter{NickNamePrefix: "cito"}, want: []string{}},
+		{name: "status", filter: UserFilter{Statuses: []string{UserStatusDeleted}}, want: []string{"c"}},
+		{name: "prefix and deleted", filter: UserFilter{NickNamePrefix: "jo", IncludeDeleted: true}, want: []string{"b", "c", "e"}},
+		{name: "created range", filter: UserFilter{CreatedFrom: day(2), CreatedTo: day(4)}, want: []string{"b"}},
+	}
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			for _, u := range seed {
+				require.NoError(t, storage.SetUser(ctx, u))
+			}
+			s := NewService(storage, nil)
+
+			for _, tt := range tests {
+				list, err := s.ListUsers(ctx, tt.filter, PageRequest{})
+				require.NoError(t, err, tt.name)
+				got := []string{}
+				for _, u := range list.Results {
+					got = append(got, u.ID)
+				}
+				require.Equal(t, tt.want, got, tt.name)
+				require.Empty(t, list.NextCursor, tt.name)
+			}
+		})
+	}
+}
+
+func TestService_ListUsers_Pages(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			var want []string
+			for i := range 7 {
+				// Pairs of users share created_at, so ties are broken by ID.
+				u := &User{ID: string(rune('a' + i)), Status: UserStatusActive, CreatedAt: created.Add(time.Duration(i/2) * time.Hour)}
+				require.NoError(t, storage.SetUser(ctx, u))
+				want = append(want, u.ID)
+			}
+			s := NewService(storage, nil)
+
+			var (
+				got    []string
+				cursor string
+				pages  int
+			)
+			for {
+				list, err := s.ListUsers(ctx, UserFilter{}, PageRequest{Limit: 3, Cursor: cursor})
+				require.NoError(t, err)
+				pages++
+				for _, u := range list.Results {
+					got = append(got, u.ID)
+				}
+				if list.NextCursor == "" {
+					break
+				}
+				cursor = list.NextCursor
+			}
+			require.Equal(t, want, got)
+			require.Equal(t, 3, pages)
+
+			_, err := s.ListUsers(ctx, UserFilter{}, PageRequest{Limit: MaxPageSize + 1})
+			require.ErrorIs(t, err, ErrInvalidInput)
+			_, err = s.ListUsers(ctx, UserFilter{}, PageRequest{Cursor: "not a cursor"})
+			require.ErrorIs(t, err, ErrInvalidInput)
+		})
+	}
+}
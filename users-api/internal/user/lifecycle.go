@@ -0,0 +1,88 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Suspend suspends the user until until, for reason. Suspending a suspended
+// user replaces their expiry.
+// If expectedVersion is not zero, only that version of the user is changed.
+// Returns ErrInvalidInput without a reason or with an until not in the
+// future, and ErrInvalidTransition unless the user is active or suspended.
+func (s *Service) Suspend(ctx context.Context, id string, until time.Time, reason string, expectedVersion int) (*User, error) {
+	if reason == "" || !until.After(time.Now()) {
+		return nil, fmt.Errorf("%w: a suspension needs a reason and an end in the future", ErrInvalidInput)
+	}
+	return s.modify(ctx, id, expectedVersion, EventUserSuspended, func(user *User) error {
+		if user.Status != UserStatusActive && user.Status != UserStatusSuspended {
+			return fmt.Errorf("%w: cannot suspend a %s user", ErrInvalidTransition, user.Status)
+		}
+		setStatus(user, UserStatusSuspended, reason, until)
+		return nil
+	})
+}
+
+// Block blocks the user, for reason, until Reactivate is called.
+// If expectedVersion is not zero, only that version of the user is changed.
+// Returns ErrInvalidInput without a reason, and ErrInvalidTransition unless
+// the user is active or suspended.
+func (s *Service) Block(ctx context.Context, id string, reason string, expectedVersion int) (*User, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("%w: blocking needs a reason", ErrInvalidInput)
+	}
+	return s.modify(ctx, id, expectedVersion, EventUserBlocked, func(user *User) error {
+		if user.Status != UserStatusActive && user.Status != UserStatusSuspended {
+			return fmt.Errorf("%w: cannot block a %s user", ErrInvalidTransition, user.Status)
+		}
+		setStatus(user, UserStatusBlocked, reason, time.Time{})
+		return nil
+	})
+}
+
+// Reactivate lifts a suspension or a block, for reason, which may be empty.
+// If expectedVersion is not zero, only that version of the user is changed.
+// Returns ErrInvalidTransition unless the user is suspended or blocked.
+func (s *Service) Reactivate(ctx context.Context, id string, reason string, expectedVersion int) (*User, error) {
+	return s.modify(ctx, id, expectedVersion, EventUserReactivated, func(user *User) error {
+		if user.Status != UserStatusSuspended && user.Status != UserStatusBlocked {
+			return fmt.Errorf("%w: cannot reactivate a %s user", ErrInvalidTransition, user.Status)
+		}
+		setStatus(user, UserStatusActive, reason, time.Time{})
+		return nil
+	})
+}
+
+// setStatus moves user to status, recording why and, for a suspension, until
+// when.
+func setStatus(user *User, status, reason string, until time.Time) {
+	user.Status = status
+	user.StatusReason = reason
+	user.SuspendedUntil = until
+}
+
+// liftExpiredSuspension makes user active if their suspension is over at
+// now, and reports whether it did. Suspensions are not lifted in the
+// storage when they end but the next time the user is changed, so filters
+// on status go by currentStatus instead.
+func liftExpiredSuspension(user *User, now time.Time) bool {
+	if !suspensionOver(user, now) {
+		return false
+	}
+	setStatus(user, UserStatusActive, "", time.Time{})
+	return true
+}
+
+// currentStatus is the status user is in now, active once their suspension
+// is over even if that is not stored yet.
+func currentStatus(user *User) string {
+	if suspensionOver(user, time.Now()) {
+		return UserStatusActive
+	}
+	return user.Status
+}
+
+func suspensionOver(user *User, now time.Time) bool {
+	return user.Status == UserStatusSuspended && !now.Before(user.SuspendedUntil)
+}
@@ -0,0 +1,181 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Lifecycle(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil)
+
+			u := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+			require.NoError(t, s.CreateUser(ctx, u))
+
+			until := time.Now().Add(time.Hour).Truncate(time.Second)
+			_, err := s.Suspend(ctx, u.ID, until, "", 0)
+			require.ErrorIs(t, err, ErrInvalidInput)
+			_, err = s.Suspend(ctx, u.ID, time.Now().Add(-time.Hour), "chargeback", 0)
+			require.ErrorIs(t, err, ErrInvalidInput)
+
+			suspended, err := s.Suspend(ctx, u.ID, until, "chargeback", 1)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusSuspended, suspended.Status)
+			require.Equal(t, 2, suspended.Version)
+
+			got, err := s.GetUser(ctx, u.ID)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusSuspended, got.Status)
+			require.Equal(t, "chargeback", got.StatusReason)
+			require.True(t, until.Equal(got.SuspendedUntil))
+
+			blocked, err := s.Block(ctx, u.ID, "stolen card", 0)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusBlocked, blocked.Status)
+			require.True(t, blocked.SuspendedUntil.IsZero())
+
+			_, err = s.Suspend(ctx, u.ID, until, "chargeback", 0)
+			require.ErrorIs(t, err, ErrInvalidTransition)
+			_, err = s.Block(ctx, u.ID, "again", 0)
+			require.ErrorIs(t, err, ErrInvalidTransition)
+
+			active, err := s.Reactivate(ctx, u.ID, "cleared by support", 0)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusActive, active.Status)
+			require.Equal(t, "cleared by support", active.StatusReason)
+			_, err = s.Reactivate(ctx, u.ID, "", 0)
+			require.ErrorIs(t, err, ErrInvalidTransition)
+
+			list, err := s.ListUsers(ctx, UserFilter{Statuses: []string{UserStatusActive}}, PageRequest{})
+			require.NoError(t, err)
+			require.Len(t, list.Results, 1)
+
+			entries, err := storage.ReadOutbox(ctx, OutboxFilter{})
+			require.NoError(t, err)
+			var types []string
+			for _, entry := range entries {
+				types = append(types, entry.Event.Type)
+			}
+			require.Equal(t, []string{EventUserCreated, EventUserSuspended, EventUserBlocked, EventUserReactivated}, types)
+
+			require.NoError(t, s.Delete(ctx, u.ID, 0))
+			_, err = s.Block(ctx, u.ID, "stolen card", 0)
+			require.ErrorIs(t, err, ErrInvalidTransition)
+		})
+	}
+}
+
+func TestService_SuspensionExpires(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil)
+
+			require.NoError(t, storage.SetUser(ctx, &User{
+				ID: "a", NickName: "a", Status: UserStatusSuspended, StatusReason: "chargeback",
+				SuspendedUntil: time.Now().Add(-time.Minute), Version: 2,
+			}))
+			require.NoError(t, storage.SetUser(ctx, &User{
+				ID: "b", NickName: "b", Status: UserStatusSuspended, StatusReason: "chargeback",
+				SuspendedUntil: time.Now().Add(time.Hour), Version: 2,
+			}))
+
+			got, err := s.GetUser(ctx, "a")
+			require.NoError(t, err)
+			require.Equal(t, UserStatusActive, got.Status)
+			require.Empty(t, got.StatusReason)
+			require.True(t, got.SuspendedUntil.IsZero())
+
+			// Listings see the suspension lifted before it is stored.
+			where, err := ParseFilterExpr(`status = "active"`)
+			require.NoError(t, err)
+			for _, tt := range []struct {
+				filter UserFilter
+				want   int
+			}{
+				{filter: UserFilter{Statuses: []string{UserStatusActive}}, want: 1},
+				{filter: UserFilter{Statuses: []string{UserStatusSuspended}}, want: 1},
+				{filter: UserFilter{Statuses: []string{UserStatusActive, UserStatusBlocked}}, want: 1},
+				{filter: UserFilter{Where: where}, want: 1},
+			} {
+				list, err := s.ListUsers(ctx, tt.filter, PageRequest{})
+				require.NoError(t, err)
+				require.Len(t, list.Results, tt.want, "%+v", tt.filter)
+			}
+
+			// The next change stores the lifted suspension.
+			name := "Ana"
+			_, err = s.UpdateUser(ctx, "a", &UpdateFieldsUser{Name: &name}, 0)
+			require.NoError(t, err)
+			stored, err := storage.ReadUser(ctx, "a")
+			require.NoError(t, err)
+			require.Equal(t, UserStatusActive, stored.Status)
+
+			_, err = s.Reactivate(ctx, "a", "", 0)
+			require.ErrorIs(t, err, ErrInvalidTransition)
+		})
+	}
+}
+
+func TestService_RestoreKeepsSanction(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil)
+
+			until := time.Now().Add(time.Hour).Truncate(time.Second)
+			require.NoError(t, storage.SetUser(ctx, &User{ID: "blocked", NickName: "a", Status: UserStatusBlocked, StatusReason: "stolen card", Version: 1}))
+			require.NoError(t, storage.SetUser(ctx, &User{
+				ID: "suspended", NickName: "b", Status: UserStatusSuspended, StatusReason: "chargeback",
+				SuspendedUntil: until, Version: 1,
+			}))
+			require.NoError(t, storage.SetUser(ctx, &User{
+				ID: "expired", NickName: "c", Status: UserStatusSuspended, StatusReason: "chargeback",
+				SuspendedUntil: time.Now().Add(time.Hour), Version: 1,
+			}))
+			// Deleted before the status was kept.
+			require.NoError(t, storage.SetUser(ctx, &User{ID: "legacy", NickName: "d", Status: UserStatusDeleted, StatusReason: "spam", Version: 2}))
+
+			for _, id := range []string{"blocked", "suspended", "expired"} {
+				require.NoError(t, s.Delete(ctx, id, 0))
+			}
+
+			blocked, err := s.Restore(ctx, "blocked", 0)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusBlocked, blocked.Status)
+			require.Equal(t, "stolen card", blocked.StatusReason)
+			require.Empty(t, blocked.StatusBeforeDeletion)
+
+			suspended, err := s.Restore(ctx, "suspended", 0)
+			require.NoError(t, err)
+			got, err := s.GetUser(ctx, suspended.ID)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusSuspended, got.Status)
+			require.Equal(t, "chargeback", got.StatusReason)
+			require.True(t, until.Equal(got.SuspendedUntil))
+
+			// The suspension ends while the user is deleted.
+			deleted, err := storage.ReadUser(ctx, "expired")
+			require.NoError(t, err)
+			deleted.SuspendedUntil = time.Now().Add(-time.Minute)
+			require.NoError(t, storage.SetUser(ctx, deleted))
+			expired, err := s.Restore(ctx, "expired", 0)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusActive, expired.Status)
+			require.Empty(t, expired.StatusReason)
+
+			legacy, err := s.Restore(ctx, "legacy", 0)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusActive, legacy.Status)
+			require.Empty(t, legacy.StatusReason)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package user
+
+import (
+	"context"
+	"slices"
+)
+
+// nickNameSuggestions is how many alternatives CheckNickName offers for a
+// taken nickname.
+const nickNameSuggestions = 3
+
+// maxNickNameCandidates bounds how many alternatives CheckNickName tries.
+const maxNickNameCandidates = 30
+
+// nickNameAffixes give the first alternatives tried for a taken nickname.
+var nickNameAffixes = []struct{ prefix, suffix string }{
+	{suffix: "Real"},
+	{prefix: "The"},
+	{suffix: "Oficial"},
+}
+
+// NickNameAvailability tells whether a nickname is free to take.
+type NickNameAvailability struct {
+	NickName  string `json:"nickname"`
+	Available bool   `json:"available"`
+
+	// Suggestions are free nicknames like NickName, when it is taken.
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// CheckNickName reports whether nick is free, ignoring case, and suggests
+// free alternatives if it is not. Returns ErrInvalidInput if nick is not a
+// valid nickname.
+func (s *Service) CheckNickName(ctx context.Context, nick string) (NickNameAvailability, error) {
+	if !letterRegex.MatchString(nick) {
+		return NickNameAvailability{}, ErrInvalidInput
+	}
+
+	taken, err := s.storage.NickNameTaken(ctx, nick)
+	if err != nil {
+		return NickNameAvailability{}, contextError(err)
+	}
+	availability := NickNameAvailability{NickName: nick, Available: !taken}
+	if !taken {
+		return availability, nil
+	}
+
+	for _, candidate := range nickNameCandidates(nick) {
+		taken, err := s.storage.NickNameTaken(ctx, candidate)
+		if err != nil {
+			return NickNameAvailability{}, contextError(err)
+		}
+		if taken {
+			continue
+		}
+		availability.Suggestions = append(availability.Suggestions, candidate)
+		if len(availability.Suggestions) == nickNameSuggestions {
+			break
+		}
+	}
+	return availability, nil
+}
+
+// nickNameCandidates returns alternatives to nick, all valid nicknames:
+// nick with each of nickNameAffixes, then nick followed by a, b, ..., z,
+// aa, ab and so on.
+func nickNameCandidates(nick string) []string {
+	candidates := make([]string, 0, maxNickNameCandidates)
+	for _, affix := range nickNameAffixes {
+		candidates = append(candidates, affix.prefix+nick+affix.suffix)
+	}
+	for n := 0; len(candidates) < maxNickNameCandidates; n++ {
+		candidates = append(candidates, nick+letterSuffix(n))
+	}
+	return candidates
+}
+
+// letterSuffix returns the n-th of a, b, ..., z, aa, ab, ...
+func letterSuffix(n int) string {
+	var s []byte
+	for ; n >= 0; n = n/26 - 1 {
+		s = append(s, byte('a'+n%26))
+	}
+	slices.Reverse(s)
+	return string(s)
+}
@@ -0,0 +1,219 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_UniqueNickName(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewService(newStorage(), nil)
+
+			chiche := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+			require.NoError(t, s.CreateUser(ctx, chiche))
+			juan := &User{Name: "Juan", Address: "Balcarce", NickName: "Chueco"}
+			require.NoError(t, s.CreateUser(ctx, juan))
+
+			err := s.CreateUser(ctx, &User{Name: "Otro", Address: "Lobos", NickName: "CHICHE"})
+			require.ErrorIs(t, err, ErrNickNameTaken)
+
+			taken := "chiche"
+			_, err = s.UpdateUser(ctx, juan.ID, &UpdateFieldsUser{NickName: &taken}, 0)
+			require.ErrorIs(t, err, ErrNickNameTaken)
+
+			// Changing the case of one's own nickname is fine.
+			own := "CHICHE"
+			updated, err := s.UpdateUser(ctx, chiche.ID, &UpdateFieldsUser{NickName: &own}, 0)
+			require.NoError(t, err)
+			require.Equal(t, "CHICHE", updated.NickName)
+
+			// A deleted user keeps their nickname.
+			require.NoError(t, s.Delete(ctx, chiche.ID, 0))
+			err = s.CreateUser(ctx, &User{Name: "Otro", Address: "Lobos", NickName: "Chiche"})
+			require.ErrorIs(t, err, ErrNickNameTaken)
+		})
+	}
+}
+
+func TestService_UniqueNickName_ReleaseDeleted(t *testing.T) {
+	for backend, newStorage := range storageBackends(t, WithReleaseDeletedNickNames(true)) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewService(newStorage(), nil)
+
+			chiche := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+			require.NoError(t, s.CreateUser(ctx, chiche))
+			require.NoError(t, s.Delete(ctx, chiche.ID, 0))
+
+			availability, err := s.CheckNickName(ctx, "chiche")
+			require.NoError(t, err)
+			require.True(t, availability.Available)
+
+			require.NoError(t, s.CreateUser(ctx, &User{Name: "Otro", Address: "Lobos", NickName: "chiche"}))
+		})
+	}
+}
+
+func TestService_CheckNickName(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewService(newStorage(), nil)
+
+			for _, nick := range []string{"Chueco", "ChuecoReal", "chuecoa"} {
+				require.NoError(t, s.CreateUser(ctx, &User{Name: "Juan", Address: "Balcarce", NickName: nick}))
+			}
+
+			availability, err := s.CheckNickName(ctx, "Fangio")
+			require.NoError(t, err)
+			require.Equal(t, NickNameAvailability{NickName: "Fangio", Available: true}, availability)
+
+			availability, err = s.CheckNickName(ctx, "chueco")
+			require.NoError(t, err)
+			require.False(t, availability.Available)
+			require.Equal(t, []string{"Thechueco", "chuecoOficial", "chuecob"}, availability.Suggestions)
+
+			_, err = s.CheckNickName(ctx, "chueco5")
+			require.ErrorIs(t, err, ErrInvalidInput)
+		})
+	}
+}
+
+func TestLetterSuffix(t *testing.T) {
+	for n, want := range map[int]string{0: "a", 25: "z", 26: "aa", 27: "ab", 701: "zz", 702: "aaa"} {
+		require.Equal(t, want, letterSuffix(n), n)
+	}
+}
+
+func TestStorage_SharedNickNames(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil)
+
+			shared, err := storage.ImportUser(ctx, &User{ID: "a", NickName: "Chiche", Status: UserStatusActive, Version: 1})
+			require.NoError(t, err)
+			require.False(t, shared)
+			shared, err = storage.ImportUser(ctx, &User{ID: "b", NickName: "chiche", Status: UserStatusActive, Version: 1})
+			require.NoError(t, err)
+			require.True(t, shared)
+
+			// Writes leaving the nickname alone still go through.
+			name := "Juan"
+			_, err = s.UpdateUser(ctx, "b", &UpdateFieldsUser{Name: &name}, 0)
+			require.NoError(t, err)
+			_, err = s.Block(ctx, "b", "fraud", 0)
+			require.NoError(t, err)
+
+			// But the nickname cannot be taken up again once given up.
+			other, back := "Juancho", "CHICHE"
+			_, err = s.UpdateUser(ctx, "b", &UpdateFieldsUser{NickName: &other}, 0)
+			require.NoError(t, err)
+			_, err = s.UpdateUser(ctx, "b", &UpdateFieldsUser{NickName: &back}, 0)
+			require.ErrorIs(t, err, ErrNickNameTaken)
+
+			err = s.CreateUser(ctx, &User{Name: "Otro", Address: "Lobos", NickName: "chiche"})
+			require.ErrorIs(t, err, ErrNickNameTaken)
+			err = s.CreateUser(ctx, &User{Name: "Otro", Address: "Lobos", NickName: "juancho"})
+			require.ErrorIs(t, err, ErrNickNameTaken)
+		})
+	}
+}
+
+func TestStorage_SharedNickNameOutlivesHolder(t *testing.T) {
+	for backend, newStorage := range storageBackends(t, WithReleaseDeletedNickNames(true)) {
+		for name, giveUp := range map[string]func(ctx context.Context, s *Service, storage Storage) error{
+			"renamed": func(ctx context.Context, s *Service, _ Storage) error {
+				nick := "Roberto"
+				_, err := s.UpdateUser(ctx, "a", &UpdateFieldsUser{NickName: &nick}, 0)
+				return err
+			},
+			"deleted": func(ctx context.Context, s *Service, _ Storage) error {
+				return s.Delete(ctx, "a", 0)
+			},
+			"removed": func(ctx context.Context, _ *Service, storage Storage) error {
+				return storage.Delete(ctx, "a", 0)
+			},
+		} {
+			t.Run(backend+"/"+name, func(t *testing.T) {
+				ctx := context.Background()
+				storage := newStorage()
+				s := NewService(storage, nil)
+
+				_, err := storage.ImportUser(ctx, &User{ID: "a", NickName: "bob", Status: UserStatusActive, Version: 1})
+				require.NoError(t, err)
+				_, err = storage.ImportUser(ctx, &User{ID: "b", NickName: "Bob", Status: UserStatusActive, Version: 1})
+				require.NoError(t, err)
+				require.NoError(t, giveUp(ctx, s, storage))
+
+				// b still holds it.
+				taken, err := storage.NickNameTaken(ctx, "bob")
+				require.NoError(t, err)
+				require.True(t, taken)
+				err = storage.SetUser(ctx, &User{ID: "c", NickName: "BOB", Status: UserStatusActive, Version: 1})
+				require.ErrorIs(t, err, ErrNickNameTaken)
+
+				other := "Bobby"
+				_, err = s.UpdateUser(ctx, "b", &UpdateFieldsUser{NickName: &other}, 0)
+				require.NoError(t, err)
+				require.NoError(t, storage.SetUser(ctx, &User{ID: "c", NickName: "BOB", Status: UserStatusActive, Version: 1}))
+			})
+		}
+	}
+}
+
+func TestSQLiteStorage_ClaimsNickNamesOfExistingUsers(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.db")
+
+	// A database from before nicknames were unique, holding duplicates.
+	db, err := sql.Open(sqliteDriver, "file:"+path)
+	require.NoError(t, err)
+	require.NoError(t, migrate(db, userMigrations[:3]))
+	for i, u := range []struct{ id, nick, status string }{
+		{"a", "Chiche", UserStatusDeleted},
+		{"b", "CHICHE", UserStatusActive},
+		{"c", "chiche", UserStatusActive},
+	} {
+		_, err := db.Exec(`
+			INSERT INTO users (id, name, address, nickname, status, created_at, updated_at, version)
+			VALUES (?, '', '', ?, ?, ?, ?, 1)`, u.id, u.nick, u.status, i+1, i+1)
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	storage, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+	s := NewService(storage, nil)
+
+	// All of them hold it and can still be written.
+	name := "Juan"
+	_, err = s.UpdateUser(ctx, "c", &UpdateFieldsUser{Name: &name}, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(ctx, "b", 0))
+	err = s.CreateUser(ctx, &User{Name: "Otro", Address: "Lobos", NickName: "Chiche"})
+	require.ErrorIs(t, err, ErrNickNameTaken)
+	require.NoError(t, storage.Close())
+
+	// Once deleted users release theirs, c alone holds it.
+	storage, err = NewSQLiteStorage(path, WithReleaseDeletedNickNames(true))
+	require.NoError(t, err)
+	defer storage.Close()
+	s = NewService(storage, nil)
+
+	taken, err := storage.NickNameTaken(ctx, "chiche")
+	require.NoError(t, err)
+	require.True(t, taken)
+	nick := "chiche"
+	_, err = s.UpdateUser(ctx, "c", &UpdateFieldsUser{NickName: &nick}, 0)
+	require.NoError(t, err)
+	_, err = s.Restore(ctx, "a", 0)
+	require.ErrorIs(t, err, ErrNickNameTaken)
+}
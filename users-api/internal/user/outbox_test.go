@@ -0,0 +1,171 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_QueuesEventsInOutbox(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil)
+
+			u := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+			require.NoError(t, s.CreateUser(ctx, u))
+			name := "Juan"
+			_, err := s.UpdateUser(ctx, u.ID, &UpdateFieldsUser{Name: &name}, 0)
+			require.NoError(t, err)
+			require.NoError(t, s.Delete(ctx, u.ID, 0))
+
+			// Failed changes queue nothing.
+			_, err = s.UpdateUser(ctx, u.ID, &UpdateFieldsUser{Name: &name}, 1)
+			require.ErrorIs(t, err, ErrVersionConflict)
+			require.ErrorIs(t, s.Delete(ctx, "missing", 0), ErrNotFound)
+
+			entries, err := storage.ReadOutbox(ctx, OutboxFilter{})
+			require.NoError(t, err)
+			require.Len(t, entries, 3)
+			for i, eventType := range []string{EventUserCreated, EventUserUpdated, EventUserDeleted} {
+				entry := entries[i]
+				require.Equal(t, int64(i+1), entry.Seq)
+				require.Equal(t, OutboxPending, entry.Status)
+				require.Equal(t, eventType, entry.Event.Type)
+				require.Equal(t, u.ID, entry.Event.UserID)
+				require.Equal(t, i+1, entry.Event.Version)
+				require.Equal(t, i+1, entry.Event.User.Version)
+			}
+			require.Equal(t, "Juan", entries[1].Event.User.Name)
+			require.Equal(t, UserStatusDeleted, entries[2].Event.User.Status)
+			require.Equal(t, "user.deleted:"+u.ID+":3", entries[2].Event.ID)
+		})
+	}
+}
+
+func TestDispatcher_RetriesAndDeadLetters(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil)
+
+			publisher := NewInProcessPublisher()
+			down := true
+			var delivered []string
+			publisher.Subscribe(func(_ context.Context, event Event) error {
+				if down {
+					return errors.New("receiver down")
+				}
+				delivered = append(delivered, event.ID)
+				return nil
+			})
+
+			u := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+			require.NoError(t, s.CreateUser(ctx, u))
+
+			now := time.Now()
+			d := NewDispatcher(storage, DispatcherConfig{MaxAttempts: 3, RetryWait: time.Second, RetryMaxWait: 2 * time.Second}, nil, publisher)
+			d.now = func() time.Time { return now }
+
+			result, err := d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Retried: 1}, result)
+
+			// Not due yet.
+			result, err = d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{}, result)
+
+			now = now.Add(time.Second)
+			result, err = d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Retried: 1}, result)
+
+			entry, err := d.Entry(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, 2, entry.Attempts)
+			require.Equal(t, "receiver down", entry.LastError)
+			require.True(t, entry.NextAttemptAt.Equal(now.Add(2*time.Second)))
+
+			now = now.Add(2 * time.Second)
+			result, err = d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Dead: 1}, result)
+
+			dead, err := d.Entries(ctx, OutboxDead, 0)
+			require.NoError(t, err)
+			require.Len(t, dead, 1)
+
+			// Once the receiver is back, a replay gets it through.
+			down = false
+			entry, err = d.Replay(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, OutboxPending, entry.Status)
+			require.Zero(t, entry.Attempts)
+
+			result, err = d.DispatchPending(ctx)
+			require.NoError(t, err)
+			require.Equal(t, DispatchResult{Delivered: 1}, result)
+			require.Equal(t, []string{"user.created:" + u.ID + ":1"}, delivered)
+
+			entry, err = d.Entry(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, OutboxDelivered, entry.Status)
+			require.Empty(t, entry.LastError)
+
+			_, err = d.Replay(ctx, 9)
+			require.ErrorIs(t, err, ErrOutboxEntryNotFound)
+			_, err = d.Entries(ctx, "lost", 0)
+			require.ErrorIs(t, err, ErrInvalidInput)
+		})
+	}
+}
+
+func TestLocalOutbox_KeepsLatestDelivered(t *testing.T) {
+	o := newLocalOutbox()
+	o.keep = 2
+	for i := range 4 {
+		o.add(Event{ID: fmt.Sprint("event-", i+1)})
+	}
+
+	deliver := func(seq int64, at time.Time) {
+		entry, err := o.get(seq)
+		require.NoError(t, err)
+		entry.Status = OutboxDelivered
+		entry.DeliveredAt = at
+		require.NoError(t, o.update(entry))
+	}
+	now := time.Now()
+	deliver(1, now)
+	deliver(3, now)
+	require.Equal(t, []int64{2, 4}, o.pending)
+
+	// Replaying puts an entry back in order among the pending ones.
+	entry, err := o.get(1)
+	require.NoError(t, err)
+	entry.Status = OutboxPending
+	entry.DeliveredAt = time.Time{}
+	require.NoError(t, o.update(entry))
+	pending := o.read(OutboxFilter{Status: OutboxPending})
+	require.Len(t, pending, 3)
+	require.Equal(t, []int64{1, 2, 4}, []int64{pending[0].Seq, pending[1].Seq, pending[2].Seq})
+
+	// Only the two latest deliveries are kept: 3 is dropped, while 1 is kept
+	// for its second delivery.
+	deliver(1, now.Add(time.Second))
+	deliver(2, now.Add(2*time.Second))
+	_, err = o.get(3)
+	require.ErrorIs(t, err, ErrOutboxEntryNotFound)
+
+	delivered := o.read(OutboxFilter{Status: OutboxDelivered})
+	require.Len(t, delivered, 2)
+	require.Equal(t, int64(1), delivered[0].Seq)
+	require.Equal(t, int64(2), delivered[1].Seq)
+	require.Len(t, o.read(OutboxFilter{}), 3)
+}
@@ -0,0 +1,125 @@
+package user
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Page sizes of a user listing.
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// PageRequest asks for one page of a user listing, which is always oldest
+// first, ties broken by ID.
+type PageRequest struct {
+	// Limit is at most MaxPageSize, DefaultPageSize if zero.
+	Limit int
+
+	// Cursor is the NextCursor of the previous page, empty for the first one.
+	Cursor string
+}
+
+// UserPage is what the storage is asked for: up to Limit users right after
+// After, or from the first if nil. A zero Limit asks for all of them.
+type UserPage struct {
+	After *UserCursor
+	Limit int
+}
+
+// UserCursor is the position of a user in a listing. Clients get it
+// encoded, as an opaque string.
+type UserCursor struct {
+	// CreatedAt is in unix nanoseconds.
+	CreatedAt int64  `json:"t"`
+	ID        string `json:"id"`
+}
+
+// cursorAt returns the position of user in a listing.
+func cursorAt(user *User) *UserCursor {
+	return &UserCursor{CreatedAt: toUnixNano(user.CreatedAt), ID: user.ID}
+}
+
+// passed reports whether user is at or before c in a listing, so it was
+// already on an earlier page.
+func (c *UserCursor) passed(user *User) bool {
+	if t := toUnixNano(user.CreatedAt); t != c.CreatedAt {
+		return t < c.CreatedAt
+	}
+	return user.ID <= c.ID
+}
+
+// Encode returns the opaque form of c handed to clients.
+func (c *UserCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses the result of UserCursor.Encode.
+func decodeCursor(s string) (*UserCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	var c UserCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	return &c, nil
+}
+
+// userPage checks req and turns it into the UserPage asked to the storage.
+func userPage(req PageRequest) (UserPage, error) {
+	page := UserPage{Limit: req.Limit}
+	if req.Cursor != "" {
+		after, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return page, err
+		}
+		page.After = after
+	}
+	switch {
+	case page.Limit == 0:
+		page.Limit = DefaultPageSize
+	case page.Limit < 0 || page.Limit > MaxPageSize:
+		return page, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidInput, MaxPageSize)
+	}
+	return page, nil
+}
+
+// paginate sorts users oldest first and returns the page of them, for
+// storages that filter in memory.
+func paginate(users []*User, page UserPage) []*User {
+	slices.SortFunc(users, func(a, b *User) int {
+		if c := cmp.Compare(toUnixNano(a.CreatedAt), toUnixNano(b.CreatedAt)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	if page.After != nil {
+		start, _ := slices.BinarySearchFunc(users, page.After, func(user *User, after *UserCursor) int {
+			if after.passed(user) {
+				return -1
+			}
+			return 1
+		})
+		users = users[start:]
+	}
+	if page.Limit > 0 && len(users) > page.Limit {
+		users = users[:page.Limit]
+	}
+	return users
+}
+
+// UserList is a page of a user listing.
+type UserList struct {
+	Results []*User `json:"results"`
+
+	// NextCursor fetches the page after Results; empty on the last one.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
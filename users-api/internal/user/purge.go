@@ -0,0 +1,172 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PurgeConfig tunes a Purger. Zero fields take their value from
+// DefaultPurgeConfig.
+type PurgeConfig struct {
+	// Interval is how often the Purger looks for users to remove.
+	Interval time.Duration
+
+	// Retention is how long a deleted user is kept, so they can still be
+	// restored, before being removed for good.
+	Retention time.Duration
+
+	// Batch bounds how many users are read at once.
+	Batch int
+}
+
+// DefaultPurgeConfig returns the settings used for fields left empty.
+func DefaultPurgeConfig() PurgeConfig {
+	return PurgeConfig{
+		Interval:  time.Hour,
+		Retention: 30 * 24 * time.Hour,
+		Batch:     100,
+	}
+}
+
+// PurgedUser is a user a purge removed.
+type PurgedUser struct {
+	ID        string    `json:"id"`
+	NickName  string    `json:"nickname"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// PurgeReport tells what one PurgeDeleted pass removed.
+type PurgeReport struct {
+	StartedAt time.Time `json:"started_at"`
+
+	// Cutoff is when the users removed were deleted before.
+	Cutoff time.Time `json:"cutoff"`
+
+	Purged []PurgedUser `json:"purged"`
+
+	// Skipped users changed after they were picked, e.g. were restored, and
+	// were left alone.
+	Skipped int `json:"skipped"`
+}
+
+// Purger removes, with Storage.Delete, the users deleted longer than the
+// retention window, counted from their DeletedAt.
+type Purger struct {
+	storage Storage
+	cfg     PurgeConfig
+	logger  *zap.Logger
+	now     func() time.Time
+
+	mu   sync.Mutex
+	last *PurgeReport
+}
+
+// NewPurger builds a Purger of the users kept in storage.
+func NewPurger(storage Storage, cfg PurgeConfig, logger *zap.Logger) *Purger {
+	def := DefaultPurgeConfig()
+	if cfg.Interval == 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.Retention == 0 {
+		cfg.Retention = def.Retention
+	}
+	if cfg.Batch == 0 {
+		cfg.Batch = def.Batch
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Purger{
+		storage: storage,
+		cfg:     cfg,
+		logger:  logger,
+		now:     time.Now,
+	}
+}
+
+// PurgeDeleted removes every user deleted before the retention window, in
+// batches, and reports who it removed. It is also kept as the LastReport.
+//
+// A user is only removed if still the version that was picked, so one
+// restored meanwhile is not lost.
+func (p *Purger) PurgeDeleted(ctx context.Context) (PurgeReport, error) {
+	now := p.now()
+	report := PurgeReport{StartedAt: now, Cutoff: now.Add(-p.cfg.Retention), Purged: []PurgedUser{}}
+	defer p.keep(&report)
+
+	filter := UserFilter{Statuses: []string{UserStatusDeleted}, DeletedTo: report.Cutoff}
+	page := UserPage{Limit: p.cfg.Batch}
+	for {
+		users, err := p.storage.ReadUsers(ctx, filter, page)
+		if err != nil {
+			return report, contextError(err)
+		}
+
+		for _, picked := range users {
+			err := p.storage.Delete(ctx, picked.ID, picked.Version)
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+				report.Skipped++
+				continue
+			}
+			if err != nil {
+				return report, contextError(err)
+			}
+			report.Purged = append(report.Purged, PurgedUser{ID: picked.ID, NickName: picked.NickName, DeletedAt: picked.DeletedAt})
+		}
+
+		if len(users) < page.Limit {
+			return report, nil
+		}
+		page.After = cursorAt(users[len(users)-1])
+	}
+}
+
+// keep records report as the LastReport.
+func (p *Purger) keep(report *PurgeReport) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = report
+}
+
+// LastReport returns the report of the latest PurgeDeleted pass, or false
+// if there was none yet.
+func (p *Purger) LastReport() (PurgeReport, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last == nil {
+		return PurgeReport{}, false
+	}
+	return *p.last, true
+}
+
+// Run purges every Interval until ctx is done, logging who was removed.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := p.PurgeDeleted(ctx)
+		if err != nil {
+			p.logger.Error("purge of deleted users failed", zap.Error(err), zap.Int("purged", len(report.Purged)))
+			continue
+		}
+		if len(report.Purged)+report.Skipped > 0 {
+			ids := make([]string, len(report.Purged))
+			for i, u := range report.Purged {
+				ids[i] = u.ID
+			}
+			p.logger.Info("purged deleted users", zap.Strings("ids", ids), zap.Int("skipped", report.Skipped),
+				zap.Time("cutoff", report.Cutoff))
+		}
+	}
+}
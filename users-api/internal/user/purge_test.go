@@ -0,0 +1,166 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Restore(t *testing.T) {
+	for backend, newStorage := range storageBackends(t, WithReleaseDeletedNickNames(true)) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil)
+
+			chiche := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+			require.NoError(t, s.CreateUser(ctx, chiche))
+
+			_, err := s.Restore(ctx, chiche.ID, 0)
+			require.ErrorIs(t, err, ErrUserNotDeleted)
+
+			require.NoError(t, s.Delete(ctx, chiche.ID, 0))
+			deleted, err := storage.ReadUser(ctx, chiche.ID)
+			require.NoError(t, err)
+			require.False(t, deleted.DeletedAt.IsZero())
+
+			// Neither deleting again nor changing the user restarts their
+			// retention window.
+			require.NoError(t, s.Delete(ctx, chiche.ID, 0))
+			name := "Senna"
+			_, err = s.UpdateUser(ctx, chiche.ID, &UpdateFieldsUser{Name: &name}, 0)
+			require.NoError(t, err)
+			changed, err := storage.ReadUser(ctx, chiche.ID)
+			require.NoError(t, err)
+			require.True(t, deleted.DeletedAt.Equal(changed.DeletedAt))
+
+			_, err = s.Restore(ctx, chiche.ID, 1)
+			require.ErrorIs(t, err, ErrVersionConflict)
+
+			restored, err := s.Restore(ctx, chiche.ID, 4)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusActive, restored.Status)
+			require.Equal(t, 5, restored.Version)
+			require.True(t, restored.DeletedAt.IsZero())
+
+			got, err := s.GetUser(ctx, chiche.ID)
+			require.NoError(t, err)
+			require.Equal(t, 5, got.Version)
+
+			entries, err := storage.ReadOutbox(ctx, OutboxFilter{})
+			require.NoError(t, err)
+			require.Equal(t, EventUserRestored, entries[len(entries)-1].Event.Type)
+
+			// Once deleted users give their nickname up, it may be gone by
+			// the time they come back.
+			require.NoError(t, s.Delete(ctx, chiche.ID, 0))
+			require.NoError(t, s.CreateUser(ctx, &User{Name: "Otro", Address: "Lobos", NickName: "chiche"}))
+			_, err = s.Restore(ctx, chiche.ID, 0)
+			require.ErrorIs(t, err, ErrNickNameTaken)
+
+			_, err = s.Restore(ctx, "missing", 0)
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+// restoringStorage restores a user right after it was listed, as a client
+// could while a purge runs.
+type restoringStorage struct {
+	Storage
+	restore string
+}
+
+func (r *restoringStorage) ReadUsers(ctx context.Context, filter UserFilter, page UserPage) ([]*User, error) {
+	users, err := r.Storage.ReadUsers(ctx, filter, page)
+	for _, u := range users {
+		if u.ID == r.restore {
+			if _, err := NewService(r.Storage, nil).Restore(ctx, u.ID, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return users, err
+}
+
+func TestPurger_PurgeDeleted(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(d int) time.Time { return now.AddDate(0, 0, -d) }
+
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			for _, u := range []*User{
+				{ID: "a", NickName: "a", Status: UserStatusDeleted, CreatedAt: daysAgo(90), UpdatedAt: daysAgo(40), DeletedAt: daysAgo(40), Version: 2},
+				{ID: "b", NickName: "b", Status: UserStatusDeleted, CreatedAt: daysAgo(90), UpdatedAt: daysAgo(10), DeletedAt: daysAgo(10), Version: 2},
+				{ID: "c", NickName: "c", Status: UserStatusActive, CreatedAt: daysAgo(90), UpdatedAt: daysAgo(60), Version: 1},
+				// Changed since it was deleted, which does not restart its retention.
+				{ID: "d", NickName: "d", Status: UserStatusDeleted, CreatedAt: daysAgo(80), UpdatedAt: daysAgo(2), DeletedAt: daysAgo(31), Version: 3},
+				{ID: "e", NickName: "e", Status: UserStatusDeleted, CreatedAt: daysAgo(70), UpdatedAt: daysAgo(50), DeletedAt: daysAgo(50), Version: 2},
+			} {
+				require.NoError(t, storage.SetUser(ctx, u))
+			}
+
+			// e is restored while the purge runs.
+			p := NewPurger(&restoringStorage{Storage: storage, restore: "e"}, PurgeConfig{Batch: 1}, nil)
+			p.now = func() time.Time { return now }
+
+			_, ok := p.LastReport()
+			require.False(t, ok)
+
+			report, err := p.PurgeDeleted(ctx)
+			require.NoError(t, err)
+			require.Equal(t, now.AddDate(0, 0, -30), report.Cutoff)
+			require.Len(t, report.Purged, 2)
+			for i, want := range []PurgedUser{
+				{ID: "a", NickName: "a", DeletedAt: daysAgo(40)},
+				{ID: "d", NickName: "d", DeletedAt: daysAgo(31)},
+			} {
+				got := report.Purged[i]
+				require.Equal(t, want.ID, got.ID)
+				require.Equal(t, want.NickName, got.NickName)
+				require.True(t, want.DeletedAt.Equal(got.DeletedAt), got.DeletedAt)
+			}
+			require.Equal(t, 1, report.Skipped)
+
+			last, ok := p.LastReport()
+			require.True(t, ok)
+			require.Equal(t, report, last)
+
+			for id, want := range map[string]error{"a": ErrNotFound, "b": nil, "c": nil, "d": ErrNotFound, "e": nil} {
+				_, err := storage.ReadUser(ctx, id)
+				require.ErrorIs(t, err, want, id)
+			}
+		})
+	}
+}
+
+func TestStorage_Delete(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			require.NoError(t, storage.SetUser(ctx, &User{ID: "a", NickName: "a", Status: UserStatusDeleted, Version: 2}))
+			require.NoError(t, storage.SetUser(ctx, &User{ID: "b", NickName: "b", Status: UserStatusActive, Version: 3}))
+
+			require.ErrorIs(t, storage.Delete(ctx, "a", 1), ErrVersionConflict)
+			require.ErrorIs(t, storage.Delete(ctx, "missing", 1), ErrNotFound)
+			require.ErrorIs(t, storage.Delete(ctx, "missing", 0), ErrNotFound)
+			require.NoError(t, storage.Delete(ctx, "a", 2))
+			require.NoError(t, storage.Delete(ctx, "b", 0))
+
+			for _, id := range []string{"a", "b"} {
+				_, err := storage.ReadUser(ctx, id)
+				require.ErrorIs(t, err, ErrNotFound)
+			}
+
+			// Their nicknames are free again.
+			taken, err := storage.NickNameTaken(ctx, "A")
+			require.NoError(t, err)
+			require.False(t, taken)
+		})
+	}
+}
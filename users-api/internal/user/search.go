@@ -0,0 +1,21 @@
+package user
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// fold returns s lower-cased and without accents, so "José" and "jose"
+// compare equal. Searches compare folded strings.
+func fold(s string) string {
+	// A transformer keeps state, so each call needs its own.
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if folded, _, err := transform.String(t, s); err == nil {
+		s = folded
+	}
+	return strings.ToLower(s)
+}
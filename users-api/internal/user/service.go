@@ -1,6 +1,7 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"regexp"
 	"time"
@@ -16,8 +17,20 @@ var (
 	ErrUserNotFound       = errors.New("user not found")
 	ErrSaleNotFound       = errors.New("sale not found")
 	ErrTransactionInvalid = errors.New("transaccion invalida")
+	ErrTimeout            = errors.New("request timed out")
+	ErrUserNotDeleted     = errors.New("user is not deleted")
+	ErrInvalidTransition  = errors.New("invalid user status change")
 )
 
+// contextError translates a context error into the service's own errors, so
+// callers can tell a deadline apart from any other failure.
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return err
+}
+
 // Service provides high-level user management operations on a LocalStorage backend.
 type Service struct {
 	// storage is the underlying persistence for User entities.
@@ -28,6 +41,8 @@ type Service struct {
 }
 
 // NewService creates a new Service.
+// Every change it makes queues a user event in the storage outbox; run a
+// Dispatcher to deliver them.
 func NewService(storage Storage, logger *zap.Logger) *Service {
 	if logger == nil {
 		logger, _ = zap.NewProduction()
@@ -41,7 +56,10 @@ func NewService(storage Storage, logger *zap.Logger) *Service {
 }
 
 // Create de Usuario
-func (s *Service) CreateUser(user *User) error {
+func (s *Service) CreateUser(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
 
 	if user.Name == "" || user.Address == "" || user.NickName == "" {
 		return ErrInvalidInput
@@ -60,40 +78,76 @@ func (s *Service) CreateUser(user *User) error {
 	user.Version = 1
 	user.Status = UserStatusActive
 
-	if err := s.storage.SetUser(user); err != nil {
+	if err := s.storage.SetUser(ctx, user, newEvent(EventUserCreated, user)); err != nil {
 		s.logger.Error("failed to set user", zap.Error(err), zap.Any("user", user))
-		return err
+		return contextError(err)
 	}
 
 	return nil
 }
 
 // si el users esta inactivo, no lo devolverá
-func (s *Service) GetUser(id string) (*User, error) {
-	user, err := s.storage.ReadUser(id)
+func (s *Service) GetUser(ctx context.Context, id string) (*User, error) {
+	user, err := s.storage.ReadUser(ctx, id)
 	if err != nil {
-		return nil, err
+		return nil, contextError(err)
 	}
 	if user.Status == UserStatusDeleted {
 		return nil, ErrNotFound
 	}
+	liftExpiredSuspension(user, time.Now())
 	return user, nil
 }
 
-//Update
-//Si no se modifica ningún valor debe arrojar un 400.
+// ListUsers returns a page of the users matching filter, oldest first.
+func (s *Service) ListUsers(ctx context.Context, filter UserFilter, req PageRequest) (UserList, error) {
+	page, err := userPage(req)
+	if err != nil {
+		return UserList{}, err
+	}
 
-func (s *Service) UpdateUser(id string, updates *UpdateFieldsUser) (*User, error) {
-	existing, err := s.storage.ReadUser(id)
+	// One more than asked tells whether there is a next page.
+	limit := page.Limit
+	page.Limit++
+	users, err := s.storage.ReadUsers(ctx, filter, page)
 	if err != nil {
-		return nil, err
+		return UserList{}, contextError(err)
 	}
 
+	now := time.Now()
+	for _, user := range users {
+		liftExpiredSuspension(user, now)
+	}
+	list := UserList{Results: users}
+	if len(users) > limit {
+		list.Results = users[:limit]
+		list.NextCursor = cursorAt(users[limit-1]).Encode()
+	}
+	return list, nil
+}
+
+// maxUpdateAttempts bounds how many times a write re-reads a user that
+// changed under it when the caller did not ask for a specific version.
+const maxUpdateAttempts = 3
+
+//Update
+//Si no se modifica ningún valor debe arrojar un 400.
+
+// UpdateUser applies updates to the user.
+// If expectedVersion is not zero, the update only applies to that version of
+// the user and ErrVersionConflict is returned otherwise.
+func (s *Service) UpdateUser(ctx context.Context, id string, updates *UpdateFieldsUser, expectedVersion int) (*User, error) {
+	return s.modify(ctx, id, expectedVersion, EventUserUpdated, func(existing *User) error {
+		return applyUpdates(existing, updates)
+	})
+}
+
+func applyUpdates(existing *User, updates *UpdateFieldsUser) error {
 	updated := false
 
 	if updates.Name != nil {
 		if !letterRegex.MatchString(*updates.Name) {
-			return nil, ErrInvalidInput
+			return ErrInvalidInput
 		}
 		existing.Name = *updates.Name
 		updated = true
@@ -107,7 +161,7 @@ func (s *Service) UpdateUser(id string, updates *UpdateFieldsUser) (*User, error
 
 	if updates.NickName != nil {
 		if !letterRegex.MatchString(*updates.NickName) {
-			return nil, ErrInvalidInput
+			return ErrInvalidInput
 		}
 		existing.NickName = *updates.NickName
 		updated = true
@@ -115,17 +169,9 @@ func (s *Service) UpdateUser(id string, updates *UpdateFieldsUser) (*User, error
 
 	// Si no se modificó nada, lanzar error 400
 	if !updated {
-		return nil, ErrNoFieldsToUpdate
-	}
-
-	existing.UpdatedAt = time.Now()
-	existing.Version++
-
-	if err := s.storage.SetUser(existing); err != nil {
-		return nil, err
+		return ErrNoFieldsToUpdate
 	}
-
-	return existing, nil
+	return nil
 }
 
 // Delete removes a user from the system by its ID.
@@ -135,25 +181,93 @@ func (s *Service) UpdateUser(id string, updates *UpdateFieldsUser) (*User, error
 }*/
 
 // Hacer que el borrado sea lógico en vez de físico.
-func (s *Service) Delete(id string) error {
-	user, err := s.storage.ReadUser(id)
+// If expectedVersion is not zero, only that version of the user is deleted.
+// Queues EventUserDeleted, so sales-api can cancel the user's open sales.
+// Deleting a deleted user again keeps the time of the first delete, so it
+// does not restart their retention window.
+func (s *Service) Delete(ctx context.Context, id string, expectedVersion int) error {
+	_, err := s.modify(ctx, id, expectedVersion, EventUserDeleted, func(user *User) error {
+		if user.Status != UserStatusDeleted {
+			user.StatusBeforeDeletion = user.Status
+			user.Status = UserStatusDeleted
+			user.DeletedAt = time.Now()
+		}
+		return nil
+	})
 	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			s.logger.Error("failed to set user as deleted", zap.Error(err), zap.String("id", id))
+		}
 		return err
 	}
+	return nil
+}
 
-	user.Status = UserStatusDeleted
-	user.UpdatedAt = time.Now()
-	user.Version++
+// Restore brings a deleted user back, as a new version, to the status they
+// had when they were deleted, so deleting a suspended or blocked user does
+// not lift the sanction. A suspension that ended in the meantime is lifted.
+// If expectedVersion is not zero, only that version of the user is restored.
+// Returns ErrUserNotDeleted if the user is not deleted, and ErrNickNameTaken
+// if someone took their nickname in the meantime.
+func (s *Service) Restore(ctx context.Context, id string, expectedVersion int) (*User, error) {
+	return s.modify(ctx, id, expectedVersion, EventUserRestored, func(user *User) error {
+		if user.Status != UserStatusDeleted {
+			return ErrUserNotDeleted
+		}
+		user.Status = user.StatusBeforeDeletion
+		if user.Status == "" {
+			setStatus(user, UserStatusActive, "", time.Time{})
+		}
+		user.StatusBeforeDeletion = ""
+		user.DeletedAt = time.Time{}
+		liftExpiredSuspension(user, time.Now())
+		return nil
+	})
+}
 
-	if err := s.storage.SetUser(user); err != nil {
-		s.logger.Error("failed to set user as deleted", zap.Error(err), zap.String("id", id))
-		return err
-	}
+// modify reads the user, applies change and writes it back with a new
+// version, together with an event of eventType, as long as nobody wrote it
+// in between. Without an expectedVersion, a concurrent write makes it start
+// over from a fresh read.
+func (s *Service) modify(ctx context.Context, id string, expectedVersion int, eventType string, change func(*User) error) (*User, error) {
+	for attempt := 1; ; attempt++ {
+		existing, err := s.storage.ReadUser(ctx, id)
+		if err != nil {
+			return nil, contextError(err)
+		}
+		if expectedVersion != 0 && existing.Version != expectedVersion {
+			return nil, ErrVersionConflict
+		}
 
-	return nil
+		now := time.Now()
+		liftExpiredSuspension(existing, now)
+		if err := change(existing); err != nil {
+			return nil, err
+		}
+
+		readVersion := existing.Version
+		existing.UpdatedAt = now
+		existing.Version++
+
+		err = s.storage.CompareAndSetUser(ctx, existing, readVersion, newEvent(eventType, existing))
+		if errors.Is(err, ErrVersionConflict) && expectedVersion == 0 && attempt < maxUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, contextError(err)
+		}
+
+		return existing, nil
+	}
 }
 
 const (
 	UserStatusActive  = "active"
 	UserStatusDeleted = "deleted"
+
+	// UserStatusSuspended is temporary: it ends by itself at SuspendedUntil.
+	UserStatusSuspended = "suspended"
+
+	// UserStatusBlocked is for fraud; only an admin lifts it.
+	UserStatusBlocked = "blocked"
 )
@@ -1,14 +1,37 @@
 package user
 
 import (
+	"context"
 	"errors"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
+// storageBackends returns a constructor for every Storage implementation, so
+// the service tests run against each backend.
+func storageBackends(t *testing.T, opts ...StorageOption) map[string]func() Storage {
+	return map[string]func() Storage{
+		"local": func() Storage { return NewLocalStorage(opts...) },
+		"sqlite": func() Storage {
+			s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "users.db"), opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+}
+
 func TestService_Create_Simple(t *testing.T) {
-	s := NewService(NewLocalStorage(), nil)
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) { testServiceCreateSimple(t, newStorage) })
+	}
+}
+
+func testServiceCreateSimple(t *testing.T, newStorage func() Storage) {
+	s := NewService(newStorage(), nil)
 
 	input := &User{
 		Name:     "Ayrton",
@@ -16,7 +39,7 @@ func TestService_Create_Simple(t *testing.T) {
 		NickName: "Chiche",
 	}
 
-	err := s.CreateUser(input)
+	err := s.CreateUser(context.Background(), input)
 
 	require.Nil(t, err)                  //valida que el error sea nil
 	require.NotEmpty(t, input.ID)        //para validar que el ID no sea vacío
@@ -30,12 +53,18 @@ func TestService_Create_Simple(t *testing.T) {
 		},
 	}, nil)
 
-	err = s.CreateUser(input)
+	err = s.CreateUser(context.Background(), input)
 	require.NotNil(t, err)
 	require.EqualError(t, err, "fake error trying to set user")
 }
 
 func TestService_Create(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) { testServiceCreate(t, newStorage) })
+	}
+}
+
+func testServiceCreate(t *testing.T, newStorage func() Storage) {
 	type fields struct {
 		storage Storage
 	}
@@ -72,7 +101,7 @@ func TestService_Create(t *testing.T) {
 		{
 			name: "success",
 			fields: fields{
-				storage: NewLocalStorage(),
+				storage: newStorage(),
 			},
 			args: args{
 				user: &User{
@@ -98,7 +127,7 @@ func TestService_Create(t *testing.T) {
 				storage: tt.fields.storage,
 			}
 
-			err := s.CreateUser(tt.args.user)
+			err := s.CreateUser(context.Background(), tt.args.user)
 			if tt.wantErr != nil {
 				tt.wantErr(t, err)
 			}
@@ -110,20 +139,147 @@ func TestService_Create(t *testing.T) {
 	}
 }
 
+func TestService_ContextDone(t *testing.T) {
+	s := NewService(NewLocalStorage(), nil)
+	u := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+	require.NoError(t, s.CreateUser(context.Background(), u))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.GetUser(ctx, u.ID)
+	require.ErrorIs(t, err, context.Canceled)
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	err = s.Delete(ctx, u.ID, 0)
+	require.ErrorIs(t, err, ErrTimeout)
+
+	got, err := s.GetUser(context.Background(), u.ID)
+	require.NoError(t, err)
+	require.Equal(t, UserStatusActive, got.Status)
+}
+
+func TestService_UpdateUser_Versions(t *testing.T) {
+	for backend, newStorage := range storageBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage()
+			s := NewService(storage, nil)
+			u := &User{Name: "Ayrton", Address: "Pringles", NickName: "Chiche"}
+			require.NoError(t, s.CreateUser(ctx, u))
+
+			name := "Senna"
+			// A stale If-Match is refused and nothing changes.
+			_, err := s.UpdateUser(ctx, u.ID, &UpdateFieldsUser{Name: &name}, 7)
+			require.ErrorIs(t, err, ErrVersionConflict)
+
+			got, err := s.UpdateUser(ctx, u.ID, &UpdateFieldsUser{Name: &name}, 1)
+			require.NoError(t, err)
+			require.Equal(t, 2, got.Version)
+
+			// Two writers holding version 2: only the first one wins.
+			require.NoError(t, s.Delete(ctx, u.ID, 2))
+			_, err = s.UpdateUser(ctx, u.ID, &UpdateFieldsUser{Name: &name}, 2)
+			require.ErrorIs(t, err, ErrVersionConflict)
+
+			// The storage refuses a swap against a stale version on its own.
+			got.Status = UserStatusActive
+			err = storage.CompareAndSetUser(ctx, got, 2)
+			require.ErrorIs(t, err, ErrVersionConflict)
+
+			stored, err := storage.ReadUser(ctx, u.ID)
+			require.NoError(t, err)
+			require.Equal(t, UserStatusDeleted, stored.Status)
+			require.Equal(t, 3, stored.Version)
+		})
+	}
+}
+
+func TestService_UpdateUser_RetriesConflicts(t *testing.T) {
+	stored := &User{ID: "1", Name: "Ayrton", Status: UserStatusActive, Version: 1}
+	attempts := 0
+	s := NewService(&mockStorage{
+		mockReadUser: func(id string) (*User, error) {
+			u := *stored
+			return &u, nil
+		},
+		mockCompareAndSetUser: func(user *User, expectedVersion int) error {
+			attempts++
+			if attempts < maxUpdateAttempts {
+				// Someone else wrote in between.
+				stored.Version++
+				return ErrVersionConflict
+			}
+			return nil
+		},
+	}, nil)
+
+	name := "Senna"
+	got, err := s.UpdateUser(context.Background(), "1", &UpdateFieldsUser{Name: &name}, 0)
+	require.NoError(t, err)
+	require.Equal(t, maxUpdateAttempts, attempts)
+	require.Equal(t, maxUpdateAttempts+1, got.Version)
+
+	// With an explicit version, a lost race is reported instead of retried.
+	attempts = 0
+	_, err = s.UpdateUser(context.Background(), "1", &UpdateFieldsUser{Name: &name}, stored.Version)
+	require.ErrorIs(t, err, ErrVersionConflict)
+	require.Equal(t, 1, attempts)
+}
+
 type mockStorage struct {
 	mockSetUser  func(user *User) error
 	mockReadUser func(id string) (*User, error)
-	mockDelete   func(id string) error
+	mockDelete   func(id string, expectedVersion int) error
+
+	mockReadUsers func(filter UserFilter, page UserPage) ([]*User, error)
+
+	mockNickNameTaken func(nick string) (bool, error)
+	mockImportUser    func(user *User) (bool, error)
+
+	mockCompareAndSetUser func(user *User, expectedVersion int) error
+
+	mockReadOutbox        func(filter OutboxFilter) ([]*OutboxEntry, error)
+	mockReadOutboxEntry   func(seq int64) (*OutboxEntry, error)
+	mockUpdateOutboxEntry func(entry *OutboxEntry) error
 }
 
-func (m *mockStorage) SetUser(user *User) error {
+func (m *mockStorage) SetUser(_ context.Context, user *User, _ ...Event) error {
 	return m.mockSetUser(user)
 }
 
-func (m *mockStorage) ReadUser(id string) (*User, error) {
+func (m *mockStorage) ReadUser(_ context.Context, id string) (*User, error) {
 	return m.mockReadUser(id)
 }
 
-func (m *mockStorage) Delete(id string) error {
-	return m.mockDelete(id)
+func (m *mockStorage) Delete(_ context.Context, id string, expectedVersion int) error {
+	return m.mockDelete(id, expectedVersion)
+}
+
+func (m *mockStorage) ReadUsers(_ context.Context, filter UserFilter, page UserPage) ([]*User, error) {
+	return m.mockReadUsers(filter, page)
+}
+
+func (m *mockStorage) NickNameTaken(_ context.Context, nick string) (bool, error) {
+	return m.mockNickNameTaken(nick)
+}
+
+func (m *mockStorage) ImportUser(_ context.Context, user *User) (bool, error) {
+	return m.mockImportUser(user)
+}
+
+func (m *mockStorage) CompareAndSetUser(_ context.Context, user *User, expectedVersion int, _ ...Event) error {
+	return m.mockCompareAndSetUser(user, expectedVersion)
+}
+
+func (m *mockStorage) ReadOutbox(_ context.Context, filter OutboxFilter) ([]*OutboxEntry, error) {
+	return m.mockReadOutbox(filter)
+}
+
+func (m *mockStorage) ReadOutboxEntry(_ context.Context, seq int64) (*OutboxEntry, error) {
+	return m.mockReadOutboxEntry(seq)
+}
+
+func (m *mockStorage) UpdateOutboxEntry(_ context.Context, entry *OutboxEntry) error {
+	return m.mockUpdateOutboxEntry(entry)
 }
@@ -0,0 +1,642 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is go-sqlite3 with fold available to queries as a SQL
+// function, so searches ignore case and accents the same way LocalStorage
+// does.
+const sqliteDriver = "sqlite3_users"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("fold", fold, true); err != nil {
+				return err
+			}
+			// Not pure: the status depends on when it is asked for.
+			return conn.RegisterFunc("current_status", func(status string, suspendedUntil int64) string {
+				return currentStatus(&User{Status: status, SuspendedUntil: fromUnixNano(suspendedUntil)})
+			}, false)
+		},
+	})
+}
+
+// userMigrations holds the schema changes for the SQLite backend, in order.
+// The position of each statement (1-based) is its schema version, tracked
+// through PRAGMA user_version. Never edit an applied migration: append a new one.
+var userMigrations = []string{
+	`CREATE TABLE users (
+		id         TEXT PRIMARY KEY,
+		name       TEXT    NOT NULL,
+		address    TEXT    NOT NULL,
+		nickname   TEXT    NOT NULL,
+		status     TEXT    NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		version    INTEGER NOT NULL
+	);
+	CREATE INDEX idx_users_status ON users (status);`,
+
+	`CREATE TABLE outbox (
+		seq             INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id        TEXT    NOT NULL UNIQUE,
+		event           TEXT    NOT NULL,
+		status          TEXT    NOT NULL,
+		attempts        INTEGER NOT NULL,
+		last_error      TEXT    NOT NULL,
+		created_at      INTEGER NOT NULL,
+		next_attempt_at INTEGER NOT NULL,
+		delivered_at    INTEGER NOT NULL
+	);
+	CREATE INDEX idx_outbox_due ON outbox (status, next_attempt_at);`,
+
+	// Keyset pagination of user listings.
+	`CREATE INDEX idx_users_created ON users (created_at, id);`,
+
+	// Nicknames are unique ignoring case: nickname_key is the nickNameKey of
+	// the nickname its user holds, NULL if they hold none. Existing users
+	// get theirs from SQLiteStorage.claimNickNames.
+	`ALTER TABLE users ADD COLUMN nickname_key TEXT;
+	CREATE INDEX idx_users_nickname_key ON users (nickname_key);`,
+
+	`ALTER TABLE users ADD COLUMN status_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN suspended_until INTEGER NOT NULL DEFAULT 0;`,
+
+	// Users deleted before deleted_at was kept count as deleted since their
+	// last change.
+	`ALTER TABLE users ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE users SET deleted_at = updated_at WHERE status = 'deleted';`,
+
+	// Users deleted before status_before_deletion was kept are restored as
+	// active.
+	`ALTER TABLE users ADD COLUMN status_before_deletion TEXT NOT NULL DEFAULT '';`,
+}
+
+// SQLiteStorage provides a durable implementation of Storage backed by an
+// embedded SQLite database file.
+//
+// Soft-deleted users are kept as rows with status "deleted", together with
+// their Version and timestamps, so the logical delete survives a restart.
+// Only Delete removes a row physically.
+type SQLiteStorage struct {
+	db   *sql.DB
+	opts storageOptions
+}
+
+// NewSQLiteStorage opens (or creates) the SQLite database at path and brings
+// its schema up to date.
+func NewSQLiteStorage(path string, opts ...StorageOption) (*SQLiteStorage, error) {
+	db, err := sql.Open(sqliteDriver, "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database: %w", err)
+	}
+	// SQLite serializes writers anyway; a single connection avoids SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db, userMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &SQLiteStorage{db: db, opts: newStorageOptions(opts)}
+	if err := s.claimNickNames(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error claiming nicknames: %w", err)
+	}
+	return s, nil
+}
+
+// Close releases the underlying database handle.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies every migration newer than the database's user_version.
+func migrate(db *sql.DB, migrations []string) error {
+	var current int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&current); err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error applying migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetUser inserts or replaces a user, including its status.
+// Returns ErrEmptyID if the user has an empty ID.
+func (s *SQLiteStorage) SetUser(ctx context.Context, user *User, events ...Event) error {
+	_, err := s.setUser(ctx, user, false, events)
+	return err
+}
+
+// ImportUser implements Storage.
+func (s *SQLiteStorage) ImportUser(ctx context.Context, user *User) (bool, error) {
+	return s.setUser(ctx, user, true, nil)
+}
+
+// setUser stores user, letting them share a nickname if share is set.
+func (s *SQLiteStorage) setUser(ctx context.Context, user *User, share bool, events []Event) (shared bool, err error) {
+	if user.ID == "" {
+		return false, ErrEmptyID
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	old, err := readUser(ctx, tx, user.ID)
+	if errors.Is(err, ErrNotFound) {
+		old = nil
+	} else if err != nil {
+		return false, err
+	}
+	key, shared, err := s.nickNameKeyFor(ctx, tx, old, user, share)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO users (id, name, address, nickname, nickname_key, status, status_reason, suspended_until,
+			status_before_deletion, deleted_at, created_at, updated_at, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name,
+			address = excluded.address,
+			nickname = excluded.nickname,
+			nickname_key = excluded.nickname_key,
+			status = excluded.status,
+			status_reason = excluded.status_reason,
+			suspended_until = excluded.suspended_until,
+			status_before_deletion = excluded.status_before_deletion,
+			deleted_at = excluded.deleted_at,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			version = excluded.version`,
+		user.ID, user.Name, user.Address, user.NickName, key, user.Status, user.StatusReason, toUnixNano(user.SuspendedUntil),
+		user.StatusBeforeDeletion, toUnixNano(user.DeletedAt), toUnixNano(user.CreatedAt), toUnixNano(user.UpdatedAt), user.Version,
+	)
+	if err != nil {
+		return false, err
+	}
+	if err := insertOutbox(ctx, tx, events); err != nil {
+		return false, err
+	}
+	return shared, tx.Commit()
+}
+
+// CompareAndSetUser updates the row only where the version still matches.
+// Writes run one at a time on the single connection, so checking the
+// version within tx is enough.
+func (s *SQLiteStorage) CompareAndSetUser(ctx context.Context, user *User, expectedVersion int, events ...Event) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	old, err := readUser(ctx, tx, user.ID)
+	if err != nil {
+		return err
+	}
+	if old.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	key, _, err := s.nickNameKeyFor(ctx, tx, old, user, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET
+			name = ?, address = ?, nickname = ?, nickname_key = ?, status = ?, status_reason = ?, suspended_until = ?,
+			status_before_deletion = ?, deleted_at = ?, created_at = ?, updated_at = ?, version = ?
+		WHERE id = ?`,
+		user.Name, user.Address, user.NickName, key, user.Status, user.StatusReason, toUnixNano(user.SuspendedUntil),
+		user.StatusBeforeDeletion, toUnixNano(user.DeletedAt), toUnixNano(user.CreatedAt), toUnixNano(user.UpdatedAt), user.Version,
+		user.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := insertOutbox(ctx, tx, events); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// nickNameKeyFor returns the nickname_key to store user with over old, nil
+// for a new user, following the rules of LocalStorage.claimNickNameLocked.
+// Writes run one at a time on the single connection, so checking within tx
+// is enough.
+func (s *SQLiteStorage) nickNameKeyFor(ctx context.Context, tx *sql.Tx, old, user *User, share bool) (key sql.NullString, shared bool, err error) {
+	if !s.opts.holdsNickName(user) {
+		return sql.NullString{}, false, nil
+	}
+
+	key = sql.NullString{String: nickNameKey(user.NickName), Valid: true}
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE nickname_key = ? AND id != ?)`,
+		key, user.ID).Scan(&shared)
+	switch {
+	case err != nil:
+		return sql.NullString{}, false, err
+	case shared && !share && !s.opts.keepsNickName(old, user):
+		return sql.NullString{}, false, ErrNickNameTaken
+	}
+	return key, shared, nil
+}
+
+// claimNickNames brings nickname_key in line with the nickname policy, a
+// setting that may have changed since the database was last opened: deleted
+// users give their nickname up if they should, and users who should hold
+// theirs get it. Users left sharing a nickname, as data written before
+// nicknames were unique may, keep it.
+func (s *SQLiteStorage) claimNickNames(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, nickname FROM users WHERE nickname_key IS NULL AND nickname != ''`
+	var args []any
+	if s.opts.releaseDeletedNickNames {
+		_, err := tx.ExecContext(ctx, `UPDATE users SET nickname_key = NULL WHERE status = ?`, UserStatusDeleted)
+		if err != nil {
+			return err
+		}
+		query += ` AND status != ?`
+		args = append(args, UserStatusDeleted)
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	var claims [][2]string // id, nickname
+	for rows.Next() {
+		var id, nick string
+		if err := rows.Scan(&id, &nick); err != nil {
+			rows.Close()
+			return err
+		}
+		claims = append(claims, [2]string{id, nick})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range claims {
+		_, err := tx.ExecContext(ctx, `UPDATE users SET nickname_key = ? WHERE id = ?`, nickNameKey(c[1]), c[0])
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// NickNameTaken implements Storage.
+func (s *SQLiteStorage) NickNameTaken(ctx context.Context, nick string) (bool, error) {
+	var taken bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE nickname_key = ?)`, nickNameKey(nick)).Scan(&taken)
+	return taken, err
+}
+
+// insertOutbox queues events within tx.
+func insertOutbox(ctx context.Context, tx *sql.Tx, events []Event) error {
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		entry := newOutboxEntry(event)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO outbox (event_id, event, status, attempts, last_error, created_at, next_attempt_at, delivered_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			event.ID, string(payload), entry.Status, entry.Attempts, entry.LastError,
+			toUnixNano(entry.CreatedAt), toUnixNano(entry.NextAttemptAt), toUnixNano(entry.DeliveredAt),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadUser retrieves a user by ID, whatever its status.
+// Returns ErrNotFound if the user is not found.
+func (s *SQLiteStorage) ReadUser(ctx context.Context, id string) (*User, error) {
+	return readUser(ctx, s.db, id)
+}
+
+// queryer is what *sql.DB and *sql.Tx share for reading.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// readUser reads the user id through q.
+func readUser(ctx context.Context, q queryer, id string) (*User, error) {
+	row := q.QueryRowContext(ctx, `
+		SELECT id, name, address, nickname, status, status_reason, suspended_until, status_before_deletion, deleted_at, created_at, updated_at, version
+		FROM users WHERE id = ?`, id)
+
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ReadUsers pushes the filter and the page down into the query.
+func (s *SQLiteStorage) ReadUsers(ctx context.Context, filter UserFilter, page UserPage) ([]*User, error) {
+	where, args := filterWhere(filter)
+	query := `
+		SELECT id, name, address, nickname, status, status_reason, suspended_until, status_before_deletion, deleted_at, created_at, updated_at, version
+		FROM users WHERE ` + where
+	if page.After != nil {
+		query += ` AND (created_at, id) > (?, ?)`
+		args = append(args, page.After.CreatedAt, page.After.ID)
+	}
+	query += ` ORDER BY created_at, id`
+	if page.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, page.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// storedStatuses returns the stored statuses of the users whose
+// currentStatus may be one of statuses: active users include those whose
+// suspension is over.
+func storedStatuses(statuses []string) []string {
+	if slices.Contains(statuses, UserStatusActive) && !slices.Contains(statuses, UserStatusSuspended) {
+		return append(slices.Clone(statuses), UserStatusSuspended)
+	}
+	return statuses
+}
+
+// filterWhere returns the WHERE condition selecting the users filter
+// matches, and its arguments.
+func filterWhere(filter UserFilter) (string, []any) {
+	conds := []string{"1 = 1"}
+	var args []any
+	switch {
+	case len(filter.Statuses) > 0:
+		// The stored status narrows the rows down through idx_users_status
+		// before current_status decides.
+		stored := storedStatuses(filter.Statuses)
+		conds = append(conds, `status IN (?`+strings.Repeat(`, ?`, len(stored)-1)+`)`)
+		for _, st := range stored {
+			args = append(args, st)
+		}
+		conds = append(conds, `current_status(status, suspended_until) IN (?`+strings.Repeat(`, ?`, len(filter.Statuses)-1)+`)`)
+		for _, st := range filter.Statuses {
+			args = append(args, st)
+		}
+	case !filter.IncludeDeleted:
+		conds = append(conds, `status != ?`)
+		args = append(args, UserStatusDeleted)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		conds = append(conds, `created_at >= ?`)
+		args = append(args, toUnixNano(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, toUnixNano(filter.CreatedTo))
+	}
+	if !filter.UpdatedFrom.IsZero() {
+		conds = append(conds, `updated_at >= ?`)
+		args = append(args, toUnixNano(filter.UpdatedFrom))
+	}
+	if !filter.UpdatedTo.IsZero() {
+		conds = append(conds, `updated_at < ?`)
+		args = append(args, toUnixNano(filter.UpdatedTo))
+	}
+	if !filter.DeletedTo.IsZero() {
+		conds = append(conds, `deleted_at > 0 AND deleted_at < ?`)
+		args = append(args, toUnixNano(filter.DeletedTo))
+	}
+	if filter.NickNamePrefix != "" {
+		conds = append(conds, `instr(fold(nickname), ?) = 1`)
+		args = append(args, fold(filter.NickNamePrefix))
+	}
+	if filter.Search != "" {
+		search := fold(filter.Search)
+		conds = append(conds, `(instr(fold(name), ?) > 0 OR instr(fold(nickname), ?) > 0)`)
+		args = append(args, search, search)
+	}
+	if filter.Where != nil {
+		cond, condArgs := filter.Where.sql()
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Delete physically removes a user by ID, checking the version and
+// removing the row in one statement.
+// Returns ErrNotFound if the user does not exist.
+func (s *SQLiteStorage) Delete(ctx context.Context, id string, expectedVersion int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ? AND (? = 0 OR version = ?)`,
+		id, expectedVersion, expectedVersion)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// Nothing matched: tell why.
+		if _, err := readUser(ctx, tx, id); err != nil {
+			return err
+		}
+		return ErrVersionConflict
+	}
+	return tx.Commit()
+}
+
+// ReadOutbox uses the (status, next_attempt_at) index when filtering by
+// status.
+func (s *SQLiteStorage) ReadOutbox(ctx context.Context, filter OutboxFilter) ([]*OutboxEntry, error) {
+	query := `SELECT ` + outboxColumns + ` FROM outbox WHERE 1 = 1`
+	var args []any
+	if filter.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, filter.Status)
+	}
+	if !filter.DueBy.IsZero() {
+		query += ` AND next_attempt_at <= ?`
+		args = append(args, toUnixNano(filter.DueBy))
+	}
+	query += ` ORDER BY seq`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*OutboxEntry{}
+	for rows.Next() {
+		entry, err := scanOutboxEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// ReadOutboxEntry implements OutboxStorage.
+func (s *SQLiteStorage) ReadOutboxEntry(ctx context.Context, seq int64) (*OutboxEntry, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+outboxColumns+` FROM outbox WHERE seq = ?`, seq)
+	entry, err := scanOutboxEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOutboxEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// UpdateOutboxEntry implements OutboxStorage.
+func (s *SQLiteStorage) UpdateOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, delivered_at = ?
+		WHERE seq = ?`,
+		entry.Status, entry.Attempts, entry.LastError,
+		toUnixNano(entry.NextAttemptAt), toUnixNano(entry.DeliveredAt), entry.Seq,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOutboxEntryNotFound
+	}
+	return nil
+}
+
+// outboxColumns is the column list scanOutboxEntry expects.
+const outboxColumns = `seq, event, status, attempts, last_error, created_at, next_attempt_at, delivered_at`
+
+func scanOutboxEntry(r rowScanner) (*OutboxEntry, error) {
+	var (
+		entry                               OutboxEntry
+		event                               string
+		createdAt, nextAttempt, deliveredAt int64
+	)
+	err := r.Scan(&entry.Seq, &event, &entry.Status, &entry.Attempts, &entry.LastError,
+		&createdAt, &nextAttempt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(event), &entry.Event); err != nil {
+		return nil, fmt.Errorf("error decoding outbox entry %d: %w", entry.Seq, err)
+	}
+	entry.CreatedAt = fromUnixNano(createdAt)
+	entry.NextAttemptAt = fromUnixNano(nextAttempt)
+	entry.DeliveredAt = fromUnixNano(deliveredAt)
+	return &entry, nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(r rowScanner) (*User, error) {
+	var (
+		user                                            User
+		suspendedUntil, deletedAt, createdAt, updatedAt int64
+	)
+	err := r.Scan(&user.ID, &user.Name, &user.Address, &user.NickName, &user.Status, &user.StatusReason,
+		&suspendedUntil, &user.StatusBeforeDeletion, &deletedAt, &createdAt, &updatedAt, &user.Version)
+	if err != nil {
+		return nil, err
+	}
+	user.SuspendedUntil = fromUnixNano(suspendedUntil)
+	user.DeletedAt = fromUnixNano(deletedAt)
+	user.CreatedAt = fromUnixNano(createdAt)
+	user.UpdatedAt = fromUnixNano(updatedAt)
+	return &user, nil
+}
+
+// toUnixNano stores the zero time as 0 so it survives a round trip.
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -1,6 +1,11 @@
 package user
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
 
 // ErrNotFound is returned when a user with the given ID is not found.
 var ErrNotFound = errors.New("user not found")
@@ -9,54 +14,326 @@ var ErrNotFoundSale = errors.New("sale not found")
 // ErrEmptyID is returned when trying to store a user with an empty ID.
 var ErrEmptyID = errors.New("empty user ID")
 
+// ErrVersionConflict is returned when a user changed since it was read.
+var ErrVersionConflict = errors.New("version conflict")
+
+// ErrNickNameTaken is returned when writing a user whose nickname another
+// user already holds, ignoring case.
+var ErrNickNameTaken = errors.New("nickname already taken")
+
+// StorageOption configures a Storage backend.
+type StorageOption func(*storageOptions)
+
+type storageOptions struct {
+	releaseDeletedNickNames bool
+}
+
+// WithReleaseDeletedNickNames sets whether a deleted user gives up their
+// nickname, so another user can take it. By default they keep it.
+func WithReleaseDeletedNickNames(release bool) StorageOption {
+	return func(o *storageOptions) { o.releaseDeletedNickNames = release }
+}
+
+func newStorageOptions(opts []StorageOption) storageOptions {
+	var o storageOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// holdsNickName reports whether user keeps their nickname from others.
+func (o storageOptions) holdsNickName(user *User) bool {
+	return user.NickName != "" && !(o.releaseDeletedNickNames && user.Status == UserStatusDeleted)
+}
+
+// keepsNickName reports whether storing user over old, nil for a new user,
+// leaves them holding the nickname they held, ignoring case. Such a write
+// is never refused for the nickname, even if it is shared, as data written
+// before nicknames were unique may be.
+func (o storageOptions) keepsNickName(old, user *User) bool {
+	return old != nil && o.holdsNickName(old) && o.holdsNickName(user) &&
+		nickNameKey(old.NickName) == nickNameKey(user.NickName)
+}
+
+// nickNameKey is what nicknames are compared by: they ignore case.
+func nickNameKey(nick string) string {
+	return strings.ToLower(nick)
+}
+
 // Storage is the main interface for our storage layer.
+// Every method gives up with the context's error once ctx is done.
+//
+// No two users hold the same nickname, ignoring case: the write methods
+// return ErrNickNameTaken rather than let a user take up one another user
+// holds. Deleted users hold theirs unless WithReleaseDeletedNickNames says
+// otherwise. Users imported with ImportUser may share a nickname; each keeps
+// it for as long as they do not change it, and nobody else can take it up
+// while any of them holds it.
+//
+// The write methods queue the given events in the outbox in the same atomic
+// step as the user itself, so an event is sent if and only if its change
+// was stored.
 type Storage interface {
-	SetUser(user *User) error
-	ReadUser(id string) (*User, error)
-	Delete(id string) error
+	SetUser(ctx context.Context, user *User, events ...Event) error
+	ReadUser(ctx context.Context, id string) (*User, error)
+
+	// Delete removes a user for good. If expectedVersion is not zero, only
+	// that version of the user is removed and ErrVersionConflict is returned
+	// otherwise.
+	Delete(ctx context.Context, id string, expectedVersion int) error
+
+	// NickNameTaken reports whether a user holds nick, ignoring case.
+	NickNameTaken(ctx context.Context, nick string) (bool, error)
+
+	// ImportUser stores user like SetUser, but lets them share a nickname
+	// another user holds instead of failing, so data written before
+	// nicknames were unique can be brought in. It reports whether the
+	// nickname is shared.
+	ImportUser(ctx context.Context, user *User) (shared bool, err error)
+
+	// ReadUsers returns the page of the users matching filter, oldest
+	// first, ties broken by ID.
+	ReadUsers(ctx context.Context, filter UserFilter, page UserPage) ([]*User, error)
+
+	// CompareAndSetUser replaces an existing user only if its stored version
+	// is still expectedVersion. Returns ErrNotFound if the user does not
+	// exist and ErrVersionConflict if someone else wrote it first.
+	CompareAndSetUser(ctx context.Context, user *User, expectedVersion int, events ...Event) error
+
+	OutboxStorage
 }
 
-// LocalStorage provides an in-memory implementation for storing users.
+// LocalStorage provides a concurrency-safe in-memory implementation for
+// storing users. Users are copied on the way in and out, so a caller holding
+// a user cannot change the stored one behind the storage's back. Only the
+// latest delivered outbox entries are kept.
 type LocalStorage struct {
-	m map[string]*User
+	opts storageOptions
+
+	mu        sync.RWMutex
+	m         map[string]*User
+	nickNames map[string]map[string]bool // nickNameKey -> IDs of the users holding it
+
+	outbox *localOutbox
 }
 
 // NewLocalStorage instantiates a new LocalStorage with an empty map.
-func NewLocalStorage() *LocalStorage {
+func NewLocalStorage(opts ...StorageOption) *LocalStorage {
 	return &LocalStorage{
-		m: map[string]*User{},
+		opts:      newStorageOptions(opts),
+		m:         map[string]*User{},
+		nickNames: map[string]map[string]bool{},
+		outbox:    newLocalOutbox(),
 	}
 }
 
 // Set stores or updates a user in the local storage.
 // Returns ErrEmptyID if the user has an empty ID.
-func (l *LocalStorage) SetUser(user *User) error {
+func (l *LocalStorage) SetUser(ctx context.Context, user *User, events ...Event) error {
+	_, err := l.setUser(ctx, user, false, events)
+	return err
+}
+
+// ImportUser implements Storage.
+func (l *LocalStorage) ImportUser(ctx context.Context, user *User) (bool, error) {
+	return l.setUser(ctx, user, true, nil)
+}
+
+// setUser stores user, letting them share a nickname if share is set.
+func (l *LocalStorage) setUser(ctx context.Context, user *User, share bool, events []Event) (shared bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	if user.ID == "" {
-		return ErrEmptyID
+		return false, ErrEmptyID
+	}
+
+	stored := *user
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	shared, err = l.claimNickNameLocked(l.m[user.ID], &stored, share)
+	if err != nil {
+		return false, err
+	}
+
+	l.m[user.ID] = &stored
+	l.appendOutboxLocked(events)
+	return shared, nil
+}
+
+// CompareAndSetUser swaps the user under the write lock, so two concurrent
+// updates of the same version cannot both succeed.
+func (l *LocalStorage) CompareAndSetUser(ctx context.Context, user *User, expectedVersion int, events ...Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stored := *user
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old, ok := l.m[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	if _, err := l.claimNickNameLocked(old, &stored, false); err != nil {
+		return err
 	}
 
-	l.m[user.ID] = user
+	l.m[user.ID] = &stored
+	l.appendOutboxLocked(events)
 	return nil
 }
 
+// claimNickNameLocked keeps the nickname index in step with storing user
+// over old, nil for a new user. A user taking up a nickname another user
+// holds gets ErrNickNameTaken, unless share is set, in which case they share
+// it and shared is true. Callers hold l.mu.
+func (l *LocalStorage) claimNickNameLocked(old, user *User, share bool) (shared bool, err error) {
+	key := nickNameKey(user.NickName)
+	if l.opts.holdsNickName(user) {
+		holders := l.nickNames[key]
+		shared = len(holders) > 1 || len(holders) == 1 && !holders[user.ID]
+		if shared && !share && !l.opts.keepsNickName(old, user) {
+			return false, ErrNickNameTaken
+		}
+	}
+
+	if old != nil {
+		l.releaseNickNameLocked(old)
+	}
+	if l.opts.holdsNickName(user) {
+		if l.nickNames[key] == nil {
+			l.nickNames[key] = map[string]bool{}
+		}
+		l.nickNames[key][user.ID] = true
+	}
+	return shared, nil
+}
+
+// releaseNickNameLocked drops user from the nickname index, if they held
+// one. Callers hold l.mu.
+func (l *LocalStorage) releaseNickNameLocked(user *User) {
+	key := nickNameKey(user.NickName)
+	delete(l.nickNames[key], user.ID)
+	if len(l.nickNames[key]) == 0 {
+		delete(l.nickNames, key)
+	}
+}
+
+// NickNameTaken implements Storage.
+func (l *LocalStorage) NickNameTaken(ctx context.Context, nick string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return len(l.nickNames[nickNameKey(nick)]) > 0, nil
+}
+
+// appendOutboxLocked queues events. Callers hold l.mu.
+func (l *LocalStorage) appendOutboxLocked(events []Event) {
+	for _, event := range events {
+		l.outbox.add(event)
+	}
+}
+
 // Read retrieves a user from the local storage by ID.
 // Returns ErrNotFound if the user is not found.
-func (l *LocalStorage) ReadUser(id string) (*User, error) {
+func (l *LocalStorage) ReadUser(ctx context.Context, id string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	u, ok := l.m[id]
 	if !ok {
 		return nil, ErrNotFound
 	}
-	return u, nil
+	user := *u
+	return &user, nil
+}
+
+// ReadUsers walks every user; users are few enough not to need indexes.
+func (l *LocalStorage) ReadUsers(ctx context.Context, filter UserFilter, page UserPage) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	users := []*User{}
+	for _, stored := range l.m {
+		if filter.matches(stored) {
+			user := *stored
+			users = append(users, &user)
+		}
+	}
+	return paginate(users, page), nil
 }
 
 // Delete removes a user from the local storage by ID.
 // Returns ErrNotFound if the user does not exist.
-func (l *LocalStorage) Delete(id string) error {
-	_, err := l.ReadUser(id)
-	if err != nil {
+func (l *LocalStorage) Delete(ctx context.Context, id string, expectedVersion int) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	user, ok := l.m[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if expectedVersion != 0 && user.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+
+	l.releaseNickNameLocked(user)
 	delete(l.m, id)
 	return nil
 }
+
+// ReadOutbox implements OutboxStorage.
+func (l *LocalStorage) ReadOutbox(ctx context.Context, filter OutboxFilter) ([]*OutboxEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.outbox.read(filter), nil
+}
+
+// ReadOutboxEntry implements OutboxStorage.
+func (l *LocalStorage) ReadOutboxEntry(ctx context.Context, seq int64) (*OutboxEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.outbox.get(seq)
+}
+
+// UpdateOutboxEntry implements OutboxStorage.
+func (l *LocalStorage) UpdateOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.outbox.update(entry)
+}
@@ -1,17 +1,102 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 	"users-api/api"
+	"users-api/internal/user"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	r := gin.Default()
-	api.InitRoutes(r)
 
-	if err := r.Run(":8080"); err != nil {
-		panic(fmt.Errorf("error trying to start server: %v", err))
+	cfg := api.Config{
+		Storage:    getEnv("USERS_STORAGE", api.StorageMemory),
+		SQLitePath: getEnv("USERS_SQLITE_PATH", "users.db"),
+
+		RequestTimeout: getDurationEnv("USERS_REQUEST_TIMEOUT", 10*time.Second),
+
+		ReleaseDeletedNickNames: getBoolEnv("USERS_RELEASE_DELETED_NICKNAMES", false),
+
+		Purge: user.PurgeConfig{
+			Interval:  getDurationEnv("USERS_PURGE_INTERVAL", user.DefaultPurgeConfig().Interval),
+			Retention: getDurationEnv("USERS_DELETED_RETENTION", user.DefaultPurgeConfig().Retention),
+		},
+
+		EventsWebhookURL: getEnv("USERS_EVENTS_WEBHOOK_URL", ""),
+		Outbox: user.DispatcherConfig{
+			Interval: getDurationEnv("USERS_OUTBOX_INTERVAL", user.DefaultDispatcherConfig().Interval),
+		},
+	}
+	// ctx is done on SIGINT or SIGTERM, which stops the background workers.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := api.InitRoutes(ctx, r, cfg); err != nil {
+		panic(fmt.Errorf("error trying to init routes: %v", err))
+	}
+
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(fmt.Errorf("error trying to start server: %v", err))
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "error trying to stop server: %v\n", err)
+	}
+}
+
+// shutdownTimeout is how long the requests in flight get to finish once the
+// server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+// getEnv returns the value of the environment variable key, or def if unset.
+func getEnv(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// getDurationEnv parses the environment variable key as a time.Duration,
+// falling back to def if it is unset.
+func getDurationEnv(key string, def time.Duration) time.Duration {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic(fmt.Errorf("invalid duration in %s: %v", key, err))
+	}
+	return d
+}
+
+// getBoolEnv parses the environment variable key as a bool, falling back to
+// def if it is unset.
+func getBoolEnv(key string, def bool) bool {
+	v := getEnv(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		panic(fmt.Errorf("invalid boolean in %s: %v", key, err))
 	}
+	return b
 }
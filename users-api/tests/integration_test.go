@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 	"users-api/api"
 	"users-api/internal/user"
 
@@ -15,7 +17,7 @@ import (
 
 func TestIntegrationCreateAndGet(t *testing.T) {
 	app := gin.Default()
-	api.InitRoutes(app)
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{}))
 
 	req, _ := http.NewRequest(http.MethodGet, "/ping", nil)
 	res := fakeRequest(app, req)
@@ -51,6 +53,262 @@ func TestIntegrationCreateAndGet(t *testing.T) {
 
 	require.NotNil(t, res)
 	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, `"1"`, res.Header().Get("ETag"))
+
+	req, _ = http.NewRequest(http.MethodPatch, "/users/"+resUser.ID, bytes.NewBufferString(`{"name":"Senna"}`))
+	req.Header.Set("If-Match", `"1"`)
+
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, `"2"`, res.Header().Get("ETag"))
+
+	// A client still holding version 1 must not overwrite version 2.
+	req, _ = http.NewRequest(http.MethodPatch, "/users/"+resUser.ID, bytes.NewBufferString(`{"name":"Ayrton"}`))
+	req.Header.Set("If-Match", `"1"`)
+
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusPreconditionFailed, res.Code)
+
+	req, _ = http.NewRequest(http.MethodDelete, "/users/"+resUser.ID, nil)
+	req.Header.Set("If-Match", `"1"`)
+
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusPreconditionFailed, res.Code)
+
+	req, _ = http.NewRequest(http.MethodDelete, "/users/"+resUser.ID, nil)
+	req.Header.Set("If-Match", `"2"`)
+
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusNoContent, res.Code)
+}
+
+func TestIntegrationOutbox(t *testing.T) {
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{
+		// Keep the dispatcher out of the way; entries stay pending.
+		Outbox: user.DispatcherConfig{Interval: time.Hour},
+	}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"name":"Ayrton","address":"Pringles","nickname":"Chiche"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, "/admin/outbox?status=pending", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	var page struct {
+		Results []*user.OutboxEntry `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &page))
+	require.Len(t, page.Results, 1)
+	require.Equal(t, user.EventUserCreated, page.Results[0].Event.Type)
+
+	req, _ = http.NewRequest(http.MethodPost, "/admin/outbox/1/replay", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"status":"pending"`)
+
+	req, _ = http.NewRequest(http.MethodGet, "/admin/outbox/2", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusNotFound, res.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, "/admin/outbox?status=lost", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestIntegrationListUsers(t *testing.T) {
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{}))
+
+	for _, body := range []string{
+		`{"name":"Ayrton","address":"Pringles","nickname":"Chiche"}`,
+		`{"name":"Juan","address":"Balcarce","nickname":"Chueco"}`,
+	} {
+		req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+		res := fakeRequest(app, req)
+		require.Equal(t, http.StatusCreated, res.Code)
+	}
+
+	query := url.Values{"filter": {`nickname in ("Chueco", "Fangio") and created_at >= "2020-01-01"`}}
+	req, _ := http.NewRequest(http.MethodGet, "/users?"+query.Encode(), nil)
+	res := fakeRequest(app, req)
+
+	require.Equal(t, http.StatusOK, res.Code)
+	var list struct {
+		Results []user.User `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &list))
+	require.Len(t, list.Results, 1)
+	require.Equal(t, "Juan", list.Results[0].Name)
+
+	query = url.Values{"filter": {`nickname = "Chueco" or`}}
+	req, _ = http.NewRequest(http.MethodGet, "/users?"+query.Encode(), nil)
+	res = fakeRequest(app, req)
+
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	require.JSONEq(t, `{"error":"invalid input: filter: expected a field name, got end of filter at position 23","position":23}`,
+		res.Body.String())
+
+	req, _ = http.NewRequest(http.MethodGet, "/users?q=CHIC&nickname_prefix=ch&limit=1", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	var page struct {
+		Results    []user.User `json:"results"`
+		NextCursor string      `json:"next_cursor"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &page))
+	require.Len(t, page.Results, 1)
+	require.Equal(t, "Ayrton", page.Results[0].Name)
+	require.Empty(t, page.NextCursor)
+
+	req, _ = http.NewRequest(http.MethodGet, "/users?limit=1", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &page))
+	require.Equal(t, "Ayrton", page.Results[0].Name)
+	require.NotEmpty(t, page.NextCursor)
+
+	req, _ = http.NewRequest(http.MethodGet, "/users?limit=1&cursor="+page.NextCursor, nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	page.NextCursor = ""
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &page))
+	require.Equal(t, "Juan", page.Results[0].Name)
+	require.Empty(t, page.NextCursor)
+
+	for _, query := range []string{"status=banned", "created_from=ayer", "limit=0x", "cursor=%21"} {
+		req, _ = http.NewRequest(http.MethodGet, "/users?"+query, nil)
+		res = fakeRequest(app, req)
+		require.Equal(t, http.StatusBadRequest, res.Code, query)
+	}
+}
+
+func TestIntegrationUniqueNickName(t *testing.T) {
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"name":"Ayrton","address":"Pringles","nickname":"Chiche"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"name":"Otro","address":"Lobos","nickname":"chiche"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusConflict, res.Code)
+	require.JSONEq(t, `{"error":"nickname already taken"}`, res.Body.String())
+
+	req, _ = http.NewRequest(http.MethodGet, "/users/nicknames/CHICHE/availability", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `{"nickname":"CHICHE","available":false,"suggestions":["CHICHEReal","TheCHICHE","CHICHEOficial"]}`,
+		res.Body.String())
+
+	req, _ = http.NewRequest(http.MethodGet, "/users/nicknames/Fangio/availability", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `{"nickname":"Fangio","available":true}`, res.Body.String())
+
+	req, _ = http.NewRequest(http.MethodGet, "/users/nicknames/F4ngio/availability", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestIntegrationRestoreUser(t *testing.T) {
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"name":"Ayrton","address":"Pringles","nickname":"Chiche"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+	var created user.User
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+
+	req, _ = http.NewRequest(http.MethodPost, "/users/"+created.ID+"/restore", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusConflict, res.Code)
+
+	req, _ = http.NewRequest(http.MethodDelete, "/users/"+created.ID, nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusNoContent, res.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/users/"+created.ID+"/restore", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, `"3"`, res.Header().Get("ETag"))
+	var restored user.User
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &restored))
+	require.Equal(t, user.UserStatusActive, restored.Status)
+	require.Equal(t, 3, restored.Version)
+
+	req, _ = http.NewRequest(http.MethodGet, "/users/"+created.ID, nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/users/missing/restore", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusNotFound, res.Code)
+
+	// The purge runs hourly, so there is nothing to report yet.
+	req, _ = http.NewRequest(http.MethodGet, "/admin/users/purge", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestIntegrationUserLifecycle(t *testing.T) {
+	app := gin.Default()
+	require.NoError(t, api.InitRoutes(t.Context(), app, api.Config{}))
+
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"name":"Ayrton","address":"Pringles","nickname":"Chiche"}`))
+	res := fakeRequest(app, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+	var created user.User
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+
+	until := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	req, _ = http.NewRequest(http.MethodPost, "/admin/users/"+created.ID+"/suspend",
+		bytes.NewBufferString(`{"reason":"chargeback","until":"`+until+`"}`))
+	req.Header.Set("If-Match", `"1"`)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, `"2"`, res.Header().Get("ETag"))
+
+	req, _ = http.NewRequest(http.MethodGet, "/users/"+created.ID, nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"status":"suspended"`)
+	require.Contains(t, res.Body.String(), `"status_reason":"chargeback"`)
+	require.Contains(t, res.Body.String(), `"suspended_until":"`+until+`"`)
+
+	req, _ = http.NewRequest(http.MethodPost, "/admin/users/"+created.ID+"/block", bytes.NewBufferString(`{}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, "/admin/users/"+created.ID+"/block", bytes.NewBufferString(`{"reason":"stolen card"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"status":"blocked"`)
+
+	req, _ = http.NewRequest(http.MethodPost, "/admin/users/"+created.ID+"/block", bytes.NewBufferString(`{"reason":"again"}`))
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusConflict, res.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, "/users?status=blocked", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), created.ID)
+
+	req, _ = http.NewRequest(http.MethodPost, "/admin/users/"+created.ID+"/reactivate", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Contains(t, res.Body.String(), `"status":"active"`)
+
+	req, _ = http.NewRequest(http.MethodPost, "/admin/users/missing/reactivate", nil)
+	res = fakeRequest(app, req)
+	require.Equal(t, http.StatusNotFound, res.Code)
 }
 
 func fakeRequest(e *gin.Engine, r *http.Request) *httptest.ResponseRecorder {